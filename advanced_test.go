@@ -2,7 +2,12 @@ package compressfs
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 // TestAlgorithmRules tests file-specific algorithm selection
@@ -53,6 +58,40 @@ func TestAlgorithmRules(t *testing.T) {
 	}
 }
 
+// TestAlgorithmRuleLevelZero tests that a rule's explicit level 0 is used
+// when the file is written, rather than the configured or auto-tuned level
+func TestAlgorithmRuleLevelZero(t *testing.T) {
+	memfs := NewMemFS()
+	fs, err := New(memfs, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+		EnableAutoTuning:  true,
+		AlgorithmRules: []AlgorithmRule{
+			{Pattern: ".log$", Algorithm: AlgorithmGzip, Level: 0},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create FS: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("line of a highly compressible log\n"), 2000)
+	writeTestFile(t, fs, "app.log", data)
+	writeTestFile(t, fs, "app.txt", data)
+
+	// Gzip level 0 stores the data in uncompressed deflate blocks
+	if stored := readBaseFile(t, memfs, "app.log.gz"); len(stored) < len(data) {
+		t.Errorf("Rule level 0 stored %d bytes of %d, expected them uncompressed", len(stored), len(data))
+	}
+	if stored := readBaseFile(t, memfs, "app.txt.gz"); len(stored) >= len(data)/10 {
+		t.Errorf("File without a rule stored %d bytes of %d, expected it compressed", len(stored), len(data))
+	}
+	if got := readTestFile(t, fs, "app.log"); !bytes.Equal(got, data) {
+		t.Error("Stored file did not round-trip")
+	}
+}
+
 // TestAutoTuning tests compression level auto-tuning based on file size
 func TestAutoTuning(t *testing.T) {
 	memfs := NewMemFS()
@@ -93,6 +132,40 @@ func TestAutoTuning(t *testing.T) {
 	}
 }
 
+// TestAutoTuningStreamed tests that a file written in small chunks reaches
+// the auto-tuning tier of its whole size, not only of the buffered prefix
+func TestAutoTuningStreamed(t *testing.T) {
+	var log []Decision
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:             AlgorithmZstd,
+		Level:                 6,
+		EnableAutoTuning:      true,
+		AutoTuneSizeThreshold: 1024 * 1024,
+		DecisionLog:           &log,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create FS: %v", err)
+	}
+
+	f, err := cfs.Create("large.dat")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	chunk := bytes.Repeat([]byte("streamed in small chunks "), 160)
+	for written := 0; written <= 11*1024*1024; written += len(chunk) {
+		if _, err := f.Write(chunk); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if len(log) != 1 || log[0].Level != 1 {
+		t.Errorf("Expected one decision at the over-10MB level 1, got %+v", log)
+	}
+}
+
 // TestZstdDictionaryCompression tests dictionary-based compression
 func TestZstdDictionaryCompression(t *testing.T) {
 	memfs := NewMemFS()
@@ -350,3 +423,177 @@ func TestAutoTuningWithDifferentAlgorithms(t *testing.T) {
 		})
 	}
 }
+
+// buildTestDictionary builds a small zstd dictionary with the given ID
+func buildTestDictionary(t *testing.T, id uint32, word string) []byte {
+	t.Helper()
+	var samples [][]byte
+	for i := 0; i < 32; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`{"id":%d,"kind":"%s","payload":"%s %s %s"}`, i, word, word, word, word)))
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: samples,
+		History:  bytes.Repeat([]byte(word+" history "), 64),
+		Offsets:  [3]int{1, 4, 8},
+	})
+	if err != nil {
+		t.Fatalf("BuildDict failed: %v", err)
+	}
+	return dict
+}
+
+// TestZstdDictionaryMismatch tests reading frames written with another dictionary
+func TestZstdDictionaryMismatch(t *testing.T) {
+	dictA := buildTestDictionary(t, 1001, "alpha")
+	dictB := buildTestDictionary(t, 2002, "bravo")
+
+	memfs := NewMemFS()
+	writer, err := New(memfs, &Config{
+		Algorithm:         AlgorithmZstd,
+		Level:             3,
+		ZstdDictionary:    dictA,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create FS: %v", err)
+	}
+	data := []byte(`{"id":7,"kind":"alpha","payload":"alpha alpha alpha"}`)
+	writeTestFile(t, writer, "record.json", data)
+
+	// Reading with only the wrong dictionary reports a clear error
+	mismatched, _ := New(memfs, &Config{
+		Algorithm:         AlgorithmZstd,
+		ZstdDictionary:    dictB,
+		AutoDetect:        true,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	_, err = mismatched.Open("record.json")
+	if !errors.Is(err, ErrCorruptedData) || !strings.Contains(err.Error(), "dictionary mismatch") {
+		t.Errorf("Expected dictionary mismatch error, got %v", err)
+	}
+
+	// The right dictionary is picked from Dictionaries by ID
+	withMap, _ := New(memfs, &Config{
+		Algorithm:         AlgorithmZstd,
+		ZstdDictionary:    dictB,
+		Dictionaries:      map[uint32][]byte{1001: dictA},
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if got := readTestFile(t, withMap, "record.json"); !bytes.Equal(got, data) {
+		t.Errorf("Expected %q, got %q", data, got)
+	}
+}
+
+// TestZstdCustomOptions tests that caller-supplied zstd options are applied
+func TestZstdCustomOptions(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:          AlgorithmZstd,
+		Level:              3,
+		PreserveExtension:  true,
+		StripExtension:     true,
+		ZstdEncoderOptions: []zstd.EOption{zstd.WithWindowSize(1 << 20), zstd.WithEncoderCRC(false)},
+		ZstdDecoderOptions: []zstd.DOption{zstd.WithDecoderMaxWindow(1 << 20)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("custom window size "), 5000)
+	writeTestFile(t, cfs, "window.txt", data)
+
+	stored := readBaseFile(t, base, "window.txt.zst")
+	var header zstd.Header
+	if err := header.Decode(stored); err != nil {
+		t.Fatalf("DecodeHeader failed: %v", err)
+	}
+	if header.HasCheckSum {
+		t.Error("Expected encoder option to disable the frame checksum")
+	}
+	if header.WindowSize > 1<<20 {
+		t.Errorf("Expected window of at most 1MB, got %d", header.WindowSize)
+	}
+
+	if got := readTestFile(t, cfs, "window.txt"); !bytes.Equal(got, data) {
+		t.Error("Content did not round-trip")
+	}
+}
+
+// TestZstdContentSizeStat tests that Stat reports the size from the zstd frame header
+func TestZstdContentSizeStat(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmZstd,
+		Level:             3,
+		PreserveExtension: true,
+		StripExtension:    true,
+		ZstdContentSize:   true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	// Larger than one zstd block so the size is only known from the option
+	data := bytes.Repeat([]byte("frame content size "), 20000)
+	writeTestFile(t, cfs, "sized.txt", data)
+
+	var header zstd.Header
+	if err := header.Decode(readBaseFile(t, base, "sized.txt.zst")); err != nil {
+		t.Fatalf("Decode header failed: %v", err)
+	}
+	if !header.HasFCS || header.FrameContentSize != uint64(len(data)) {
+		t.Errorf("Expected frame content size %d, got %v/%d", len(data), header.HasFCS, header.FrameContentSize)
+	}
+
+	f, err := cfs.Open("sized.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	info, err := f.Stat()
+	f.Close()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() != int64(len(data)) {
+		t.Errorf("Expected Stat size %d, got %d", len(data), info.Size())
+	}
+
+	got, err := cfs.ReadFile("sized.txt")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("ReadFile returned %d bytes, want %d", len(got), len(data))
+	}
+}
+
+// TestLevellessRuleLevel tests that rules cannot set a level on level-less algorithms
+func TestLevellessRuleLevel(t *testing.T) {
+	tests := []struct {
+		rule    AlgorithmRule
+		wantErr bool
+	}{
+		{AlgorithmRule{Pattern: `\.tmp$`, Algorithm: AlgorithmSnappy, Level: 5}, true},
+		{AlgorithmRule{Pattern: `\.log$`, Algorithm: AlgorithmLZ4, Level: 9}, true},
+		{AlgorithmRule{Pattern: `\.tmp$`, Algorithm: AlgorithmSnappy, Level: 0}, false},
+		{AlgorithmRule{Pattern: `\.tmp$`, Algorithm: AlgorithmSnappy, Level: -1}, false},
+		{AlgorithmRule{Pattern: `\.txt$`, Algorithm: AlgorithmGzip, Level: 5}, false},
+	}
+
+	for _, tt := range tests {
+		_, err := New(NewMemFS(), &Config{
+			Algorithm:      AlgorithmZstd,
+			AlgorithmRules: []AlgorithmRule{tt.rule},
+		})
+		if tt.wantErr && !errors.Is(err, ErrInvalidLevel) {
+			t.Errorf("%s level %d: expected ErrInvalidLevel, got %v", tt.rule.Algorithm, tt.rule.Level, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("%s level %d: unexpected error %v", tt.rule.Algorithm, tt.rule.Level, err)
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package compressfs
 
 import (
+	"bufio"
+	"compress/bzip2"
 	"compress/gzip"
+	"compress/zlib"
 	"io"
 
 	"github.com/andybalholm/brotli"
 	"github.com/golang/snappy"
 	"github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
 )
 
 // createCompressor creates a compressor for the specified algorithm
@@ -15,8 +19,104 @@ func createCompressor(algo Algorithm, w io.Writer, level int) (io.WriteCloser, e
 	return createCompressorWithDict(algo, w, level, nil)
 }
 
+// newCompressor creates a compressor writing to w for files written through
+// cfs, using the configured dictionary for zstd when one is set and pinning
+// variable header fields when Config.Deterministic is set. Encoders are
+// reused from the pool when one is free and return to it on Close. Zstd
+// writes use the seekable format when Config.Seekable is set. Under
+// Config.MaxCompressMemory the level and zstd window may be downgraded.
+func (cfs *FS) newCompressor(algo Algorithm, level int, w io.Writer) (io.WriteCloser, error) {
+	level, window, err := cfs.fitCompressMemory(algo, normalizeLevel(algo, level))
+	if err != nil {
+		return nil, err
+	}
+	if algo == AlgorithmZstd && cfs.config.Seekable {
+		return cfs.newSeekableCompressor(level, window, w)
+	}
+
+	key := codecKey{algo: algo, level: level}
+	compressor := cfs.codecs.getEncoder(key, w)
+	if compressor == nil {
+		var dict []byte
+		if algo == AlgorithmZstd {
+			dict = cfs.config.ZstdDictionary
+		}
+
+		if opts := cfs.zstdEncoderOptions(window); algo == AlgorithmZstd && len(opts) > 0 {
+			compressor, err = createZstdCompressorWithOptions(w, level, dict, opts)
+		} else {
+			compressor, err = createCompressorWithDict(algo, w, level, dict)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if algo == AlgorithmSnappy && cfs.config.SnappyBlockSize > 0 {
+			compressor = newBlockSizedSnappyWriter(compressor.(*snappy.Writer), cfs.config.SnappyBlockSize)
+		}
+		cfs.incrementStat(&cfs.stats.EncodersCreated)
+	}
+
+	if gz, ok := compressor.(*gzip.Writer); ok && cfs.config.Deterministic {
+		gz.Header = gzip.Header{OS: 255} // zero mtime, no name, unknown OS
+	}
+	return &pooledEncoder{WriteCloser: compressor, pool: &cfs.codecs, key: key}, nil
+}
+
+// newSizedCompressor is newCompressor for input of a known size, recording
+// it in the zstd frame header when Config.ZstdContentSize is set
+func (cfs *FS) newSizedCompressor(algo Algorithm, level int, w io.Writer, size int64) (io.WriteCloser, error) {
+	compressor, err := cfs.newCompressor(algo, level, w)
+	if err != nil {
+		return nil, err
+	}
+	if enc, ok := unwrapEncoder(compressor).(*zstd.Encoder); ok && cfs.config.ZstdContentSize {
+		enc.ResetContentSize(w, size)
+	}
+	return compressor, nil
+}
+
+// newDecompressor creates a decompressor reading from r for files read
+// through cfs, applying Config.ZstdDecoderOptions for zstd. Snappy decoders
+// and zstd decoders without a dictionary are reused from the pool and
+// return to it on Close.
+func (cfs *FS) newDecompressor(algo Algorithm, r io.Reader, dict []byte) (io.ReadCloser, error) {
+	pooled := algo == AlgorithmZstd && len(dict) == 0
+	if pooled {
+		if dec := cfs.codecs.getDecoder(r); dec != nil {
+			return &zstdReadCloser{Decoder: dec, release: cfs.codecs.putDecoder}, nil
+		}
+	}
+	if algo == AlgorithmSnappy {
+		if dec := cfs.codecs.getSnappyDecoder(r); dec != nil {
+			return &snappyReadCloser{Reader: dec, release: cfs.codecs.putSnappyDecoder}, nil
+		}
+	}
+
+	var decompressor io.ReadCloser
+	var err error
+	if algo == AlgorithmZstd && len(cfs.config.ZstdDecoderOptions) > 0 {
+		decompressor, err = createZstdDecompressorWithOptions(r, dict, cfs.config.ZstdDecoderOptions)
+	} else {
+		// Decoders take no level
+		decompressor, err = createDecompressorWithDict(algo, r, 0, dict)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfs.incrementStat(&cfs.stats.DecodersCreated)
+
+	if z, ok := decompressor.(*zstdReadCloser); ok && pooled {
+		z.release = cfs.codecs.putDecoder
+	}
+	if s, ok := decompressor.(*snappyReadCloser); ok {
+		s.release = cfs.codecs.putSnappyDecoder
+	}
+	return decompressor, nil
+}
+
 // createCompressorWithDict creates a compressor with optional dictionary support
 func createCompressorWithDict(algo Algorithm, w io.Writer, level int, dict []byte) (io.WriteCloser, error) {
+	level = normalizeLevel(algo, level)
 	switch algo {
 	case AlgorithmGzip:
 		return createGzipCompressor(w, level)
@@ -28,6 +128,12 @@ func createCompressorWithDict(algo Algorithm, w io.Writer, level int, dict []byt
 		return createBrotliCompressor(w, level)
 	case AlgorithmSnappy:
 		return createSnappyCompressor(w, level)
+	case AlgorithmXz:
+		return createXzCompressor(w, level)
+	case AlgorithmZlib:
+		return createZlibCompressor(w, level)
+	case AlgorithmBzip2:
+		return nil, unsupportedWrite(algo)
 	default:
 		return nil, ErrUnsupportedAlgorithm
 	}
@@ -51,6 +157,12 @@ func createDecompressorWithDict(algo Algorithm, r io.Reader, level int, dict []b
 		return createBrotliDecompressor(r)
 	case AlgorithmSnappy:
 		return createSnappyDecompressor(r)
+	case AlgorithmXz:
+		return createXzDecompressor(r)
+	case AlgorithmZlib:
+		return createZlibDecompressor(r)
+	case AlgorithmBzip2:
+		return createBzip2Decompressor(r)
 	default:
 		return nil, ErrUnsupportedAlgorithm
 	}
@@ -58,11 +170,8 @@ func createDecompressorWithDict(algo Algorithm, r io.Reader, level int, dict []b
 
 // Gzip implementation using standard library
 func createGzipCompressor(w io.Writer, level int) (io.WriteCloser, error) {
-	// Gzip supports levels -2 to 9
+	// Gzip supports levels -2 to 9, as normalizeLevel leaves them
 	// -1 = default, 0 = no compression, 1-9 = compression levels
-	if level < -2 {
-		level = gzip.DefaultCompression
-	}
 	return gzip.NewWriterLevel(w, level)
 }
 
@@ -70,34 +179,37 @@ func createGzipDecompressor(r io.Reader) (io.ReadCloser, error) {
 	return gzip.NewReader(r)
 }
 
+// Zlib (RFC 1950) implementation using standard library. The levels are
+// gzip's, deflate being the codec of both.
+func createZlibCompressor(w io.Writer, level int) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, level)
+}
+
+func createZlibDecompressor(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
 // Zstd implementation using github.com/klauspost/compress/zstd
 func createZstdCompressor(w io.Writer, level int) (io.WriteCloser, error) {
 	return createZstdCompressorWithDict(w, level, nil)
 }
 
 func createZstdCompressorWithDict(w io.Writer, level int, dict []byte) (io.WriteCloser, error) {
-	// Map level to zstd encoder level
-	var encoderLevel zstd.EncoderLevel
-	switch {
-	case level <= 0:
-		encoderLevel = zstd.SpeedFastest
-	case level <= 3:
-		encoderLevel = zstd.SpeedDefault
-	case level <= 6:
-		encoderLevel = zstd.SpeedBetterCompression
-	default:
-		encoderLevel = zstd.SpeedBestCompression
-	}
+	return createZstdCompressorWithOptions(w, level, dict, nil)
+}
 
+// createZstdCompressorWithOptions creates a zstd compressor, applying extra
+// after the options derived from level and dict so they take precedence
+func createZstdCompressorWithOptions(w io.Writer, level int, dict []byte, extra []zstd.EOption) (io.WriteCloser, error) {
 	// Build encoder options
-	opts := []zstd.EOption{zstd.WithEncoderLevel(encoderLevel)}
+	opts := []zstd.EOption{zstd.WithEncoderLevel(zstdEncoderLevel(level))}
 
 	// Add dictionary if provided and valid
 	// Note: Dictionary must be in the format produced by "zstd --train" or BuildDict
 	// If the dictionary is invalid, we'll try without it
 	if len(dict) > 0 {
 		// Try to create with dictionary first
-		optsWithDict := append(opts, zstd.WithEncoderDict(dict))
+		optsWithDict := append(append(opts, zstd.WithEncoderDict(dict)), extra...)
 		writer, err := zstd.NewWriter(w, optsWithDict...)
 		if err == nil {
 			return writer, nil
@@ -105,7 +217,7 @@ func createZstdCompressorWithDict(w io.Writer, level int, dict []byte) (io.Write
 		// If dictionary fails, fall back to no dictionary
 	}
 
-	return zstd.NewWriter(w, opts...)
+	return zstd.NewWriter(w, append(opts, extra...)...)
 }
 
 func createZstdDecompressor(r io.Reader) (io.ReadCloser, error) {
@@ -113,13 +225,19 @@ func createZstdDecompressor(r io.Reader) (io.ReadCloser, error) {
 }
 
 func createZstdDecompressorWithDict(r io.Reader, dict []byte) (io.ReadCloser, error) {
+	return createZstdDecompressorWithOptions(r, dict, nil)
+}
+
+// createZstdDecompressorWithOptions creates a zstd decompressor, applying
+// extra after the dictionary option so they take precedence
+func createZstdDecompressorWithOptions(r io.Reader, dict []byte, extra []zstd.DOption) (io.ReadCloser, error) {
 	// Build decoder options
 	opts := []zstd.DOption{}
 
 	// Add dictionary if provided and try to decode
 	// If dictionary is invalid, fall back to no dictionary
 	if len(dict) > 0 {
-		optsWithDict := append(opts, zstd.WithDecoderDicts(dict))
+		optsWithDict := append(append(opts, zstd.WithDecoderDicts(dict)), extra...)
 		decoder, err := zstd.NewReader(r, optsWithDict...)
 		if err == nil {
 			return &zstdReadCloser{Decoder: decoder}, nil
@@ -127,19 +245,31 @@ func createZstdDecompressorWithDict(r io.Reader, dict []byte) (io.ReadCloser, er
 		// If dictionary fails, fall back to no dictionary
 	}
 
-	decoder, err := zstd.NewReader(r, opts...)
+	decoder, err := zstd.NewReader(r, append(opts, extra...)...)
 	if err != nil {
 		return nil, err
 	}
 	return &zstdReadCloser{Decoder: decoder}, nil
 }
 
-// zstdReadCloser wraps zstd.Decoder to implement io.ReadCloser
+// zstdReadCloser wraps zstd.Decoder to implement io.ReadCloser. When release
+// is set, Close hands the decoder back for reuse instead of closing it.
 type zstdReadCloser struct {
 	*zstd.Decoder
+	release func(*zstd.Decoder)
+	closed  bool
 }
 
 func (r *zstdReadCloser) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if r.release != nil {
+		r.release(r.Decoder)
+		return nil
+	}
 	r.Decoder.Close()
 	return nil
 }
@@ -190,6 +320,112 @@ func createSnappyCompressor(w io.Writer, level int) (io.WriteCloser, error) {
 	return snappy.NewBufferedWriter(w), nil
 }
 
+// snappyBlockMaxSize caps the decoded size of a snappy block that is
+// probed for, as a block is decoded whole in memory
+const snappyBlockMaxSize = 64 << 20
+
+// decodeSnappyBlock decodes data as one snappy block and reports whether
+// it is one: a non-empty block no larger than snappyBlockMaxSize decoded,
+// no longer than snappy could have encoded it, that decodes without error
+func decodeSnappyBlock(data []byte) ([]byte, bool) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil || n == 0 || n > snappyBlockMaxSize || len(data) > snappy.MaxEncodedLen(n) {
+		return nil, false
+	}
+	decoded, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// blockSizedSnappyWriter gathers writes into blocks of a configured size in
+// front of a framed snappy writer. The framing format caps each chunk at
+// 64KB of input and golang/snappy offers no way to change its own buffer,
+// so larger blocks only batch work into fewer encoder calls.
+type blockSizedSnappyWriter struct {
+	*bufio.Writer
+	snappy *snappy.Writer
+}
+
+func newBlockSizedSnappyWriter(w *snappy.Writer, size int) *blockSizedSnappyWriter {
+	return &blockSizedSnappyWriter{Writer: bufio.NewWriterSize(w, size), snappy: w}
+}
+
+func (w *blockSizedSnappyWriter) Close() error {
+	if err := w.Writer.Flush(); err != nil {
+		return err
+	}
+	return w.snappy.Close()
+}
+
+// Flush encodes the gathered block and flushes it through the framed writer
+func (w *blockSizedSnappyWriter) Flush() error {
+	if err := w.Writer.Flush(); err != nil {
+		return err
+	}
+	return w.snappy.Flush()
+}
+
+// Reset discards buffered data and writes to dst from now on
+func (w *blockSizedSnappyWriter) Reset(dst io.Writer) {
+	w.snappy.Reset(dst)
+	w.Writer.Reset(w.snappy)
+}
+
 func createSnappyDecompressor(r io.Reader) (io.ReadCloser, error) {
-	return io.NopCloser(snappy.NewReader(r)), nil
+	return &snappyReadCloser{Reader: snappy.NewReader(r)}, nil
+}
+
+// snappyReadCloser gives the framed snappy reader, which has no Close of
+// its own, one that hands it back to the pool when release is set
+type snappyReadCloser struct {
+	*snappy.Reader
+	release func(*snappy.Reader)
+	closed  bool
+}
+
+func (r *snappyReadCloser) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if r.release != nil {
+		r.release(r.Reader)
+	}
+	return nil
+}
+
+// xzDictCaps maps xz levels 0-9 to the dictionary sizes of the xz tool's
+// presets. github.com/ulikunitz/xz has no presets of its own; the
+// dictionary is what the presets mostly differ by.
+var xzDictCaps = [10]int{
+	256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20,
+	8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20,
+}
+
+// Xz implementation using github.com/ulikunitz/xz
+func createXzCompressor(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < 0 || level >= len(xzDictCaps) {
+		level = 6
+	}
+	zw, err := xz.WriterConfig{DictCap: xzDictCaps[level]}.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return zw, nil
+}
+
+func createXzDecompressor(r io.Reader) (io.ReadCloser, error) {
+	zr, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(zr), nil
+}
+
+// Bzip2 implementation using the standard library, which only decompresses
+func createBzip2Decompressor(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(bzip2.NewReader(r)), nil
 }
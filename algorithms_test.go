@@ -2,8 +2,14 @@ package compressfs
 
 import (
 	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"testing"
+
+	"github.com/golang/snappy"
 )
 
 // Test all compression algorithms with the same data
@@ -346,3 +352,446 @@ func TestEmptyFileCompression(t *testing.T) {
 		})
 	}
 }
+
+func TestTryBrotliDetection(t *testing.T) {
+	testData := bytes.Repeat([]byte("brotli data without a telling extension. "), 200)
+	compressed, err := CompressBytes(testData, AlgorithmBrotli, 6)
+	if err != nil {
+		t.Fatalf("CompressBytes failed: %v", err)
+	}
+
+	base := NewMemFS()
+	writeBaseFile(t, base, "payload.dat", compressed)
+
+	cfs, err := New(base, &Config{
+		Algorithm:  AlgorithmZstd,
+		AutoDetect: true,
+		TryBrotli:  true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	f, err := cfs.Open("payload.dat")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	got, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(got, testData) {
+		t.Errorf("Brotli payload was not decoded, got %d bytes", len(got))
+	}
+
+	// Plain text is still read as-is
+	writeBaseFile(t, base, "plain.dat", []byte("just some plain text"))
+
+	got, err = cfs.ReadFile("plain.dat")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(got) != "just some plain text" {
+		t.Errorf("Plain file was altered: %q", got)
+	}
+
+	// A whole file that only starts like brotli is not a cut-off stream
+	long, err := CompressBytes(cdcTestData(64<<10), AlgorithmBrotli, 6)
+	if err != nil {
+		t.Fatalf("CompressBytes failed: %v", err)
+	}
+	cut := long[:2000]
+	writeBaseFile(t, base, "cut.dat", cut)
+	if got := readTestFile(t, cfs, "cut.dat"); !bytes.Equal(got, cut) {
+		t.Errorf("Truncated brotli file was decoded, got %d bytes", len(got))
+	}
+}
+
+// TestBrotliNotDetectedByMagic tests that data starting with the bytes once
+// taken for a brotli header is not reported as brotli, while .br files are
+// still decoded by extension
+func TestBrotliNotDetectedByMagic(t *testing.T) {
+	blob := append([]byte{0xce, 0xb2, 0xcf, 0x81}, "arbitrary binary record"...)
+	if algo, ok := IsCompressed(blob); ok {
+		t.Errorf("IsCompressed = %q, expected no algorithm", algo)
+	}
+	if algo, err := DetectAlgorithm(bytes.NewReader(blob)); err != nil || algo != "" {
+		t.Errorf("DetectAlgorithm = %q, %v, expected no algorithm", algo, err)
+	}
+
+	base := NewMemFS()
+	writeBaseFile(t, base, "record.bin", blob)
+	data := bytes.Repeat([]byte("brotli by extension "), 100)
+	compressed, err := CompressBytes(data, AlgorithmBrotli, 6)
+	if err != nil {
+		t.Fatalf("CompressBytes failed: %v", err)
+	}
+	writeBaseFile(t, base, "page.html.br", compressed)
+
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, StripExtension: true, AutoDetect: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	if got := readTestFile(t, cfs, "record.bin"); !bytes.Equal(got, blob) {
+		t.Errorf("Binary file was altered: %x", got)
+	}
+	if got := readTestFile(t, cfs, "page.html"); !bytes.Equal(got, data) {
+		t.Error("Brotli file was not decoded by its extension")
+	}
+}
+
+// TestDecodeFallbacks tests recovery of a file whose extension is misleading
+func TestDecodeFallbacks(t *testing.T) {
+	data := bytes.Repeat([]byte("actually brotli inside "), 300)
+	brotliBytes, err := CompressBytes(data, AlgorithmBrotli, 6)
+	if err != nil {
+		t.Fatalf("CompressBytes failed: %v", err)
+	}
+
+	for _, fallbacks := range [][]Algorithm{nil, {AlgorithmLZ4, AlgorithmBrotli}} {
+		base := NewMemFS()
+		cfs, err := New(base, &Config{
+			Algorithm:       AlgorithmGzip,
+			StripExtension:  true,
+			DecodeFallbacks: fallbacks,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+
+		// Snappy has no reliable magic bytes, so the extension is trusted
+		writeBaseFile(t, base, "mislabeled.txt.sz", brotliBytes)
+
+		f, err := cfs.Open("mislabeled.txt")
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		got, readErr := io.ReadAll(f)
+		f.Close()
+
+		if fallbacks == nil {
+			if readErr == nil && bytes.Equal(got, data) {
+				t.Error("Expected snappy decode of brotli data to fail without fallbacks")
+			}
+			continue
+		}
+		if readErr != nil {
+			t.Fatalf("Read with fallbacks failed: %v", readErr)
+		}
+		if !bytes.Equal(got, data) {
+			t.Error("Fallback decode did not recover the content")
+		}
+	}
+}
+
+// TestDeterministicGzip tests that identical input yields byte-identical gzip files
+func TestDeterministicGzip(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+		Deterministic:     true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("reproducible artifact "), 400)
+	writeTestFile(t, cfs, "first.txt", data)
+	writeTestFile(t, cfs, "second.txt", data)
+
+	first := readBaseFile(t, base, "first.txt.gz")
+	second := readBaseFile(t, base, "second.txt.gz")
+	if !bytes.Equal(first, second) {
+		t.Error("Expected byte-identical gzip output")
+	}
+	if mtime := first[4:8]; !bytes.Equal(mtime, []byte{0, 0, 0, 0}) {
+		t.Errorf("Expected zero gzip mtime, got %x", mtime)
+	}
+}
+
+// TestSnappyBlockSize tests round trips at each configured snappy block size
+func TestSnappyBlockSize(t *testing.T) {
+	data := generateTestData(300 * 1024)
+
+	for _, size := range []int{0, 4096, 64 * 1024, 256 * 1024} {
+		cfs, err := New(NewMemFS(), &Config{
+			Algorithm:         AlgorithmSnappy,
+			PreserveExtension: true,
+			StripExtension:    true,
+			StreamingSample:   true,
+			BufferSize:        1024,
+			SnappyBlockSize:   size,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+
+		// Write twice to cover a pooled writer being reset
+		for round := 0; round < 2; round++ {
+			f, err := cfs.Create("blocks.bin")
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			for off := 0; off < len(data); off += 1000 {
+				end := off + 1000
+				if end > len(data) {
+					end = len(data)
+				}
+				f.Write(data[off:end])
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			if got := readTestFile(t, cfs, "blocks.bin"); !bytes.Equal(got, data) {
+				t.Errorf("SnappyBlockSize=%d: round %d did not round-trip", size, round)
+			}
+		}
+	}
+}
+
+// bzip2Payload is libbzip2 output for four lines of text
+var bzip2Payload = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x2b, 0xde, 0x1f,
+	0x7c, 0x00, 0x00, 0x39, 0xd9, 0x80, 0x00, 0x10, 0x40, 0x00, 0x10, 0x00, 0x3a,
+	0xe5, 0xd5, 0xf0, 0x20, 0x00, 0x70, 0x50, 0x34, 0xd0, 0xc8, 0xc9, 0x88, 0x15,
+	0x54, 0x0d, 0xa8, 0xc9, 0xa6, 0x83, 0x26, 0xe5, 0xca, 0x95, 0x34, 0x20, 0xa1,
+	0x62, 0x08, 0x38, 0x32, 0xec, 0xa1, 0xe9, 0x43, 0xd1, 0x22, 0x09, 0x96, 0x24,
+	0x60, 0x99, 0xe1, 0x43, 0xb3, 0xe3, 0x82, 0x47, 0xc6, 0xa5, 0x4d, 0x08, 0x2e,
+	0x41, 0xb1, 0x82, 0xc4, 0x0c, 0xcd, 0x8e, 0x8c, 0x0f, 0xc5, 0xdc, 0x91, 0x4e,
+	0x14, 0x24, 0x0a, 0xf7, 0x87, 0xdf, 0x00,
+}
+
+// TestBzip2Read tests that bzip2 files are read by extension and by magic
+// bytes, and that writing bzip2 is refused
+func TestBzip2Read(t *testing.T) {
+	want := bytes.Repeat([]byte("legacy archive written by external bzip2 tooling\n"), 4)
+
+	got, err := DecompressBytes(bzip2Payload, AlgorithmBzip2)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("DecompressBytes = %q, %v", got, err)
+	}
+
+	base := NewMemFS()
+	writeBaseFile(t, base, "legacy.txt.bz2", bzip2Payload)
+	writeBaseFile(t, base, "unnamed", bzip2Payload)
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, StripExtension: true, AutoDetect: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	if got := readTestFile(t, cfs, "legacy.txt"); !bytes.Equal(got, want) {
+		t.Errorf("Read by extension = %q", got)
+	}
+	if got := readTestFile(t, cfs, "unnamed"); !bytes.Equal(got, want) {
+		t.Errorf("Read by magic bytes = %q", got)
+	}
+
+	if _, err := CompressBytes(want, AlgorithmBzip2, 0); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("CompressBytes(bzip2) = %v, expected ErrUnsupportedAlgorithm", err)
+	}
+	if err := cfs.SetAlgorithm(AlgorithmBzip2); !errors.Is(err, ErrUnsupportedAlgorithm) || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("SetAlgorithm(bzip2) = %v, expected a read-only ErrUnsupportedAlgorithm", err)
+	}
+	if _, err := New(base, &Config{Algorithm: AlgorithmBzip2}); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("New with bzip2 = %v, expected ErrUnsupportedAlgorithm", err)
+	}
+}
+
+// TestXz tests xz round trips at several levels and detection of xz data by
+// magic bytes and by extension
+func TestXz(t *testing.T) {
+	data := bytes.Repeat([]byte("xz round trip through the lzma2 encoder\n"), 200)
+
+	for _, level := range []int{0, 6, 9} {
+		t.Run(fmt.Sprintf("level%d", level), func(t *testing.T) {
+			cfs, err := New(NewMemFS(), &Config{Algorithm: AlgorithmXz, Level: level, PreserveExtension: true, StripExtension: true})
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+			writeTestFile(t, cfs, "data.txt", data)
+			if got := readTestFile(t, cfs, "data.txt"); !bytes.Equal(got, data) {
+				t.Error("xz file did not round-trip")
+			}
+			if _, err := cfs.base.Stat("data.txt.xz"); err != nil {
+				t.Errorf("Expected data.txt.xz in the base filesystem: %v", err)
+			}
+		})
+	}
+
+	compressed, err := CompressBytes(data, AlgorithmXz, 6)
+	if err != nil {
+		t.Fatalf("CompressBytes failed: %v", err)
+	}
+	if algo, ok := IsCompressed(compressed); !ok || algo != AlgorithmXz {
+		t.Errorf("IsCompressed = %q, %v, expected xz", algo, ok)
+	}
+	if algo, err := DetectAlgorithm(bytes.NewReader(compressed)); err != nil || algo != AlgorithmXz {
+		t.Errorf("DetectAlgorithm = %q, %v, expected xz", algo, err)
+	}
+
+	// An .xz file written elsewhere is found under its logical name
+	base := NewMemFS()
+	writeBaseFile(t, base, "external.log.xz", compressed)
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	if got := readTestFile(t, cfs, "external.log"); !bytes.Equal(got, data) {
+		t.Error("External xz file did not read back through its logical name")
+	}
+	if err := cfs.SetAlgorithm(AlgorithmXz); err != nil {
+		t.Errorf("SetAlgorithm(xz) = %v", err)
+	}
+	if err := cfs.SetLevel(10); !errors.Is(err, ErrInvalidLevel) {
+		t.Errorf("SetLevel(10) for xz = %v, expected ErrInvalidLevel", err)
+	}
+}
+
+// TestZlib tests that zlib streams are detected by their header, including
+// one stored under a .gz name, and that zlib files round-trip
+func TestZlib(t *testing.T) {
+	data := bytes.Repeat([]byte("zlib wrapped deflate, not gzip\n"), 200)
+
+	for _, level := range []int{1, 6, 9} {
+		var buf bytes.Buffer
+		zw, _ := zlib.NewWriterLevel(&buf, level)
+		zw.Write(data)
+		zw.Close()
+		if algo, ok := IsCompressed(buf.Bytes()); !ok || algo != AlgorithmZlib {
+			t.Errorf("Level %d: IsCompressed = %q, %v, expected zlib", level, algo, ok)
+		}
+
+		// A zlib stream some tool saved as .gz reads back decoded
+		base := NewMemFS()
+		writeBaseFile(t, base, "export.csv.gz", buf.Bytes())
+		cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+		if got := readTestFile(t, cfs, "export.csv"); !bytes.Equal(got, data) {
+			t.Errorf("Level %d: zlib stream named .gz did not decode", level)
+		}
+	}
+
+	cfs, err := New(NewMemFS(), &Config{Algorithm: AlgorithmZlib, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, cfs, "data.txt", data)
+	if got := readTestFile(t, cfs, "data.txt"); !bytes.Equal(got, data) {
+		t.Error("zlib file did not round-trip")
+	}
+	if _, err := cfs.base.Stat("data.txt.zz"); err != nil {
+		t.Errorf("Expected data.txt.zz in the base filesystem: %v", err)
+	}
+}
+
+// TestGzipLevelZeroStores tests that gzip level 0 stores the data, larger
+// than level 9, both through CompressBytes and through the filesystem
+func TestGzipLevelZeroStores(t *testing.T) {
+	data := bytes.Repeat([]byte("compressible gzip level test data\n"), 1000)
+
+	stored, err := CompressBytes(data, AlgorithmGzip, 0)
+	if err != nil {
+		t.Fatalf("CompressBytes(0) failed: %v", err)
+	}
+	best, err := CompressBytes(data, AlgorithmGzip, 9)
+	if err != nil {
+		t.Fatalf("CompressBytes(9) failed: %v", err)
+	}
+	if len(stored) <= len(data) || len(stored) <= len(best) {
+		t.Errorf("Level 0 produced %d bytes and level 9 %d for %d bytes, expected level 0 to store", len(stored), len(best), len(data))
+	}
+
+	// Files written at each level match the CompressBytes output
+	for level, want := range map[int][]byte{0: stored, 9: best} {
+		base := NewMemFS()
+		cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, Level: level, LevelSet: true, PreserveExtension: true, StripExtension: true})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+		writeTestFile(t, cfs, "data.txt", data)
+		if got := readBaseFile(t, base, "data.txt.gz"); len(got) != len(want) {
+			t.Errorf("Level %d file stored %d bytes, CompressBytes produced %d", level, len(got), len(want))
+		}
+		if got := readTestFile(t, cfs, "data.txt"); !bytes.Equal(got, data) {
+			t.Errorf("Level %d file did not round-trip", level)
+		}
+	}
+}
+
+// TestNormalizeLevel tests the level each encoder is created with
+func TestNormalizeLevel(t *testing.T) {
+	tests := []struct {
+		algo  Algorithm
+		level int
+		want  int
+	}{
+		{AlgorithmGzip, 0, 0},
+		{AlgorithmGzip, -2, -2},
+		{AlgorithmGzip, -5, -1},
+		{AlgorithmGzip, 12, 9},
+		{AlgorithmZlib, 0, 0},
+		{AlgorithmZstd, 0, 0},
+		{AlgorithmZstd, 30, 22},
+		{AlgorithmBrotli, -1, 0},
+		{AlgorithmXz, 10, 9},
+		{AlgorithmLZ4, 9, 0},
+		{AlgorithmSnappy, 3, 0},
+	}
+	for _, tt := range tests {
+		if got := normalizeLevel(tt.algo, tt.level); got != tt.want {
+			t.Errorf("normalizeLevel(%s, %d) = %d, expected %d", tt.algo, tt.level, got, tt.want)
+		}
+	}
+}
+
+// TestDetectCompressionSnappy tests that framed snappy is detected by its
+// magic and block snappy, which has none, by decoding it
+func TestDetectCompressionSnappy(t *testing.T) {
+	data := bytes.Repeat([]byte("snappy framed or block\n"), 300)
+
+	framed, err := CompressBytes(data, AlgorithmSnappy, 0)
+	if err != nil {
+		t.Fatalf("CompressBytes failed: %v", err)
+	}
+	block := snappy.Encode(nil, data)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		algo       Algorithm
+		confidence Confidence
+	}{
+		{"framed", framed, AlgorithmSnappy, ConfidenceCertain},
+		{"block", block, AlgorithmSnappy, ConfidenceProbable},
+		{"plain", data, "", ConfidenceNone},
+		{"truncated block", block[:len(block)/2], "", ConfidenceNone},
+	}
+	for _, tt := range tests {
+		if algo, confidence := DetectCompression(tt.data); algo != tt.algo || confidence != tt.confidence {
+			t.Errorf("%s: DetectCompression = %q, %d, expected %q, %d", tt.name, algo, confidence, tt.algo, tt.confidence)
+		}
+	}
+	if _, ok := IsCompressed(block); ok {
+		t.Error("IsCompressed matched a snappy block by magic bytes")
+	}
+
+	// Both formats read back through a .sz name
+	base := NewMemFS()
+	writeBaseFile(t, base, "framed.txt.sz", framed)
+	writeBaseFile(t, base, "block.txt.sz", block)
+	cfs, err := New(base, &Config{Algorithm: AlgorithmSnappy, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	for _, name := range []string{"framed.txt", "block.txt"} {
+		if got := readTestFile(t, cfs, name); !bytes.Equal(got, data) {
+			t.Errorf("%s did not decode", name)
+		}
+	}
+}
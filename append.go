@@ -0,0 +1,144 @@
+package compressfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// ContainerFormat is the layout of a stored file across appends
+type ContainerFormat string
+
+const (
+	// SingleStream keeps each file one compressed stream unless
+	// Config.AppendMembers is set
+	SingleStream ContainerFormat = ""
+
+	// MultiMember stores each append as a new compressed member after the
+	// existing data, so appending costs only the new bytes
+	MultiMember ContainerFormat = "multi-member"
+)
+
+// appendPlan describes how an O_APPEND open of an existing compressed file
+// proceeds
+type appendPlan struct {
+	stored   string    // Stored name holding the existing content
+	algo     Algorithm // Algorithm the stored data is compressed with, "" if raw
+	member   bool      // Append a new member after the stored data
+	existing []byte    // Decompressed existing content, for a rewrite
+	perm     fs.FileMode
+}
+
+// appendPrefix marks the temporary file an append rewrite writes before
+// moving it over the stored file on Close. Like replacePrefix, it prefixes
+// the base name so the name keeps its extension.
+const appendPrefix = ".append-"
+
+// prepareAppend plans an O_APPEND open of the logical name, whose fresh
+// writes go to write. It returns nil when there is no existing file to
+// append to. Files whose algorithm decodes concatenated streams take a new
+// member when Config.AppendMembers is set or ContainerFormat is
+// MultiMember; the rest are read back so that Close rewrites them as one
+// stream under a temporary name, or fail with ErrAppendNotSupported under
+// Config.DisableAppendRewrite.
+func (cfs *FS) prepareAppend(name, write string, config *Config) (*appendPlan, error) {
+	stored, ok := write, false
+	if config.StripExtension {
+		stored, _, ok = cfs.resolveStored(name, config)
+	} else {
+		_, err := cfs.base.Stat(write)
+		ok = err == nil
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	algo, err := cfs.storedAlgorithm(stored)
+	if err != nil {
+		return nil, err
+	}
+	if cfs.appendsMember(name, algo, config) {
+		return &appendPlan{stored: stored, algo: algo, member: true}, nil
+	}
+	if config.DisableAppendRewrite {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrAppendNotSupported}
+	}
+
+	info, err := cfs.base.Stat(stored)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := cfs.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &appendPlan{stored: stored, algo: algo, existing: existing, perm: info.Mode().Perm()}, nil
+}
+
+// appendsMember reports whether an append to the logical name, stored
+// compressed with algo, can write a new member after the stored data.
+// Footers would otherwise end up between members, so none may be written.
+func (cfs *FS) appendsMember(name string, algo Algorithm, config *Config) bool {
+	if !config.AppendMembers && config.ContainerFormat != MultiMember {
+		return false
+	}
+	if config.EmbedFileMetadata || config.StoreContentHash || config.ContentDefinedChunking || cfs.normalizesLineEndings(name) {
+		return false
+	}
+	if config.ReportUncompressedSize && !cfs.recordsSize(algo) {
+		return false
+	}
+	return concatenates(algo) && !(algo == AlgorithmZstd && config.Seekable)
+}
+
+// storedAlgorithm returns the algorithm the stored file's data is compressed
+// with, or "" when it is stored raw. Brotli and snappy have no magic bytes
+// and are trusted by extension.
+func (cfs *FS) storedAlgorithm(stored string) (Algorithm, error) {
+	f, err := cfs.base.OpenFile(stored, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, decodeProbeSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	algo, _ := cfs.headAlgorithm(stored, head[:n])
+	return algo, nil
+}
+
+// headAlgorithm returns the algorithm of the stored file whose data starts
+// with head, detected by its magic bytes, and false when it is stored raw.
+// Brotli and snappy have no magic bytes and are trusted by the extension of
+// the stored name, as on open.
+func (cfs *FS) headAlgorithm(stored string, head []byte) (Algorithm, bool) {
+	if len(head) == 0 {
+		return "", false
+	}
+	if algo, compressed := IsCompressed(head); compressed {
+		return algo, true
+	}
+	if _, algo, placed := cfs.stripPlacedExtension(stored); placed && (algo == AlgorithmBrotli || algo == AlgorithmSnappy) {
+		return algo, true
+	}
+	return "", false
+}
+
+// startAppend continues an O_APPEND open after the base file is opened:
+// a member append compresses new writes as their own stream with the stored
+// algorithm, and a rewrite first writes the existing content
+func (cf *compressedFile) startAppend(plan *appendPlan) error {
+	if plan.member {
+		cf.appendMember = true
+		if plan.algo != cf.writeAlgo {
+			cf.writeLevel = cf.cfs.getDefaultLevel(plan.algo)
+		}
+		cf.writeAlgo = plan.algo
+		return nil
+	}
+	_, err := cf.Write(plan.existing)
+	return err
+}
@@ -0,0 +1,280 @@
+package compressfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/absfs/absfs"
+)
+
+// appendTestFile appends data to the named file with O_APPEND
+func appendTestFile(t *testing.T, cfs *FS, name string, data []byte) {
+	t.Helper()
+	f, err := cfs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile with O_APPEND failed: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+// TestAppendRewrite tests that appending rewrites the file as one stream
+func TestAppendRewrite(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmLZ4, AlgorithmBrotli} {
+		t.Run(string(algo), func(t *testing.T) {
+			base := NewMemFS()
+			cfs, err := New(base, &Config{Algorithm: algo, PreserveExtension: true, StripExtension: true})
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+
+			first := bytes.Repeat([]byte("first line of the log\n"), 50)
+			second := bytes.Repeat([]byte("second line of the log\n"), 50)
+			writeTestFile(t, cfs, "app.log", first)
+			appendTestFile(t, cfs, "app.log", second)
+
+			want := append(append([]byte{}, first...), second...)
+			stored := readBaseFile(t, base, "app.log"+GetExtension(algo))
+			got, err := DecompressBytes(stored, algo)
+			if err != nil {
+				t.Fatalf("DecompressBytes failed: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Error("Stored file is not one stream of the appended content")
+			}
+			if algo == AlgorithmGzip {
+				zr, err := gzip.NewReader(bytes.NewReader(stored))
+				if err != nil {
+					t.Fatalf("gzip.NewReader failed: %v", err)
+				}
+				zr.Multistream(false)
+				if n, _ := io.Copy(io.Discard, zr); n != int64(len(want)) {
+					t.Errorf("First gzip member holds %d bytes, expected the whole file", n)
+				}
+			}
+			if got := readTestFile(t, cfs, "app.log"); !bytes.Equal(got, want) {
+				t.Error("Read after append did not return the concatenation")
+			}
+		})
+	}
+}
+
+// TestAppendRewriteKeepsOriginal tests that an append rewrite leaves the
+// stored file intact until Close succeeds, and in place when it fails
+func TestAppendRewriteKeepsOriginal(t *testing.T) {
+	base := NewMemFS()
+	config := &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true}
+	cfs, err := New(base, config)
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	first := bytes.Repeat([]byte("kept until the append closes\n"), 50)
+	second := bytes.Repeat([]byte("appended line\n"), 50)
+	writeTestFile(t, cfs, "app.log", first)
+
+	f, err := cfs.OpenFile("app.log", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile with O_APPEND failed: %v", err)
+	}
+	if _, err := f.Write(second); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if got := readTestFile(t, cfs, "app.log"); !bytes.Equal(got, first) {
+		t.Error("Open append rewrite changed the stored file")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	want := append(append([]byte{}, first...), second...)
+	if got := readTestFile(t, cfs, "app.log"); !bytes.Equal(got, want) {
+		t.Error("Read after append did not return the concatenation")
+	}
+
+	// A rewrite that cannot be moved into place keeps the stored file
+	failing, err := New(&failingRenameFiler{Filer: base}, config)
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	f, err = failing.OpenFile("app.log", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile with O_APPEND failed: %v", err)
+	}
+	f.Write(second)
+	if err := f.Close(); err == nil {
+		t.Error("Expected Close to fail when the rewrite cannot be renamed")
+	}
+	if got := readTestFile(t, cfs, "app.log"); !bytes.Equal(got, want) {
+		t.Error("Failed append rewrite changed the stored file")
+	}
+	entries, err := base.ReadDir(".")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "app.log.gz" {
+		for _, e := range entries {
+			t.Logf("Base holds %s", e.Name())
+		}
+		t.Error("Expected only app.log.gz in the base filesystem")
+	}
+}
+
+// TestAppendMembers tests that AppendMembers adds a member without
+// rewriting the existing data, and that DisableAppendRewrite rejects
+// algorithms that cannot take one
+func TestAppendMembers(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:            AlgorithmGzip,
+		PreserveExtension:    true,
+		StripExtension:       true,
+		AppendMembers:        true,
+		DisableAppendRewrite: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	first := bytes.Repeat([]byte("first member "), 100)
+	writeTestFile(t, cfs, "app.log", first)
+	before := readBaseFile(t, base, "app.log.gz")
+
+	// Small appends still compress: the stored file is already gzip
+	appendTestFile(t, cfs, "app.log", []byte("tail"))
+	appendTestFile(t, cfs, "app.log", []byte(" and more"))
+	after := readBaseFile(t, base, "app.log.gz")
+	if !bytes.HasPrefix(after, before) {
+		t.Error("Member append rewrote the existing data")
+	}
+	want := string(first) + "tail and more"
+	if got := readTestFile(t, cfs, "app.log"); string(got) != want {
+		t.Errorf("Read after member appends = %d bytes, expected %d", len(got), len(want))
+	}
+
+	br, err := New(base, &Config{
+		Algorithm:            AlgorithmBrotli,
+		PreserveExtension:    true,
+		StripExtension:       true,
+		AppendMembers:        true,
+		DisableAppendRewrite: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, br, "other.log", first)
+	if _, err := br.OpenFile("other.log", os.O_WRONLY|os.O_APPEND, 0644); !errors.Is(err, ErrAppendNotSupported) {
+		t.Errorf("Append to brotli = %v, expected ErrAppendNotSupported", err)
+	}
+}
+
+// noAppendFiler ignores os.O_APPEND: its handles start at offset 0 and
+// write at their position. Writes before the end of the file fail rather
+// than overwrite, which the memory filesystem does not model.
+type noAppendFiler struct {
+	absfs.Filer
+}
+
+type positionalFile struct {
+	absfs.File
+	pos int64
+}
+
+func (n *noAppendFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	f, err := n.Filer.OpenFile(name, flag&^os.O_APPEND, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &positionalFile{File: f}, nil
+}
+
+func (f *positionalFile) Write(p []byte) (int, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if f.pos < info.Size() {
+		return 0, fmt.Errorf("write at offset %d would overwrite %d stored bytes", f.pos, info.Size())
+	}
+	n, err := f.File.Write(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *positionalFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.pos = pos
+	}
+	return pos, err
+}
+
+// TestAppendMultiMember tests that appends under MultiMember add members
+// at the end of the stored file without reading back its content, even on
+// a base that ignores O_APPEND, and that the members read back as one stream
+// in bounded memory
+func TestAppendMultiMember(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZstd, AlgorithmSnappy} {
+		t.Run(string(algo), func(t *testing.T) {
+			base := &readCountingFiler{Filer: &noAppendFiler{Filer: NewMemFS()}}
+			cfs, err := New(base, &Config{
+				Algorithm:            algo,
+				PreserveExtension:    true,
+				StripExtension:       true,
+				ContainerFormat:      MultiMember,
+				DisableAppendRewrite: true,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+
+			data := cdcTestData(3 << 20)
+			parts := [][]byte{data[:1<<20], data[1<<20 : 2<<20], data[2<<20:]}
+			var previous []byte
+			for i, part := range parts {
+				base.read = 0
+				appendTestFile(t, cfs, "app.log", part)
+				if base.read > decodeProbeSize {
+					t.Errorf("Append %d read %d bytes of the stored file", i+1, base.read)
+				}
+				stored := readBaseFile(t, base, "app.log"+GetExtension(algo))
+				if !bytes.HasPrefix(stored, previous) {
+					t.Fatalf("Append %d rewrote the existing data", i+1)
+				}
+				previous = stored
+			}
+
+			f, err := cfs.Open("app.log")
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer f.Close()
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			h := sha256.New()
+			n, err := io.CopyBuffer(h, f, make([]byte, 32<<10))
+			runtime.ReadMemStats(&after)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if want := sha256.Sum256(data); n != int64(len(data)) || !bytes.Equal(h.Sum(nil), want[:]) {
+				t.Fatalf("Read %d bytes that do not match the %d appended", n, len(data))
+			}
+			// Zstd decoders size their history to each frame's content, so
+			// only the streaming decoders are held to a bound
+			if alloc := after.TotalAlloc - before.TotalAlloc; algo != AlgorithmZstd && alloc > uint64(len(data))/2 {
+				t.Errorf("Reading the members allocated %d bytes for %d of content", alloc, len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package compressfs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// Archive layout: archiveMagic and a version byte, the stored stream of
+// every member back to back, the central directory, then a trailer holding
+// the directory offset (uint64, little endian) and archiveMagic. The
+// directory is a uvarint member count followed by, for each member, its
+// name and algorithm (uvarint length, then bytes), then its offset, stored
+// size, original size, mode and modification time in Unix nanoseconds as
+// uvarints.
+const (
+	archiveMagic       = "CFSA"
+	archiveVersion     = 1
+	archiveHeaderSize  = len(archiveMagic) + 1
+	archiveTrailerSize = 8 + len(archiveMagic)
+)
+
+// archiveEntry is a member of an archive as its central directory records it
+type archiveEntry struct {
+	name         string    // Logical path within the archive
+	algo         Algorithm // Algorithm the member is compressed with, "" if stored uncompressed
+	offset       int64     // Offset of the stored stream in the archive
+	size         int64     // Stored size
+	originalSize int64     // Decompressed size
+	mode         fs.FileMode
+	modTime      time.Time
+}
+
+// storedName returns the name the member is stored under, with the
+// extension of its algorithm
+func (e *archiveEntry) storedName() string {
+	if e.algo == "" {
+		return e.name
+	}
+	return e.name + GetExtension(e.algo)
+}
+
+// CreateArchive writes every file under root in src to a single seekable
+// archive at out on the base filesystem of src, for backup and restore.
+// Members keep the algorithm they are stored with, at the level src would
+// choose, and are compressed independently of one another and of src's
+// dictionaries and footers, so the archive reads back with OpenArchive
+// alone. Files stored uncompressed, or with an algorithm that cannot be
+// written, are archived uncompressed. Members are named by their logical
+// path relative to root. On failure the partly written archive is removed.
+func CreateArchive(src *FS, root, out string) error {
+	root, out = cleanPath(root), cleanPath(out)
+
+	dst, err := src.base.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := writeArchive(src, root, out, dst); err != nil {
+		dst.Close()
+		src.base.Remove(out)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		src.base.Remove(out)
+		return err
+	}
+	return nil
+}
+
+// writeArchive writes the archive of the files under root to dst
+func writeArchive(src *FS, root, out string, dst io.Writer) error {
+	w := &archiveWriter{w: bufio.NewWriterSize(dst, copyBufferSize)}
+	w.Write(append([]byte(archiveMagic), archiveVersion))
+
+	var entries []archiveEntry
+	err := src.walkBase(root, func(name string) error {
+		if name == out {
+			return nil
+		}
+		entry, err := w.addMember(src, root, name)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	dirOffset := w.n
+	w.Write(encodeArchiveDirectory(entries))
+	w.Write(append(binary.LittleEndian.AppendUint64(nil, uint64(dirOffset)), archiveMagic...))
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// archiveWriter counts the bytes written to an archive and keeps the first
+// write error
+type archiveWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *archiveWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+// addMember writes the stored file name to the archive and returns its
+// directory entry
+func (w *archiveWriter) addMember(src *FS, root, name string) (archiveEntry, error) {
+	info, err := src.base.Stat(name)
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	logical, _, ok := src.stripPlacedExtension(name)
+	if !ok {
+		logical = name
+	}
+
+	// Without StripExtension the stored name is the one files are read by
+	open := name
+	if src.config.StripExtension {
+		open = logical
+	}
+	f, fi, err := src.OpenWithInfo(open)
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	defer f.Close()
+
+	entry := archiveEntry{
+		name:    archiveMemberName(root, name),
+		algo:    fi.Algorithm,
+		offset:  w.n,
+		mode:    info.Mode(),
+		modTime: info.ModTime(),
+	}
+	if entry.algo != "" {
+		entry.name = archiveMemberName(root, logical)
+	}
+	if !writable(entry.algo) {
+		entry.algo = ""
+	}
+
+	var compressor io.WriteCloser
+	if entry.algo != "" {
+		size := fi.OriginalSize
+		if size < 0 {
+			size = fi.CompressedSize
+		}
+		algo, level, _ := src.selectAlgorithm(logical, size)
+		if algo != entry.algo {
+			level = src.getDefaultLevel(entry.algo)
+		}
+		if compressor, err = createCompressorWithDict(entry.algo, w, level, nil); err != nil {
+			return archiveEntry{}, err
+		}
+		// The directory records names and times, so gzip headers carry none
+		if gz, ok := compressor.(*gzip.Writer); ok {
+			gz.Header = gzip.Header{OS: 255}
+		}
+	}
+
+	if compressor == nil {
+		entry.originalSize, err = io.Copy(w, f)
+	} else if entry.originalSize, err = io.Copy(compressor, f); err != nil {
+		compressor.Close()
+	} else {
+		err = compressor.Close()
+	}
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	entry.size = w.n - entry.offset
+	return entry, nil
+}
+
+// archiveMemberName returns the path of the file name relative to root
+func archiveMemberName(root, name string) string {
+	if root == "." {
+		return name
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+}
+
+// encodeArchiveDirectory returns the central directory listing entries
+func encodeArchiveDirectory(entries []archiveEntry) []byte {
+	dir := binary.AppendUvarint(nil, uint64(len(entries)))
+	for _, e := range entries {
+		dir = binary.AppendUvarint(dir, uint64(len(e.name)))
+		dir = append(dir, e.name...)
+		dir = binary.AppendUvarint(dir, uint64(len(e.algo)))
+		dir = append(dir, e.algo...)
+		dir = binary.AppendUvarint(dir, uint64(e.offset))
+		dir = binary.AppendUvarint(dir, uint64(e.size))
+		dir = binary.AppendUvarint(dir, uint64(e.originalSize))
+		dir = binary.AppendUvarint(dir, uint64(e.mode))
+		dir = binary.AppendUvarint(dir, uint64(e.modTime.UnixNano()))
+	}
+	return dir
+}
+
+// decodeArchiveDirectory parses a central directory, checking that every
+// member lies between the header and dataEnd
+func decodeArchiveDirectory(dir []byte, dataEnd int64) ([]archiveEntry, error) {
+	d := &directoryReader{r: bytes.NewReader(dir)}
+	count := d.uvarint()
+	if d.err != nil || count > uint64(len(dir)) {
+		return nil, fmt.Errorf("%w: invalid archive directory", ErrCorruptedData)
+	}
+
+	entries := make([]archiveEntry, 0, count)
+	for i := uint64(0); i < count; i++ {
+		e := archiveEntry{
+			name:         d.string(),
+			algo:         Algorithm(d.string()),
+			offset:       int64(d.uvarint()),
+			size:         int64(d.uvarint()),
+			originalSize: int64(d.uvarint()),
+			mode:         fs.FileMode(d.uvarint()),
+			modTime:      time.Unix(0, int64(d.uvarint())),
+		}
+		if d.err != nil || e.offset < int64(archiveHeaderSize) || e.size < 0 || e.offset+e.size > dataEnd {
+			return nil, fmt.Errorf("%w: invalid archive directory", ErrCorruptedData)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// directoryReader reads the fields of a central directory, keeping the
+// first error
+type directoryReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *directoryReader) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	d.err = err
+	return v
+}
+
+func (d *directoryReader) string() string {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(d.r.Len()) {
+		d.err = io.ErrUnexpectedEOF
+	}
+	if d.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	io.ReadFull(d.r, b)
+	return string(b)
+}
+
+// OpenArchive opens the archive at path on base, as written by
+// CreateArchive, and returns a read-only FS of its members. Only the
+// central directory is read up front: each member is read from its own
+// offset when opened, and its size is reported from the directory. ReadAt
+// on members whose format cannot seek is served under
+// Config.AllowSequentialReadAt.
+func OpenArchive(base absfs.FileSystem, path string) (*FS, error) {
+	f, err := base.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	notArchive := &fs.PathError{Op: "openarchive", Path: path, Err: ErrNotArchive}
+	size := info.Size()
+	if size < int64(archiveHeaderSize+archiveTrailerSize) {
+		return nil, notArchive
+	}
+
+	header := make([]byte, archiveHeaderSize)
+	trailer := make([]byte, archiveTrailerSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if _, err := f.ReadAt(trailer, size-int64(archiveTrailerSize)); err != nil {
+		return nil, err
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic || string(trailer[8:]) != archiveMagic {
+		return nil, notArchive
+	}
+	if header[len(archiveMagic)] != archiveVersion {
+		return nil, &fs.PathError{Op: "openarchive", Path: path, Err: fmt.Errorf("%w: archive version %d", ErrNotArchive, header[len(archiveMagic)])}
+	}
+
+	dirOffset := int64(binary.LittleEndian.Uint64(trailer))
+	dirEnd := size - int64(archiveTrailerSize)
+	if dirOffset < int64(archiveHeaderSize) || dirOffset > dirEnd {
+		return nil, &fs.PathError{Op: "openarchive", Path: path, Err: ErrCorruptedData}
+	}
+	dir := make([]byte, dirEnd-dirOffset)
+	if _, err := f.ReadAt(dir, dirOffset); err != nil {
+		return nil, err
+	}
+	entries, err := decodeArchiveDirectory(dir, dirOffset)
+	if err != nil {
+		return nil, &fs.PathError{Op: "openarchive", Path: path, Err: err}
+	}
+
+	return New(newArchiveFiler(base, path, info.ModTime(), entries), &Config{
+		PreserveExtension:      true,
+		StripExtension:         true,
+		ReportUncompressedSize: true,
+		AllowSequentialReadAt:  true,
+	})
+}
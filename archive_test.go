@@ -0,0 +1,160 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/absfs/absfs"
+)
+
+// TestArchive tests archiving a tree of files stored with several
+// algorithms and reading members back by path, in any order and at random
+// offsets
+func TestArchive(t *testing.T) {
+	base := NewMemFS()
+	files := map[string][]byte{
+		"docs/readme.txt":      bytes.Repeat([]byte("read me first\n"), 500),
+		"docs/guide/intro.txt": bytes.Repeat([]byte("chapter one\n"), 2000),
+		"data/events.log":      cdcTestData(1 << 20),
+		"small.txt":            []byte("tiny"),
+	}
+	algos := map[string]Algorithm{
+		"docs/readme.txt":      AlgorithmGzip,
+		"docs/guide/intro.txt": AlgorithmZstd,
+		"data/events.log":      AlgorithmLZ4,
+		"small.txt":            AlgorithmGzip,
+	}
+	var src *FS
+	for name, data := range files {
+		cfs, err := New(base, &Config{Algorithm: algos[name], PreserveExtension: true, StripExtension: true, MinSize: 64})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+		writeTestFile(t, cfs, name, data)
+		src = cfs
+	}
+
+	if err := CreateArchive(src, ".", "backup.cfsar"); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+	arc, err := OpenArchive(absfs.ExtendFiler(base), "backup.cfsar")
+	if err != nil {
+		t.Fatalf("OpenArchive failed: %v", err)
+	}
+
+	for _, name := range []string{"data/events.log", "small.txt", "docs/guide/intro.txt", "docs/readme.txt"} {
+		if got := readTestFile(t, arc, name); !bytes.Equal(got, files[name]) {
+			t.Errorf("Member %s holds the wrong content", name)
+		}
+		info, err := arc.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%s) failed: %v", name, err)
+		}
+		if info.Size() != int64(len(files[name])) {
+			t.Errorf("Stat(%s) size = %d, expected %d", name, info.Size(), len(files[name]))
+		}
+		_, fi, err := arc.OpenWithInfo(name)
+		if err != nil {
+			t.Fatalf("OpenWithInfo(%s) failed: %v", name, err)
+		}
+		want := algos[name]
+		if name == "small.txt" {
+			want = ""
+		}
+		if fi.Algorithm != want {
+			t.Errorf("Member %s stored with %q, expected %q", name, fi.Algorithm, want)
+		}
+	}
+
+	entries, err := arc.ReadDir("docs")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "guide" || !entries[0].IsDir() || entries[1].Name() != "readme.txt" {
+		t.Errorf("ReadDir(docs) listed %v, expected guide/ and readme.txt", entries)
+	}
+
+	// Random access within a member
+	f, err := arc.Open("data/events.log")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	data := files["data/events.log"]
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 8; i++ {
+		off := rng.Int63n(int64(len(data) - 4096))
+		buf := make([]byte, 4096)
+		if _, err := f.ReadAt(buf, off); err != nil {
+			t.Fatalf("ReadAt(%d) failed: %v", off, err)
+		}
+		if !bytes.Equal(buf, data[off:off+4096]) {
+			t.Errorf("ReadAt(%d) returned the wrong bytes", off)
+		}
+	}
+
+	if _, err := arc.Create("new.txt"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Create in an archive = %v, expected os.ErrPermission", err)
+	}
+}
+
+// TestArchiveRoot tests that members are named relative to the archived
+// root and that the archive does not contain itself
+func TestArchiveRoot(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmZstd, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, cfs, "site/index.html", bytes.Repeat([]byte("<p>hello</p>\n"), 100))
+	writeTestFile(t, cfs, "other.txt", []byte("left out of the archive"))
+
+	if err := CreateArchive(cfs, "site", "site/site.cfsar"); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+	arc, err := OpenArchive(absfs.ExtendFiler(base), "site/site.cfsar")
+	if err != nil {
+		t.Fatalf("OpenArchive failed: %v", err)
+	}
+	entries, err := arc.ReadDir(".")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "index.html" {
+		t.Errorf("Archive lists %v, expected only index.html", entries)
+	}
+	if _, err := arc.Stat("other.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(other.txt) = %v, expected fs.ErrNotExist", err)
+	}
+}
+
+// TestOpenArchiveInvalid tests that files that are not archives, or whose
+// directory is damaged, are rejected
+func TestOpenArchiveInvalid(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, cfs, "a.txt", bytes.Repeat([]byte("archived\n"), 100))
+	writeBaseFile(t, base, "plain.txt", []byte("not an archive at all"))
+	if _, err := OpenArchive(absfs.ExtendFiler(base), "plain.txt"); !errors.Is(err, ErrNotArchive) {
+		t.Errorf("OpenArchive of a plain file = %v, expected ErrNotArchive", err)
+	}
+
+	if err := CreateArchive(cfs, ".", "a.cfsar"); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+	archive := readBaseFile(t, base, "a.cfsar")
+	// Point the directory past the end of the archive
+	damaged := append([]byte(nil), archive...)
+	copy(damaged[len(damaged)-archiveTrailerSize:], []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	writeBaseFile(t, base, "damaged.cfsar", damaged)
+	if _, err := OpenArchive(absfs.ExtendFiler(base), "damaged.cfsar"); !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("OpenArchive of a damaged archive = %v, expected ErrCorruptedData", err)
+	}
+}
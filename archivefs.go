@@ -0,0 +1,244 @@
+package compressfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// archiveFiler is the read-only base filesystem of an archive opened with
+// OpenArchive. Members appear under their stored names, so the FS wrapping
+// it resolves and decompresses them as it would any stored file, and
+// directories are implied by the paths of the members within them.
+type archiveFiler struct {
+	base     absfs.FileSystem
+	path     string    // Path of the archive on base
+	modTime  time.Time // Modification time reported for directories
+	entries  map[string]*archiveEntry
+	children map[string][]string // Sorted names of the entries of each directory
+}
+
+func newArchiveFiler(base absfs.FileSystem, archivePath string, modTime time.Time, entries []archiveEntry) *archiveFiler {
+	a := &archiveFiler{
+		base:     base,
+		path:     archivePath,
+		modTime:  modTime,
+		entries:  make(map[string]*archiveEntry, len(entries)),
+		children: map[string][]string{".": nil},
+	}
+	for i := range entries {
+		name := entries[i].storedName()
+		a.entries[name] = &entries[i]
+		for name != "." {
+			dir := path.Dir(name)
+			_, known := a.children[dir]
+			a.children[dir] = append(a.children[dir], path.Base(name))
+			if known {
+				break
+			}
+			name = dir
+		}
+	}
+	for _, names := range a.children {
+		sort.Strings(names)
+	}
+	return a
+}
+
+// archivePath returns the path of name within the archive
+func archivePath(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+cleanPath(name)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// stat returns the info of the member or directory at name
+func (a *archiveFiler) stat(name string) (*archiveFileInfo, bool) {
+	if e, ok := a.entries[name]; ok {
+		return &archiveFileInfo{name: path.Base(name), size: e.size, mode: e.mode, modTime: e.modTime}, true
+	}
+	if _, ok := a.children[name]; ok {
+		return &archiveFileInfo{name: path.Base(name), mode: fs.ModeDir | 0555, modTime: a.modTime}, true
+	}
+	return nil, false
+}
+
+func (a *archiveFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	name = archivePath(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	info, ok := a.stat(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if info.IsDir() {
+		return &archiveDir{archive: a, name: name, info: info}, nil
+	}
+
+	f, err := a.base.OpenFile(a.path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	e := a.entries[name]
+	return &archiveFile{SectionReader: io.NewSectionReader(f, e.offset, e.size), file: f, name: name, entry: e, info: info}, nil
+}
+
+func (a *archiveFiler) Mkdir(name string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: os.ErrPermission}
+}
+
+func (a *archiveFiler) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+}
+
+func (a *archiveFiler) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+}
+
+func (a *archiveFiler) Stat(name string) (os.FileInfo, error) {
+	name = archivePath(name)
+	info, ok := a.stat(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+func (a *archiveFiler) Chmod(name string, mode os.FileMode) error {
+	return &fs.PathError{Op: "chmod", Path: name, Err: os.ErrPermission}
+}
+
+func (a *archiveFiler) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return &fs.PathError{Op: "chtimes", Path: name, Err: os.ErrPermission}
+}
+
+func (a *archiveFiler) Chown(name string, uid, gid int) error {
+	return &fs.PathError{Op: "chown", Path: name, Err: os.ErrPermission}
+}
+
+func (a *archiveFiler) ReadDir(name string) ([]fs.DirEntry, error) {
+	name = archivePath(name)
+	names, ok := a.children[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries := make([]fs.DirEntry, 0, len(names))
+	for _, child := range names {
+		info, _ := a.stat(path.Join(name, child))
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	return entries, nil
+}
+
+func (a *archiveFiler) ReadFile(name string) ([]byte, error) {
+	f, err := a.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (a *archiveFiler) Sub(dir string) (fs.FS, error) {
+	// Like memFS, archives don't support Sub
+	return nil, os.ErrPermission
+}
+
+// archiveFileInfo describes a member or directory of an archive
+type archiveFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *archiveFileInfo) Name() string       { return fi.name }
+func (fi *archiveFileInfo) Size() int64        { return fi.size }
+func (fi *archiveFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *archiveFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *archiveFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *archiveFileInfo) Sys() interface{}   { return nil }
+
+// archiveFile reads the stored stream of a member from its own handle on
+// the archive
+type archiveFile struct {
+	*io.SectionReader
+	file  absfs.File
+	name  string
+	entry *archiveEntry
+	info  *archiveFileInfo
+}
+
+// originalSize returns the decompressed size the directory records
+func (f *archiveFile) originalSize() int64 { return f.entry.originalSize }
+
+func (f *archiveFile) Name() string                             { return f.name }
+func (f *archiveFile) Stat() (os.FileInfo, error)               { return f.info, nil }
+func (f *archiveFile) Sync() error                              { return nil }
+func (f *archiveFile) Close() error                             { return f.file.Close() }
+func (f *archiveFile) Write(p []byte) (int, error)              { return 0, f.readOnly("write") }
+func (f *archiveFile) WriteAt(p []byte, off int64) (int, error) { return 0, f.readOnly("write") }
+func (f *archiveFile) WriteString(s string) (int, error)        { return 0, f.readOnly("write") }
+func (f *archiveFile) Truncate(size int64) error                { return f.readOnly("truncate") }
+func (f *archiveFile) Readdir(n int) ([]os.FileInfo, error)     { return nil, os.ErrInvalid }
+func (f *archiveFile) Readdirnames(n int) ([]string, error)     { return nil, os.ErrInvalid }
+func (f *archiveFile) ReadDir(n int) ([]fs.DirEntry, error)     { return nil, os.ErrInvalid }
+
+func (f *archiveFile) readOnly(op string) error {
+	return &fs.PathError{Op: op, Path: f.name, Err: os.ErrPermission}
+}
+
+// archiveDir is an open directory of an archive
+type archiveDir struct {
+	archive *archiveFiler
+	name    string
+	info    *archiveFileInfo
+}
+
+func (d *archiveDir) Name() string                                 { return d.name }
+func (d *archiveDir) Stat() (os.FileInfo, error)                   { return d.info, nil }
+func (d *archiveDir) Sync() error                                  { return nil }
+func (d *archiveDir) Close() error                                 { return nil }
+func (d *archiveDir) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (d *archiveDir) ReadAt(p []byte, off int64) (int, error)      { return 0, os.ErrInvalid }
+func (d *archiveDir) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+func (d *archiveDir) Write(p []byte) (int, error)                  { return 0, os.ErrInvalid }
+func (d *archiveDir) WriteAt(p []byte, off int64) (int, error)     { return 0, os.ErrInvalid }
+func (d *archiveDir) WriteString(s string) (int, error)            { return 0, os.ErrInvalid }
+func (d *archiveDir) Truncate(size int64) error                    { return os.ErrInvalid }
+
+func (d *archiveDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := d.archive.ReadDir(d.name)
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries, err
+}
+
+func (d *archiveDir) Readdir(n int) ([]os.FileInfo, error) {
+	entries, err := d.ReadDir(n)
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, _ := entry.Info()
+		infos = append(infos, info)
+	}
+	return infos, err
+}
+
+func (d *archiveDir) Readdirnames(n int) ([]string, error) {
+	entries, err := d.ReadDir(n)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, err
+}
@@ -1,8 +1,15 @@
 package compressfs
 
 import (
+	"bytes"
 	"io"
+	"os"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+	"github.com/golang/snappy"
 )
 
 // Benchmark data generators
@@ -226,3 +233,199 @@ func BenchmarkZstdRoundTrip1MB(b *testing.B)   { benchmarkRoundTrip(b, Algorithm
 func BenchmarkLZ4RoundTrip1MB(b *testing.B)    { benchmarkRoundTrip(b, AlgorithmLZ4, 0, 1024*1024) }
 func BenchmarkBrotliRoundTrip1MB(b *testing.B) { benchmarkRoundTrip(b, AlgorithmBrotli, 6, 1024*1024) }
 func BenchmarkSnappyRoundTrip1MB(b *testing.B) { benchmarkRoundTrip(b, AlgorithmSnappy, 0, 1024*1024) }
+
+// Benchmark snappy block sizes for a stream written in small pieces
+func benchmarkSnappyBlockSize(b *testing.B, blockSize int) {
+	testData := generateTestData(1024 * 1024)
+	const chunk = 512
+
+	b.ResetTimer()
+	b.SetBytes(int64(len(testData)))
+
+	for i := 0; i < b.N; i++ {
+		base := NewMemFS()
+		cfs, _ := New(base, &Config{
+			Algorithm:         AlgorithmSnappy,
+			PreserveExtension: true,
+			StripExtension:    true,
+			StreamingSample:   true,
+			BufferSize:        chunk,
+			SnappyBlockSize:   blockSize,
+		})
+
+		f, _ := cfs.Create("test.bin")
+		for off := 0; off < len(testData); off += chunk {
+			f.Write(testData[off : off+chunk])
+		}
+		f.Close()
+	}
+}
+
+func BenchmarkSnappyBlockDefault1MB(b *testing.B) { benchmarkSnappyBlockSize(b, 0) }
+func BenchmarkSnappyBlock64KB1MB(b *testing.B)    { benchmarkSnappyBlockSize(b, 64*1024) }
+func BenchmarkSnappyBlock256KB1MB(b *testing.B)   { benchmarkSnappyBlockSize(b, 256*1024) }
+
+// Benchmark parallel against serial compression of a large file
+func benchmarkParallelCompression(b *testing.B, algo Algorithm, level int, parallel bool) {
+	testData := generateHighlyCompressibleData(50 * 1024 * 1024)
+
+	b.ResetTimer()
+	b.SetBytes(int64(len(testData)))
+
+	for i := 0; i < b.N; i++ {
+		base := NewMemFS()
+		cfs, _ := New(base, &Config{
+			Algorithm:                 algo,
+			Level:                     level,
+			PreserveExtension:         true,
+			StripExtension:            true,
+			EnableParallelCompression: parallel,
+		})
+
+		f, _ := cfs.Create("test.bin")
+		f.Write(testData)
+		f.Close()
+	}
+}
+
+func BenchmarkGzipSerial50MB(b *testing.B)   { benchmarkParallelCompression(b, AlgorithmGzip, 6, false) }
+func BenchmarkGzipParallel50MB(b *testing.B) { benchmarkParallelCompression(b, AlgorithmGzip, 6, true) }
+func BenchmarkZstdSerial50MB(b *testing.B)   { benchmarkParallelCompression(b, AlgorithmZstd, 3, false) }
+func BenchmarkZstdParallel50MB(b *testing.B) { benchmarkParallelCompression(b, AlgorithmZstd, 3, true) }
+
+// Benchmark decoding many small snappy files with and without the pool
+func benchmarkSnappySmallFiles(b *testing.B, pooled bool) {
+	const files = 100
+	payloads := make([][]byte, files)
+	for i := range payloads {
+		payloads[i], _ = CompressBytes(generateTestData(2*1024), AlgorithmSnappy, 0)
+	}
+	cfs, _ := New(NewMemFS(), &Config{Algorithm: AlgorithmSnappy})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, payload := range payloads {
+			var dec io.ReadCloser
+			if pooled {
+				dec, _ = cfs.newDecompressor(AlgorithmSnappy, bytes.NewReader(payload), nil)
+			} else {
+				dec = io.NopCloser(snappy.NewReader(bytes.NewReader(payload)))
+			}
+			io.Copy(io.Discard, dec)
+			dec.Close()
+		}
+	}
+}
+
+func BenchmarkSnappySmallFilesUnpooled(b *testing.B) { benchmarkSnappySmallFiles(b, false) }
+func BenchmarkSnappySmallFilesPooled(b *testing.B)   { benchmarkSnappySmallFiles(b, true) }
+
+// Benchmark io.Copy of 16MB into and out of a compressed file, through
+// ReadFrom and WriteTo or, with them hidden, one locked call per chunk
+func benchmarkCopyInto(b *testing.B, bulk bool) {
+	testData := generateTestData(16 * 1024 * 1024)
+	cfs, _ := New(NewMemFS(), &Config{Algorithm: AlgorithmLZ4, PreserveExtension: true, StripExtension: true})
+
+	b.SetBytes(int64(len(testData)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		f, _ := cfs.Create("copy.bin")
+		if bulk {
+			io.Copy(f, onlyReader{bytes.NewReader(testData)})
+		} else {
+			io.Copy(onlyWriter{f}, onlyReader{bytes.NewReader(testData)})
+		}
+		f.Close()
+	}
+}
+
+func benchmarkCopyOutOf(b *testing.B, bulk bool) {
+	testData := generateTestData(16 * 1024 * 1024)
+	cfs, _ := New(NewMemFS(), &Config{Algorithm: AlgorithmLZ4, PreserveExtension: true, StripExtension: true})
+	f, _ := cfs.Create("copy.bin")
+	f.Write(testData)
+	f.Close()
+
+	b.SetBytes(int64(len(testData)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		f, _ := cfs.Open("copy.bin")
+		if bulk {
+			io.Copy(onlyWriter{io.Discard}, f)
+		} else {
+			io.Copy(onlyWriter{io.Discard}, onlyReader{f})
+		}
+		f.Close()
+	}
+}
+
+func BenchmarkCopyIntoFileChunked16MB(b *testing.B)  { benchmarkCopyInto(b, false) }
+func BenchmarkCopyIntoFileBulk16MB(b *testing.B)     { benchmarkCopyInto(b, true) }
+func BenchmarkCopyOutOfFileChunked16MB(b *testing.B) { benchmarkCopyOutOf(b, false) }
+func BenchmarkCopyOutOfFileBulk16MB(b *testing.B)    { benchmarkCopyOutOf(b, true) }
+
+// slowFiler counts Read and Write calls on its files and delays each one,
+// like a network filesystem paying a round trip per call
+type slowFiler struct {
+	absfs.Filer
+	reads, writes int64
+}
+
+type slowFile struct {
+	absfs.File
+	filer *slowFiler
+}
+
+const slowCallDelay = 20 * time.Microsecond
+
+func (s *slowFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	f, err := s.Filer.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &slowFile{File: f, filer: s}, nil
+}
+
+func (f *slowFile) Read(p []byte) (int, error) {
+	atomic.AddInt64(&f.filer.reads, 1)
+	time.Sleep(slowCallDelay)
+	return f.File.Read(p)
+}
+
+func (f *slowFile) Write(p []byte) (int, error) {
+	atomic.AddInt64(&f.filer.writes, 1)
+	time.Sleep(slowCallDelay)
+	return f.File.Write(p)
+}
+
+// Benchmark a 4MB gzip write and read back over a slow base, reporting
+// the base calls each makes under the given BufferSize
+func benchmarkBufferSize(b *testing.B, bufferSize int) {
+	testData := cdcTestData(4 * 1024 * 1024)
+	base := &slowFiler{Filer: NewMemFS()}
+	cfs, _ := New(base, &Config{Algorithm: AlgorithmGzip, Level: 6, PreserveExtension: true, StripExtension: true, BufferSize: bufferSize})
+
+	b.SetBytes(int64(len(testData)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		f, _ := cfs.Create("slow.bin")
+		f.Write(testData)
+		f.Close()
+
+		f, _ = cfs.Open("slow.bin")
+		io.Copy(io.Discard, f)
+		f.Close()
+	}
+
+	b.ReportMetric(float64(atomic.LoadInt64(&base.writes))/float64(b.N), "writes/op")
+	b.ReportMetric(float64(atomic.LoadInt64(&base.reads))/float64(b.N), "reads/op")
+}
+
+func BenchmarkBufferSize4KB(b *testing.B)   { benchmarkBufferSize(b, 4*1024) }
+func BenchmarkBufferSize64KB(b *testing.B)  { benchmarkBufferSize(b, 64*1024) }
+func BenchmarkBufferSize256KB(b *testing.B) { benchmarkBufferSize(b, 256*1024) }
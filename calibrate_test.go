@@ -0,0 +1,32 @@
+package compressfs
+
+import "testing"
+
+// TestCalibrateThroughput tests that every algorithm reports positive rates
+func TestCalibrateThroughput(t *testing.T) {
+	results, err := CalibrateThroughput(64 * 1024)
+	if err != nil {
+		t.Fatalf("CalibrateThroughput failed: %v", err)
+	}
+
+	for _, algo := range probeOrder {
+		if !writable(algo) {
+			continue
+		}
+		r, ok := results[algo]
+		if !ok {
+			t.Errorf("%s: missing from results", algo)
+			continue
+		}
+		if r.CompressMBps <= 0 || r.DecompressMBps <= 0 {
+			t.Errorf("%s: expected positive throughput, got %.1f and %.1f MB/s", algo, r.CompressMBps, r.DecompressMBps)
+		}
+		if r.Ratio <= 0 || r.Ratio >= 1 {
+			t.Errorf("%s: expected the sample to compress, got ratio %.3f", algo, r.Ratio)
+		}
+	}
+
+	if _, err := CalibrateThroughput(0); err == nil {
+		t.Error("Expected an error for a zero sample size")
+	}
+}
@@ -0,0 +1,218 @@
+package compressfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/absfs/absfs"
+)
+
+// Chunk sizes under Config.ContentDefinedChunking. A boundary is cut where
+// the gear hash of the bytes since the last boundary has its low 16 bits
+// clear, which averages 64KB past the minimum.
+const (
+	cdcMinChunk = 16 << 10
+	cdcMaxChunk = 256 << 10
+	cdcMask     = 1<<16 - 1
+)
+
+// Chunk index footer written after the chunks: the compressed and
+// uncompressed size of each chunk (uint32 each, little endian), sealed with
+// chunkIndexMagic
+const (
+	chunkIndexMagic     = "CFSC"
+	chunkIndexEntrySize = 8
+)
+
+// gearTable holds the per-byte values of the gear rolling hash. It is
+// generated from a fixed seed, so boundaries are stable across processes.
+var gearTable = func() (table [256]uint64) {
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range table {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		table[i] = z ^ z>>31
+	}
+	return table
+}()
+
+// chunkEntry describes one chunk in a chunk index
+type chunkEntry struct {
+	compressed   uint32
+	uncompressed uint32
+}
+
+// useContentChunking reports whether writes with algo are split at
+// content-defined boundaries. The chunks are independent streams, so only
+// algorithms that concatenate qualify, and not seekable zstd, which has its
+// own frame layout.
+func (cfs *FS) useContentChunking(algo Algorithm) bool {
+	if !cfs.config.ContentDefinedChunking || !concatenates(algo) {
+		return false
+	}
+	return !(algo == AlgorithmZstd && cfs.config.Seekable)
+}
+
+// chunkingWriter splits its input at content-defined boundaries and writes
+// each chunk to w as an independent compressed stream. An edit then only
+// changes the compressed bytes of the chunks it touches: boundaries after
+// it are found again at the same content. Close writes the chunk index.
+type chunkingWriter struct {
+	cfs    *FS
+	algo   Algorithm
+	level  int
+	w      io.Writer
+	buf    []byte
+	hash   uint64
+	out    bytes.Buffer
+	index  []chunkEntry
+	err    error
+	closed bool
+}
+
+// newChunkingCompressor creates a compressor writing algo to w in
+// content-defined chunks
+func (cfs *FS) newChunkingCompressor(algo Algorithm, level int, w io.Writer) io.WriteCloser {
+	return &chunkingWriter{
+		cfs:   cfs,
+		algo:  algo,
+		level: level,
+		w:     w,
+		buf:   make([]byte, 0, cdcMinChunk),
+	}
+}
+
+func (c *chunkingWriter) Write(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	start := 0
+	for i, v := range b {
+		c.hash = c.hash<<1 + gearTable[v]
+		size := len(c.buf) + i + 1 - start
+		if (size >= cdcMinChunk && c.hash&cdcMask == 0) || size >= cdcMaxChunk {
+			c.buf = append(c.buf, b[start:i+1]...)
+			start = i + 1
+			if err := c.flushChunk(); err != nil {
+				return start, err
+			}
+		}
+	}
+	c.buf = append(c.buf, b[start:]...)
+	return len(b), nil
+}
+
+// flushChunk compresses the buffered chunk as a complete stream, writes it
+// and records it in the index
+func (c *chunkingWriter) flushChunk() error {
+	c.out.Reset()
+	compressor, err := c.cfs.newCompressor(c.algo, c.level, &c.out)
+	if err == nil {
+		if _, err = compressor.Write(c.buf); err != nil {
+			compressor.Close()
+		} else {
+			err = compressor.Close()
+		}
+	}
+	if err == nil {
+		_, err = c.w.Write(c.out.Bytes())
+	}
+	if err != nil {
+		c.err = err
+		return err
+	}
+
+	c.index = append(c.index, chunkEntry{compressed: uint32(c.out.Len()), uncompressed: uint32(len(c.buf))})
+	c.buf = c.buf[:0]
+	c.hash = 0
+	return nil
+}
+
+// Flush ends the current chunk early and writes it. The chunk index is only
+// written on Close, and the early boundary shifts the chunks after it.
+func (c *chunkingWriter) Flush() error {
+	if c.err != nil || len(c.buf) == 0 {
+		return c.err
+	}
+	return c.flushChunk()
+}
+
+// Close compresses the final chunk and writes the chunk index. An empty
+// input still produces one empty stream.
+func (c *chunkingWriter) Close() error {
+	if c.closed {
+		return c.err
+	}
+	c.closed = true
+
+	if c.err == nil && (len(c.buf) > 0 || len(c.index) == 0) {
+		c.flushChunk()
+	}
+	if c.err != nil {
+		return c.err
+	}
+	_, c.err = c.w.Write(encodeChunkIndex(c.index))
+	return c.err
+}
+
+// encodeChunkIndex returns the footer recording index
+func encodeChunkIndex(index []chunkEntry) []byte {
+	body := make([]byte, 0, len(index)*chunkIndexEntrySize)
+	for _, e := range index {
+		body = binary.LittleEndian.AppendUint32(body, e.compressed)
+		body = binary.LittleEndian.AppendUint32(body, e.uncompressed)
+	}
+	return sealFooter(body, chunkIndexMagic)
+}
+
+// readChunkIndex reads the chunk index at the end of a stored file of the
+// given size and returns it with the size of the chunk data before it. It
+// reports false when the file does not end in an index whose chunks account
+// for the rest of the file.
+func readChunkIndex(r io.ReaderAt, size int64) ([]chunkEntry, int64, bool) {
+	entries, dataSize, ok := readFooter(r, size, chunkIndexMagic)
+	if !ok || len(entries)%chunkIndexEntrySize != 0 {
+		return nil, 0, false
+	}
+	index := make([]chunkEntry, len(entries)/chunkIndexEntrySize)
+	var total int64
+	for i := range index {
+		index[i] = chunkEntry{
+			compressed:   binary.LittleEndian.Uint32(entries[i*chunkIndexEntrySize:]),
+			uncompressed: binary.LittleEndian.Uint32(entries[i*chunkIndexEntrySize+4:]),
+		}
+		total += int64(index[i].compressed)
+	}
+	if total != dataSize {
+		return nil, 0, false
+	}
+	return index, dataSize, true
+}
+
+// withChunkIndex hides the chunk index of a stored file opened for reading
+// and returns the index, or returns f unchanged when f has no index
+func (cfs *FS) withChunkIndex(f absfs.File) (absfs.File, []chunkEntry) {
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return f, nil
+	}
+	index, size, ok := readChunkIndex(f, info.Size())
+	if !ok {
+		return f, nil
+	}
+	return &trimmedFile{File: f, size: size}, index
+}
+
+// chunkedSize returns the decompressed size of a chunked file from its index
+func chunkedSize(index []chunkEntry) int64 {
+	var total int64
+	for _, e := range index {
+		total += int64(e.uncompressed)
+	}
+	return total
+}
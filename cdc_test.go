@@ -0,0 +1,124 @@
+package compressfs
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// cdcTestData returns compressible text that does not repeat, so chunk
+// boundaries depend on the content
+func cdcTestData(size int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel"}
+	var data []byte
+	for len(data) < size {
+		data = append(data, fmt.Sprintf("%s %d\n", words[rng.Intn(len(words))], rng.Intn(1000))...)
+	}
+	return data[:size]
+}
+
+// storedChunks splits a stored file into its compressed chunks by its index
+func storedChunks(t *testing.T, stored []byte) [][]byte {
+	t.Helper()
+	index, size, ok := readChunkIndex(bytes.NewReader(stored), int64(len(stored)))
+	if !ok {
+		t.Fatal("Stored file has no chunk index")
+	}
+	var chunks [][]byte
+	var off int64
+	for _, e := range index {
+		chunks = append(chunks, stored[off:off+int64(e.compressed)])
+		off += int64(e.compressed)
+	}
+	if off != size {
+		t.Fatalf("Chunks cover %d bytes, expected %d", off, size)
+	}
+	return chunks
+}
+
+// TestContentDefinedChunking tests that an insertion near the start of a
+// file leaves the compressed bytes of the later chunks unchanged
+func TestContentDefinedChunking(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZstd} {
+		t.Run(string(algo), func(t *testing.T) {
+			base := NewMemFS()
+			cfs, err := New(base, &Config{
+				Algorithm:              algo,
+				PreserveExtension:      true,
+				StripExtension:         true,
+				ContentDefinedChunking: true,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+
+			data := cdcTestData(2 << 20)
+			edited := append(append(append([]byte{}, data[:1000]...), "inserted bytes"...), data[1000:]...)
+			writeTestFile(t, cfs, "a.txt", data)
+			writeTestFile(t, cfs, "b.txt", edited)
+
+			if got := readTestFile(t, cfs, "a.txt"); !bytes.Equal(got, data) {
+				t.Fatal("Chunked file did not round-trip")
+			}
+			if got := readTestFile(t, cfs, "b.txt"); !bytes.Equal(got, edited) {
+				t.Fatal("Edited chunked file did not round-trip")
+			}
+
+			ext := GetExtension(algo)
+			original := storedChunks(t, readBaseFile(t, base, "a.txt"+ext))
+			changed := storedChunks(t, readBaseFile(t, base, "b.txt"+ext))
+			if len(original) < 8 {
+				t.Fatalf("Expected many chunks, got %d", len(original))
+			}
+
+			known := make(map[string]bool)
+			for _, chunk := range original {
+				known[string(chunk)] = true
+			}
+			differing := 0
+			for _, chunk := range changed {
+				if !known[string(chunk)] {
+					differing++
+				}
+			}
+			if differing > 2 {
+				t.Errorf("%d of %d chunks changed after an insertion near the start", differing, len(changed))
+			}
+		})
+	}
+}
+
+// TestContentDefinedChunkingSmallFile tests that a file below the minimum
+// chunk size is one chunk and reports its size
+func TestContentDefinedChunkingSmallFile(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true, ContentDefinedChunking: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := cdcTestData(4096)
+	writeTestFile(t, cfs, "small.txt", data)
+	if chunks := storedChunks(t, readBaseFile(t, base, "small.txt.gz")); len(chunks) != 1 {
+		t.Errorf("Small file stored as %d chunks, expected 1", len(chunks))
+	}
+
+	info, err := cfs.Stat("small.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	f, err := cfs.Open("small.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	opened, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat of open file failed: %v", err)
+	}
+	if opened.Size() != int64(len(data)) {
+		t.Errorf("Size = %d, expected %d (stored %d)", opened.Size(), len(data), info.Size())
+	}
+}
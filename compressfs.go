@@ -2,16 +2,19 @@ package compressfs
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/absfs/absfs"
+	"github.com/klauspost/compress/zstd"
 )
 
 // Algorithm represents a compression algorithm
@@ -23,6 +26,9 @@ const (
 	AlgorithmLZ4    Algorithm = "lz4"
 	AlgorithmBrotli Algorithm = "brotli"
 	AlgorithmSnappy Algorithm = "snappy"
+	AlgorithmXz     Algorithm = "xz"
+	AlgorithmZlib   Algorithm = "zlib"
+	AlgorithmBzip2  Algorithm = "bzip2" // Read-only
 	AlgorithmAuto   Algorithm = "auto"
 )
 
@@ -36,6 +42,10 @@ type AlgorithmRule struct {
 
 	// Compression level override (-1 = use default, 0+ = specific level)
 	Level int
+
+	// NormalizeLineEndings overrides Config.NormalizeLineEndings for
+	// matching files when set
+	NormalizeLineEndings *bool
 }
 
 // Config holds compression filesystem configuration
@@ -44,32 +54,115 @@ type Config struct {
 	Algorithm Algorithm
 
 	// Compression level (algorithm-specific)
-	// gzip: 1-9 (6 default)
-	// zstd: 1-22 (3 default)
-	// lz4: 1-16 (1 default)
-	// brotli: 0-11 (6 default)
+	// gzip, zlib: 1-9 (6 default), 0 stores without compression
+	// zstd: 1-22 (3 default), 0 is the fastest setting
+	// lz4: 1-16 (1 default), ignored by the encoder
+	// brotli: 0-11 (6 default), 0 is the fastest setting
+	// xz: 0-9 (6 default), 0 is the smallest preset dictionary
 	// snappy: ignored (no levels)
+	// A zero Level selects the algorithm's default unless LevelSet is true.
+	// Levels are applied as normalizeLevel maps them.
 	Level int
 
+	// LevelSet marks a zero Level as an explicit choice, such as gzip
+	// level 0 (store without compression) or zstd's fastest setting
+	LevelSet bool
+
 	// Skip patterns - regex patterns for files to skip compression
 	// Examples: []string{`\.jpg$`, `\.png$`, `\.mp4$`, `\.zip$`}
 	SkipPatterns []string
 
+	// StrictSkip makes opening a skip-matched name for writing fail with
+	// ErrStaleCompressed when a compressed copy of it is stored, such as one
+	// written before the skip pattern was added. Otherwise the stale copy is
+	// removed once the verbatim file is opened.
+	StrictSkip bool
+
 	// Auto-detect already compressed content by magic bytes
 	AutoDetect bool // default: true
 
+	// TryBrotli attempts a brotli decode of the file head when magic-byte
+	// detection fails and the extension is unknown. Brotli has no magic
+	// bytes, so this is a best-effort fallback for AutoDetect.
+	TryBrotli bool
+
+	// DecodeFallbacks lists algorithms tried in order when the head of a
+	// stream does not decode as the algorithm implied by its magic bytes or
+	// extension, for recovering mislabeled files
+	DecodeFallbacks []Algorithm
+
 	// Preserve original extension (e.g., file.txt.gz vs file.gz)
 	PreserveExtension bool // default: true
 
 	// Strip compression extensions on reads (transparent)
 	StripExtension bool // default: true
 
-	// Buffer size for streaming (default: 64KB)
+	// Template for where the codec extension goes in stored names, using
+	// {name}, {ext} and {algo} (e.g. "{name}.{algo}{ext}" stores data.txt as
+	// data.gz.txt). Empty appends the codec extension as a suffix.
+	// When set it replaces PreserveExtension.
+	ExtensionPlacement string // default: "" (suffix)
+
+	// Buffer size for streaming (default: 64KB). Compressed streams are
+	// written to and read from the base file through a buffer of this size,
+	// so larger values mean fewer, larger base Read and Write calls.
 	BufferSize int
 
+	// SnappyBlockSize, if set, buffers snappy writes into blocks of this
+	// many bytes before they reach the encoder. The snappy framing format
+	// limits each chunk to 64KB of input regardless, so this tunes how work
+	// is batched for streams written in small pieces rather than chunk size.
+	SnappyBlockSize int // default: 0 (write through)
+
+	// Deterministic makes identical input produce byte-identical output by
+	// pinning header fields that could vary between runs or hosts, such as
+	// the gzip modification time, name and OS byte
+	Deterministic bool
+
+	// SyncOnClose syncs written files to stable storage after the
+	// compressor is flushed and before Close returns
+	SyncOnClose bool
+
+	// Preallocate extends the base file to the estimated compressed size
+	// of the data buffered when compression starts, judged from its first
+	// 64KB, and truncates it to the exact size written at Close. This can
+	// reduce fragmentation on some bases. TrySmallest writes and appends
+	// are not preallocated, and bases whose Truncate fails are written as
+	// usual.
+	Preallocate bool
+
 	// Minimum file size to compress (skip smaller files)
+	// With StreamingSample, MinSize is judged on the buffered head only: a
+	// file that outgrows the head buffer is streamed compressed, so MinSize
+	// may not exceed BufferSize in that mode.
 	MinSize int64 // default: 0 (compress all)
 
+	// MaxExtensionProbes limits how many compression extensions are tried,
+	// in resolution order, when resolving a logical name in Open, Stat,
+	// Remove and similar operations. Lower values trade completeness for
+	// latency on slow bases.
+	MaxExtensionProbes int // default: 0 (probe all)
+
+	// StreamingSample buffers only the first BufferSize bytes of a write,
+	// compresses that head as a sample, and then either streams the rest
+	// through the compressor or writes it raw if the sample did not compress
+	// well. Memory use is bounded by BufferSize.
+	//
+	// Without it, writes are still streamed: only a prefix of up to MinSize
+	// (or just over 10MB with EnableAutoTuning, where its last level tier
+	// starts) is buffered to make the size decisions, and files that never reach it are decided at
+	// Close. TrySmallest, ZstdContentSize and AsyncCompress need the whole
+	// file and buffer it in memory until Close.
+	StreamingSample bool
+
+	// SkipByContentType detects the media type of the head of each write
+	// with http.DetectContentType and stores already-compressed formats,
+	// such as JPEG, PNG, MP4 or ZIP, uncompressed. Unlike SkipPatterns it
+	// catches mislabeled files, like a JPEG named .dat. The decision is
+	// made when the head is buffered: on the StreamingSample head, once a
+	// streaming write reaches its threshold, or at Close.
+	SkipByContentType bool
+
 	// ===== ADVANCED FEATURES (Phase 5) =====
 
 	// AlgorithmRules defines file-specific algorithm selection
@@ -81,15 +174,48 @@ type Config struct {
 	EnableAutoTuning bool
 
 	// AutoTuneSizeThreshold is the file size threshold for auto-tuning (bytes)
-	// Files larger than this may use lower compression levels for speed
+	// Files larger than this may use lower compression levels for speed,
+	// and files over 10MB the lowest
 	AutoTuneSizeThreshold int64 // default: 1MB
 
 	// ZstdDictionary is a pre-trained dictionary for zstd compression
 	// Improves compression ratio for similar files
 	ZstdDictionary []byte
 
+	// Dictionaries holds additional zstd dictionaries keyed by dictionary ID.
+	// When a frame was written with a dictionary other than ZstdDictionary,
+	// the matching entry is used to read it.
+	Dictionaries map[uint32][]byte
+
+	// ZstdContentSize records the decompressed size in the zstd frame header
+	// when it is known before compression starts, so Stat on an open file
+	// can report the original size. Streamed writes omit it.
+	ZstdContentSize bool
+
+	// ZstdEncoderOptions and ZstdDecoderOptions are appended after the
+	// options derived from Level and the dictionaries, so they override them
+	ZstdEncoderOptions []zstd.EOption
+	ZstdDecoderOptions []zstd.DOption
+
+	// ZstdWindowSize sets the zstd encoder window, a power of two from
+	// zstd.MinWindowSize to zstd.MaxWindowSize. Larger windows find matches
+	// further back at the cost of memory. Zero keeps the 8MB default.
+	ZstdWindowSize int
+
+	// MaxCompressMemory caps the estimated memory of each encoder. Before
+	// an encoder is created, a zstd window over the budget is halved and
+	// then the level lowered until it fits, counted in
+	// Stats.CompressMemoryDowngrades; when nothing fits, the write fails
+	// with ErrMemoryBudget. The fitted window overrides one set in
+	// ZstdEncoderOptions. Zero means no limit.
+	MaxCompressMemory int64
+
 	// EnableParallelCompression enables parallel compression for large files
 	// Only applies to files larger than ParallelThreshold
+	// Chunks are compressed as independent streams across runtime.NumCPU
+	// goroutines and concatenated in order: gzip members, zstd frames or
+	// snappy streams. Other algorithms are compressed serially. Writes are
+	// buffered up to ParallelThreshold to decide.
 	EnableParallelCompression bool
 
 	// ParallelThreshold is the minimum file size for parallel compression
@@ -98,12 +224,158 @@ type Config struct {
 	// ParallelChunkSize is the chunk size for parallel compression
 	ParallelChunkSize int // default: 1MB
 
+	// Seekable writes zstd files as independent frames of ParallelChunkSize
+	// input bytes followed by a seek table, in the zstd seekable format.
+	// Reads of such files support Seek and ReadAt by decoding only the
+	// frame holding the requested range. Other readers see an ordinary
+	// multi-frame zstd stream.
+	Seekable bool
+
+	// AllowSequentialReadAt lets ReadAt on compressed files that are not
+	// seekable decode from the start and discard up to the offset, in
+	// O(offset) time, instead of failing with ErrSeekNotSupported. Each
+	// open file keeps its place, so forward ReadAt calls continue from the
+	// last one; only reading behind it decodes from the start again.
+	AllowSequentialReadAt bool
+
+	// AppendMembers makes OpenFile with os.O_APPEND on a gzip, snappy or,
+	// unless Seekable is set, zstd file write the new data as another
+	// compressed member after the stored data, which decoders read back as
+	// one stream. Without it, and for other algorithms, the existing content
+	// is decompressed and the file rewritten as a single stream on Close.
+	AppendMembers bool
+
+	// ContainerFormat selects how appends lay out a stored file. MultiMember
+	// appends members as AppendMembers does; the default, SingleStream,
+	// leaves the choice to AppendMembers.
+	ContainerFormat ContainerFormat
+
+	// DisableAppendRewrite makes an append that would rewrite the file fail
+	// with ErrAppendNotSupported instead
+	DisableAppendRewrite bool
+
+	// EmbedFileMetadata appends a footer recording the file mode and
+	// modification time to each file written compressed, so Restore can
+	// recreate them on bases that do not keep attributes, such as object
+	// storage. Member appends are disabled, since a member would follow the
+	// footer.
+	//
+	// This and the footers of ContentDefinedChunking,
+	// ReportUncompressedSize, StoreContentHash and NormalizeLineEndings only
+	// ever follow compressed data. Reads through any FS hide them, whether
+	// or not it sets the options, but a stored file with a footer is no
+	// longer a plain .gz or .zst: other decoders see it as trailing data.
+	EmbedFileMetadata bool
+
+	// ContentDefinedChunking splits gzip, snappy and, unless Seekable is
+	// set, zstd writes at boundaries chosen by a rolling hash of the
+	// content, compressing each chunk as an independent stream followed by
+	// a chunk index footer. An insertion or deletion then changes only the
+	// compressed chunks around it, which keeps the rest of the file
+	// identical for storage that deduplicates by chunk. It takes precedence
+	// over parallel compression. Member appends are disabled.
+	ContentDefinedChunking bool
+
+	// ReportUncompressedSize makes Stat report the uncompressed size of
+	// compressed files instead of their stored size. Files written in a
+	// format that records no size of its own, which is all but gzip and
+	// seekable zstd, get a size footer; other formats' sizes are read from
+	// the stream.
+	ReportUncompressedSize bool
+
+	// StoreContentHash appends a footer holding a hash of the uncompressed
+	// bytes to each file written compressed, for deduplication and tamper
+	// detection. ContentHash reads it back without decompressing the file
+	// and VerifyContentHash checks it against the content. Member appends
+	// are disabled.
+	StoreContentHash bool
+
+	// ContentHashAlgorithm selects the StoreContentHash hash (default:
+	// sha256)
+	ContentHashAlgorithm HashAlgorithm
+
+	// NormalizeLineEndings converts CRLF line endings to LF before
+	// compressing files matching TextPatterns, which compresses text
+	// better. Only files that use CRLF throughout are converted, and a
+	// marker footer after the compressed data records it. Such files are
+	// buffered whole until Close.
+	NormalizeLineEndings bool
+
+	// TextPatterns are regex patterns naming the text files normalized
+	// under NormalizeLineEndings. When empty, common text extensions such
+	// as .txt, .csv, .log, .md and .json are matched.
+	TextPatterns []string
+
+	// RestoreLineEndings converts the line endings of files recorded as
+	// normalized back to CRLF on read. Sizes reported for such files are
+	// still those of the normalized text.
+	RestoreLineEndings bool
+
 	// AllowRecompression allows transparent re-compression when reading
 	// files compressed with a different algorithm
+	// The stored file is rewritten in RecompressionTarget under its new
+	// extension before the read is served. The new copy is written to a
+	// temporary name and renamed into place before the original is removed,
+	// so an interrupted migration leaves the original readable.
 	AllowRecompression bool
 
 	// RecompressionTarget is the target algorithm for re-compression
 	RecompressionTarget Algorithm
+
+	// OnDecision, if set, is called with the compression decision made for
+	// each opened file, for troubleshooting why a file was or was not
+	// compressed. It must not call back into the file it describes.
+	OnDecision func(DecisionEvent)
+
+	// DecisionLog, if set, has each write decision appended to it, for
+	// auditing and for asserting on behavior in tests. Read it through
+	// FS.Decisions while the filesystem is in use.
+	DecisionLog *[]Decision
+
+	// Metrics, if set, is notified of each compressed write and read when
+	// the file is closed
+	Metrics Metrics
+
+	// ShareSubStats makes filesystems created by Sub and SubFS count into
+	// this filesystem's statistics instead of keeping their own
+	ShareSubStats bool
+
+	// RetryPolicy, if set, retries transient base filesystem errors around
+	// OpenFile, Read and Write
+	RetryPolicy *RetryPolicy
+
+	// ReadTimeout, if set, bounds each read from the base filesystem, so a
+	// hung network base fails with an error matching os.ErrDeadlineExceeded
+	// instead of blocking forever. Once a read has timed out, the file
+	// cannot be read further.
+	ReadTimeout time.Duration // default: 0 (no timeout)
+
+	// TolerateTransientEOF re-reads a base file that reports EOF before
+	// the end of its stored size, as some network filesystems do
+	// transiently, instead of letting the decoder fail on a truncated
+	// stream. Read and ReadAt are retried a few times with a short,
+	// doubling backoff.
+	TolerateTransientEOF bool
+
+	// MaxOpenFiles caps how many files opened through the filesystem may be
+	// open at once. Opening another fails with ErrTooManyOpenFiles, or waits
+	// for a Close when BlockOnMaxOpenFiles is set.
+	MaxOpenFiles        int // default: 0 (unlimited)
+	BlockOnMaxOpenFiles bool
+
+	// AsyncCompress makes Close on a buffered write return immediately and
+	// compress and store the file on a background goroutine. Errors are
+	// reported to OnAsyncError instead of Close; call FS.Wait to drain.
+	AsyncCompress bool
+
+	// OnAsyncError, if set, receives the logical name and error of each
+	// AsyncCompress write that failed
+	OnAsyncError func(name string, err error)
+
+	// TrySmallest compresses each write with every listed algorithm and keeps
+	// the smallest result under that algorithm's extension. The whole file is
+	// buffered in memory, so StreamingSample is ignored when this is set.
+	TrySmallest []Algorithm
 }
 
 // DefaultConfig returns a config with sensible defaults
@@ -141,6 +413,29 @@ type Stats struct {
 	BytesDecompressed int64
 
 	AlgorithmCounts sync.Map // map[Algorithm]int64
+
+	// Bytes written to compressed files, and the compressed bytes stored
+	// for them without footers, by algorithm
+	BytesWrittenByAlgorithm    sync.Map // map[Algorithm]int64
+	BytesCompressedByAlgorithm sync.Map // map[Algorithm]int64
+
+	// TrySmallest results: winning algorithm counts, and bytes saved by the
+	// winners over the first listed algorithm
+	TrySmallestWins       sync.Map // map[Algorithm]int64
+	TrySmallestBytesSaved int64
+
+	// Codecs constructed rather than reused from the pool (see FS.Warmup)
+	EncodersCreated int64
+	DecodersCreated int64
+
+	// Files migrated to RecompressionTarget on read, and migrations that
+	// failed and left the original in place
+	FilesRecompressed    int64
+	RecompressionsFailed int64
+
+	// Encoders created with a lower level or smaller window to fit
+	// Config.MaxCompressMemory
+	CompressMemoryDowngrades int64
 }
 
 // GetAlgorithmCount returns the count for a specific algorithm
@@ -157,6 +452,50 @@ func (s *Stats) IncrementAlgorithmCount(algo Algorithm) {
 	s.AlgorithmCounts.Store(algo, val.(int64)+1)
 }
 
+// GetBytesWrittenByAlgorithm returns the bytes written to files compressed
+// with a specific algorithm
+func (s *Stats) GetBytesWrittenByAlgorithm(algo Algorithm) int64 {
+	if val, ok := s.BytesWrittenByAlgorithm.Load(algo); ok {
+		return val.(int64)
+	}
+	return 0
+}
+
+// GetBytesCompressedByAlgorithm returns the compressed bytes stored for
+// files compressed with a specific algorithm
+func (s *Stats) GetBytesCompressedByAlgorithm(algo Algorithm) int64 {
+	if val, ok := s.BytesCompressedByAlgorithm.Load(algo); ok {
+		return val.(int64)
+	}
+	return 0
+}
+
+// recordAlgorithmBytes adds written bytes compressed with algo into stored
+// bytes to the per-algorithm totals
+func (s *Stats) recordAlgorithmBytes(algo Algorithm, written, stored int64) {
+	addAlgorithmBytes(&s.BytesWrittenByAlgorithm, algo, written)
+	addAlgorithmBytes(&s.BytesCompressedByAlgorithm, algo, stored)
+}
+
+// addAlgorithmBytes adds n to the total for algo in m, retrying when a
+// concurrent Close updates it first
+func addAlgorithmBytes(m *sync.Map, algo Algorithm, n int64) {
+	for {
+		val, loaded := m.LoadOrStore(algo, n)
+		if !loaded || m.CompareAndSwap(algo, val, val.(int64)+n) {
+			return
+		}
+	}
+}
+
+// GetTrySmallestWins returns how often an algorithm won a TrySmallest write
+func (s *Stats) GetTrySmallestWins(algo Algorithm) int64 {
+	if val, ok := s.TrySmallestWins.Load(algo); ok {
+		return val.(int64)
+	}
+	return 0
+}
+
 // TotalCompressionRatio returns the overall compression ratio
 func (s *Stats) TotalCompressionRatio() float64 {
 	if s.BytesWritten == 0 {
@@ -179,6 +518,14 @@ var (
 	ErrSeekNotSupported     = errors.New("compressfs: seek not supported for compressed files")
 	ErrAlreadyCompressed    = errors.New("compressfs: file already compressed")
 	ErrCorruptedData        = errors.New("compressfs: corrupted compressed data")
+	ErrInvalidConfig        = errors.New("compressfs: invalid configuration")
+	ErrStaleCompressed      = errors.New("compressfs: compressed copy of a skipped file exists")
+	ErrTooManyOpenFiles     = errors.New("compressfs: too many open files")
+	ErrAppendNotSupported   = errors.New("compressfs: append not supported without rewriting the file")
+	ErrMemoryBudget         = errors.New("compressfs: encoder exceeds the compression memory budget")
+	ErrNoContentHash        = errors.New("compressfs: no content hash stored")
+	ErrFlushNotSupported    = errors.New("compressfs: flush not supported for this file")
+	ErrNotArchive           = errors.New("compressfs: not a compressfs archive")
 )
 
 // FileSystem interface that compressfs wraps
@@ -208,17 +555,31 @@ type compiledRule struct {
 	pattern   *regexp.Regexp
 	algorithm Algorithm
 	level     int
+	normalize *bool // NormalizeLineEndings override, nil if unset
 }
 
 // FS wraps a FileSystem with compression capabilities
 type FS struct {
-	base   absfs.FileSystem
-	config *Config
-	skip   *regexp.Regexp // Compiled skip patterns
-	rules  []compiledRule  // Compiled algorithm rules
-	stats  Stats
-	cwd    string          // Current working directory
-	mu     sync.RWMutex
+	base      absfs.FileSystem
+	config    *Config
+	skip      *regexp.Regexp     // Compiled skip patterns
+	text      *regexp.Regexp     // Compiled TextPatterns
+	rules     []compiledRule     // Compiled algorithm rules
+	placement *extensionTemplate // Compiled ExtensionPlacement, nil for suffix
+	stats     *Stats             // Shared with the parent of a Sub when ShareSubStats is set
+	cwd       string             // Current working directory
+	mu        sync.RWMutex
+
+	lineEndings bool // Line endings are normalized for some files
+	layered     bool // The base compresses files itself; opens pass through
+
+	decisionMu *sync.Mutex    // Guards appends to Config.DecisionLog, shared with a Sub
+	async      sync.WaitGroup // Outstanding AsyncCompress closes
+	codecs     codecPool      // Reusable encoders and decoders
+	openSlots  chan struct{}  // One token per open file, nil if unlimited; shared with a Sub
+
+	recompressMu sync.Mutex // Serializes AllowRecompression migrations
+	replaceMu    sync.Mutex // Serializes Replace, which writes one temporary name per file
 }
 
 // New creates a new compressed filesystem wrapper
@@ -229,6 +590,21 @@ type FS struct {
 func New(base interface{}, config *Config) (*FS, error) {
 	if config == nil {
 		config = DefaultConfig()
+	} else {
+		config = withDefaults(config)
+	}
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	if config.StreamingSample && config.MinSize > int64(config.BufferSize) {
+		return nil, fmt.Errorf("%w: MinSize %d exceeds the StreamingSample head buffer of %d bytes",
+			ErrInvalidConfig, config.MinSize, config.BufferSize)
+	}
+
+	// A typed nil would pass the type switch and panic on first use
+	if isNil(base) {
+		return nil, errors.New("compressfs: base filesystem is nil")
 	}
 
 	// Convert the base filesystem to absfs.FileSystem
@@ -253,6 +629,14 @@ func New(base interface{}, config *Config) (*FS, error) {
 		return nil, errors.New("compressfs: base must be absfs.FileSystem, absfs.Filer, or compressfs.FileSystem")
 	}
 
+	// A base that compresses already decompresses its files on read and
+	// compresses them on write, so compressing again would only nest
+	// extensions and streams
+	layered := false
+	if c, ok := base.(compressingFS); ok {
+		layered = c.AlreadyCompresses()
+	}
+
 	// Compile skip patterns
 	var skip *regexp.Regexp
 	if len(config.SkipPatterns) > 0 {
@@ -273,6 +657,10 @@ func New(base interface{}, config *Config) (*FS, error) {
 	if len(config.AlgorithmRules) > 0 {
 		rules = make([]compiledRule, 0, len(config.AlgorithmRules))
 		for _, rule := range config.AlgorithmRules {
+			if rule.Level > 0 && !hasLevels(rule.Algorithm) {
+				return nil, fmt.Errorf("%w: rule %q sets level %d but %s has no compression levels",
+					ErrInvalidLevel, rule.Pattern, rule.Level, rule.Algorithm)
+			}
 			re, err := regexp.Compile(rule.Pattern)
 			if err != nil {
 				return nil, err
@@ -281,24 +669,206 @@ func New(base interface{}, config *Config) (*FS, error) {
 				pattern:   re,
 				algorithm: rule.Algorithm,
 				level:     rule.Level,
+				normalize: rule.NormalizeLineEndings,
 			})
 		}
 	}
 
+	// Compile text patterns
+	textPattern := defaultTextPattern
+	if len(config.TextPatterns) > 0 {
+		textPattern = "(?:" + config.TextPatterns[0]
+		for i := 1; i < len(config.TextPatterns); i++ {
+			textPattern += "|" + config.TextPatterns[i]
+		}
+		textPattern += ")"
+	}
+	text, err := regexp.Compile(textPattern)
+	if err != nil {
+		return nil, err
+	}
+	lineEndings := config.NormalizeLineEndings
+	for _, rule := range rules {
+		lineEndings = lineEndings || (rule.normalize != nil && *rule.normalize)
+	}
+
+	for _, algo := range config.TrySmallest {
+		if !writable(algo) {
+			return nil, fmt.Errorf("TrySmallest entry: %w", unsupportedWrite(algo))
+		}
+	}
+
+	// Compile extension placement
+	var placement *extensionTemplate
+	if config.ExtensionPlacement != "" {
+		var err error
+		placement, err = parseExtensionTemplate(config.ExtensionPlacement)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Initialize cwd
 	cwd := "/"
 	if wd, err := absBase.Getwd(); err == nil {
 		cwd = wd
 	}
 
-	return &FS{
-		base:   absBase,
-		config: config,
-		skip:   skip,
-		rules:  rules,
-		stats:  Stats{},
-		cwd:    cwd,
-	}, nil
+	cfs := &FS{
+		base:      absBase,
+		config:    config,
+		skip:      skip,
+		text:      text,
+		rules:     rules,
+		placement: placement,
+		stats:     &Stats{},
+		cwd:       cwd,
+
+		decisionMu: &sync.Mutex{},
+
+		lineEndings: lineEndings,
+		layered:     layered,
+	}
+
+	if config.MaxOpenFiles > 0 {
+		cfs.openSlots = make(chan struct{}, config.MaxOpenFiles)
+	}
+
+	// An unset Level means the algorithm's default, not its level 0
+	if config.Level == 0 && !config.LevelSet {
+		config.Level = cfs.getDefaultLevel(config.Algorithm)
+	}
+
+	return cfs, nil
+}
+
+// compressingFS is implemented by filesystems that compress the files
+// written through them, such as FS itself
+type compressingFS interface {
+	AlreadyCompresses() bool
+}
+
+// AlreadyCompresses reports that files written through the filesystem are
+// stored compressed. A compressfs created over another one detects this
+// and passes files through to it, leaving compression to the inner layer,
+// instead of compressing them a second time under a second extension.
+func (cfs *FS) AlreadyCompresses() bool {
+	return true
+}
+
+// isNil reports whether v is nil or an interface holding a nil pointer,
+// map, slice, channel or func
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// cloneConfig returns a deep copy of config, so a filesystem built from it
+// shares no mutable state with config's owner. Callbacks, Metrics and
+// DecisionLog are sinks and stay shared.
+func cloneConfig(config *Config) *Config {
+	c := *config
+	c.SkipPatterns = append([]string(nil), config.SkipPatterns...)
+	c.TextPatterns = append([]string(nil), config.TextPatterns...)
+	c.DecodeFallbacks = append([]Algorithm(nil), config.DecodeFallbacks...)
+	c.AlgorithmRules = append([]AlgorithmRule(nil), config.AlgorithmRules...)
+	c.TrySmallest = append([]Algorithm(nil), config.TrySmallest...)
+	c.ZstdDictionary = append([]byte(nil), config.ZstdDictionary...)
+	c.ZstdEncoderOptions = append([]zstd.EOption(nil), config.ZstdEncoderOptions...)
+	c.ZstdDecoderOptions = append([]zstd.DOption(nil), config.ZstdDecoderOptions...)
+	if config.RetryPolicy != nil {
+		policy := *config.RetryPolicy
+		c.RetryPolicy = &policy
+	}
+	if config.Dictionaries != nil {
+		c.Dictionaries = make(map[uint32][]byte, len(config.Dictionaries))
+		for id, dict := range config.Dictionaries {
+			c.Dictionaries[id] = append([]byte(nil), dict...)
+		}
+	}
+	return &c
+}
+
+// validateConfig checks the codec, sizes and algorithm rules of config, so
+// misconfiguration fails in New rather than when a file is closed. An empty
+// Algorithm stores files uncompressed and takes no level; an unset Level is
+// replaced by the algorithm's default and is always valid.
+func validateConfig(config *Config) error {
+	if config.Algorithm != "" {
+		if _, _, ok := levelRange(config.Algorithm); !ok {
+			return unsupportedWrite(config.Algorithm)
+		}
+		if config.Level != 0 || config.LevelSet {
+			if err := validateCodec(config.Algorithm, config.Level); err != nil {
+				return err
+			}
+		}
+	}
+	if _, ok := hashIDs[config.ContentHashAlgorithm]; !ok && config.ContentHashAlgorithm != "" {
+		return fmt.Errorf("%w: unknown ContentHashAlgorithm %q", ErrInvalidConfig, config.ContentHashAlgorithm)
+	}
+	if config.ContainerFormat != SingleStream && config.ContainerFormat != MultiMember {
+		return fmt.Errorf("%w: unknown ContainerFormat %q", ErrInvalidConfig, config.ContainerFormat)
+	}
+	if config.BufferSize < 0 {
+		return fmt.Errorf("%w: negative BufferSize %d", ErrInvalidConfig, config.BufferSize)
+	}
+	if config.MinSize < 0 {
+		return fmt.Errorf("%w: negative MinSize %d", ErrInvalidConfig, config.MinSize)
+	}
+	if config.MaxCompressMemory < 0 {
+		return fmt.Errorf("%w: negative MaxCompressMemory %d", ErrInvalidConfig, config.MaxCompressMemory)
+	}
+	if w := config.ZstdWindowSize; w != 0 && (w < zstd.MinWindowSize || w > zstd.MaxWindowSize || w&(w-1) != 0) {
+		return fmt.Errorf("%w: ZstdWindowSize %d is not a power of two from %d to %d",
+			ErrInvalidConfig, w, zstd.MinWindowSize, zstd.MaxWindowSize)
+	}
+
+	for _, rule := range config.AlgorithmRules {
+		var err error
+		if rule.Level < 0 {
+			// A negative rule level selects the algorithm's default
+			if _, _, ok := levelRange(rule.Algorithm); !ok {
+				err = unsupportedWrite(rule.Algorithm)
+			}
+		} else {
+			err = validateCodec(rule.Algorithm, rule.Level)
+		}
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// withDefaults returns a copy of config with unset sizing fields filled in
+// from DefaultConfig, so a partially-specified config behaves sanely.
+// The caller's config is never modified.
+func withDefaults(config *Config) *Config {
+	defaults := DefaultConfig()
+	merged := *config
+
+	if merged.BufferSize == 0 {
+		merged.BufferSize = defaults.BufferSize
+	}
+	if merged.AutoTuneSizeThreshold == 0 {
+		merged.AutoTuneSizeThreshold = defaults.AutoTuneSizeThreshold
+	}
+	if merged.ParallelThreshold == 0 {
+		merged.ParallelThreshold = defaults.ParallelThreshold
+	}
+	if merged.ParallelChunkSize == 0 {
+		merged.ParallelChunkSize = defaults.ParallelChunkSize
+	}
+
+	return &merged
 }
 
 // filerAdapter adapts the old FileSystem interface to absfs.Filer
@@ -376,9 +946,13 @@ func (cfs *FS) shouldSkip(name string) bool {
 // selectAlgorithm selects the compression algorithm and level based on rules
 // Returns (algorithm, level, useDefaults)
 func (cfs *FS) selectAlgorithm(name string, fileSize int64) (Algorithm, int, bool) {
-	cfs.mu.RLock()
-	defer cfs.mu.RUnlock()
+	algo, level := cfs.Codec()
+	return cfs.selectCodec(name, fileSize, algo, level)
+}
 
+// selectCodec is selectAlgorithm with algo and level as the default codec,
+// so a file keeps the codec in effect when it was opened
+func (cfs *FS) selectCodec(name string, fileSize int64, algo Algorithm, level int) (Algorithm, int, bool) {
 	// Check algorithm rules first (highest priority)
 	for _, rule := range cfs.rules {
 		if rule.pattern.MatchString(name) {
@@ -393,60 +967,156 @@ func (cfs *FS) selectAlgorithm(name string, fileSize int64) (Algorithm, int, boo
 		}
 	}
 
-	// Use default algorithm and level
-	algo := cfs.config.Algorithm
-	level := cfs.config.Level
-
-	// Apply auto-tuning if enabled
+	// Apply auto-tuning to the default algorithm and level if enabled
 	if cfs.config.EnableAutoTuning && fileSize > 0 {
-		level = cfs.autoTuneLevel(algo, fileSize)
+		level = cfs.autoTuneLevel(algo, level, fileSize)
 	}
 
 	return algo, level, true
 }
 
-// getDefaultLevel returns the default compression level for an algorithm
+// getDefaultLevel returns the default compression level for an algorithm,
+// or 0 for one files cannot be written with
 func (cfs *FS) getDefaultLevel(algo Algorithm) int {
+	if level, ok := defaultLevel(algo); ok {
+		return level
+	}
+	return 0
+}
+
+// levelRange returns the lowest and highest compression level accepted for
+// a known algorithm. Gzip's negative levels select its default and
+// Huffman-only modes; lz4 and snappy accept their levels but ignore them.
+func levelRange(algo Algorithm) (int, int, bool) {
 	switch algo {
 	case AlgorithmGzip:
-		return 6
+		return -2, 9, true
 	case AlgorithmZstd:
-		return 3
+		return 0, 22, true
 	case AlgorithmLZ4:
-		return 1
+		return 0, 16, true
+	case AlgorithmBrotli:
+		return 0, 11, true
+	case AlgorithmSnappy:
+		return 0, 0, true
+	case AlgorithmXz:
+		return 0, 9, true
+	case AlgorithmZlib:
+		return -2, 9, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// normalizeLevel maps a compression level to the one the encoder for algo
+// is created with, the same for CompressBytes and files written through FS.
+// Level 0 is a real level everywhere: store for gzip and zlib, the fastest
+// setting for zstd and brotli, the smallest preset for xz. Levels past the
+// range are clamped to it, except that gzip and zlib levels below -2 select
+// their default. Lz4 and snappy ignore levels, so theirs become 0.
+func normalizeLevel(algo Algorithm, level int) int {
+	if !hasLevels(algo) {
+		return 0
+	}
+	lo, hi, ok := levelRange(algo)
+	if !ok {
+		return level
+	}
+	switch {
+	case level < lo && (algo == AlgorithmGzip || algo == AlgorithmZlib):
+		return -1 // DefaultCompression
+	case level < lo:
+		return lo
+	case level > hi:
+		return hi
+	}
+	return level
+}
+
+// writable reports whether files can be written with algo. Bzip2 is only
+// read: the standard library has no bzip2 writer.
+func writable(algo Algorithm) bool {
+	_, _, ok := levelRange(algo)
+	return ok
+}
+
+// unsupportedWrite returns the ErrUnsupportedAlgorithm error for writing
+// with algo, explaining when the algorithm can only be read
+func unsupportedWrite(algo Algorithm) error {
+	if algo == AlgorithmBzip2 {
+		return fmt.Errorf("%w: %s is read-only, the standard library has no bzip2 writer", ErrUnsupportedAlgorithm, algo)
+	}
+	return fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algo)
+}
+
+// validateCodec checks that algo is a known algorithm and level within its range
+func validateCodec(algo Algorithm, level int) error {
+	lo, hi, ok := levelRange(algo)
+	if !ok {
+		return unsupportedWrite(algo)
+	}
+	if level < lo || level > hi {
+		return fmt.Errorf("%w: %d for %s, expected %d to %d", ErrInvalidLevel, level, algo, lo, hi)
+	}
+	return nil
+}
+
+// hasLevels reports whether the algorithm's compression level has any
+// effect. Snappy has no levels and the lz4 encoder ignores them.
+func hasLevels(algo Algorithm) bool {
+	return algo != AlgorithmSnappy && algo != AlgorithmLZ4
+}
+
+// defaultLevel returns the default compression level for a known algorithm
+func defaultLevel(algo Algorithm) (int, bool) {
+	switch algo {
+	case AlgorithmGzip:
+		return 6, true
+	case AlgorithmZstd:
+		return 3, true
+	case AlgorithmLZ4:
+		return 1, true
 	case AlgorithmBrotli:
-		return 6
+		return 6, true
 	case AlgorithmSnappy:
-		return 0 // No levels for snappy
+		return 0, true // No levels for snappy
+	case AlgorithmXz:
+		return 6, true
+	case AlgorithmZlib:
+		return 6, true
 	default:
-		return cfs.config.Level
+		return 0, false
 	}
 }
 
+// autoTuneLargeSize is the size above which auto-tuning picks its fastest
+// levels
+const autoTuneLargeSize = 10 * 1024 * 1024
+
 // autoTuneLevel adjusts compression level based on file size
-func (cfs *FS) autoTuneLevel(algo Algorithm, fileSize int64) int {
+func (cfs *FS) autoTuneLevel(algo Algorithm, level int, fileSize int64) int {
 	// If file is smaller than threshold, use configured level
 	if fileSize < cfs.config.AutoTuneSizeThreshold {
-		return cfs.config.Level
+		return level
 	}
 
 	// For larger files, use faster compression
 	switch algo {
-	case AlgorithmGzip:
+	case AlgorithmGzip, AlgorithmZlib:
 		// For large files, use level 3-4 instead of default 6
-		if fileSize > 10*1024*1024 { // > 10MB
+		if fileSize > autoTuneLargeSize {
 			return 3
 		}
 		return 4
 	case AlgorithmZstd:
 		// For large files, use level 1-2 instead of default 3
-		if fileSize > 10*1024*1024 { // > 10MB
+		if fileSize > autoTuneLargeSize {
 			return 1
 		}
 		return 2
 	case AlgorithmBrotli:
 		// For large files, use much lower level
-		if fileSize > 10*1024*1024 { // > 10MB
+		if fileSize > autoTuneLargeSize {
 			return 3
 		}
 		return 4
@@ -456,8 +1126,14 @@ func (cfs *FS) autoTuneLevel(algo Algorithm, fileSize int64) int {
 	case AlgorithmSnappy:
 		// No levels for snappy
 		return 0
+	case AlgorithmXz:
+		// Smaller presets keep the dictionary and match finder cheaper
+		if fileSize > autoTuneLargeSize {
+			return 1
+		}
+		return 3
 	default:
-		return cfs.config.Level
+		return level
 	}
 }
 
@@ -466,7 +1142,7 @@ func (cfs *FS) GetStats() *Stats {
 	cfs.mu.RLock()
 	defer cfs.mu.RUnlock()
 	// Return a copy
-	return &Stats{
+	stats := &Stats{
 		FilesCompressed:   atomic.LoadInt64(&cfs.stats.FilesCompressed),
 		FilesDecompressed: atomic.LoadInt64(&cfs.stats.FilesDecompressed),
 		FilesSkipped:      atomic.LoadInt64(&cfs.stats.FilesSkipped),
@@ -474,7 +1150,29 @@ func (cfs *FS) GetStats() *Stats {
 		BytesWritten:      atomic.LoadInt64(&cfs.stats.BytesWritten),
 		BytesCompressed:   atomic.LoadInt64(&cfs.stats.BytesCompressed),
 		BytesDecompressed: atomic.LoadInt64(&cfs.stats.BytesDecompressed),
+
+		TrySmallestBytesSaved: atomic.LoadInt64(&cfs.stats.TrySmallestBytesSaved),
+
+		EncodersCreated: atomic.LoadInt64(&cfs.stats.EncodersCreated),
+		DecodersCreated: atomic.LoadInt64(&cfs.stats.DecodersCreated),
+
+		FilesRecompressed:    atomic.LoadInt64(&cfs.stats.FilesRecompressed),
+		RecompressionsFailed: atomic.LoadInt64(&cfs.stats.RecompressionsFailed),
+
+		CompressMemoryDowngrades: atomic.LoadInt64(&cfs.stats.CompressMemoryDowngrades),
+	}
+	for _, m := range []struct{ dst, src *sync.Map }{
+		{&stats.AlgorithmCounts, &cfs.stats.AlgorithmCounts},
+		{&stats.BytesWrittenByAlgorithm, &cfs.stats.BytesWrittenByAlgorithm},
+		{&stats.BytesCompressedByAlgorithm, &cfs.stats.BytesCompressedByAlgorithm},
+		{&stats.TrySmallestWins, &cfs.stats.TrySmallestWins},
+	} {
+		m.src.Range(func(k, v any) bool {
+			m.dst.Store(k, v)
+			return true
+		})
 	}
+	return stats
 }
 
 // ResetStats resets statistics to zero
@@ -488,25 +1186,88 @@ func (cfs *FS) ResetStats() {
 	atomic.StoreInt64(&cfs.stats.BytesWritten, 0)
 	atomic.StoreInt64(&cfs.stats.BytesCompressed, 0)
 	atomic.StoreInt64(&cfs.stats.BytesDecompressed, 0)
+	atomic.StoreInt64(&cfs.stats.TrySmallestBytesSaved, 0)
+	atomic.StoreInt64(&cfs.stats.EncodersCreated, 0)
+	atomic.StoreInt64(&cfs.stats.DecodersCreated, 0)
+	atomic.StoreInt64(&cfs.stats.FilesRecompressed, 0)
+	atomic.StoreInt64(&cfs.stats.RecompressionsFailed, 0)
+	atomic.StoreInt64(&cfs.stats.CompressMemoryDowngrades, 0)
 	cfs.stats.AlgorithmCounts = sync.Map{}
+	cfs.stats.BytesWrittenByAlgorithm = sync.Map{}
+	cfs.stats.BytesCompressedByAlgorithm = sync.Map{}
+	cfs.stats.TrySmallestWins = sync.Map{}
+}
+
+// Wait blocks until every file closed under AsyncCompress has been
+// compressed and stored
+func (cfs *FS) Wait() {
+	cfs.async.Wait()
+}
+
+// EffectiveConfig returns a copy of the configuration in use, including
+// any defaults filled in by New
+func (cfs *FS) EffectiveConfig() Config {
+	cfs.mu.RLock()
+	defer cfs.mu.RUnlock()
+	return *cfs.config
 }
 
-// SetAlgorithm changes the compression algorithm
+// SetAlgorithm changes the compression algorithm. It returns
+// ErrUnsupportedAlgorithm for anything but the algorithms files can be
+// written with; AlgorithmAuto only names detection on read and is rejected
+// too. The level is kept when it lies in the new algorithm's range and
+// otherwise reset to the algorithm's default; SetCodec sets both at once.
 func (cfs *FS) SetAlgorithm(algo Algorithm) error {
+	if _, _, ok := levelRange(algo); !ok {
+		return unsupportedWrite(algo)
+	}
+
 	cfs.mu.Lock()
 	defer cfs.mu.Unlock()
 	cfs.config.Algorithm = algo
+	if validateCodec(algo, cfs.config.Level) != nil {
+		cfs.config.Level = cfs.getDefaultLevel(algo)
+	}
 	return nil
 }
 
-// SetLevel changes the compression level
+// SetLevel changes the compression level. It returns ErrInvalidLevel when
+// the level is outside the range of the configured algorithm.
 func (cfs *FS) SetLevel(level int) error {
 	cfs.mu.Lock()
 	defer cfs.mu.Unlock()
+	if err := validateCodec(cfs.config.Algorithm, level); err != nil {
+		return err
+	}
+	cfs.config.Level = level
+	return nil
+}
+
+// SetCodec changes the compression algorithm and level together, so no
+// file is opened with the new algorithm and the old level: each open reads
+// the pair once and keeps it until Close. It returns
+// ErrUnsupportedAlgorithm or ErrInvalidLevel without changing either when
+// the pair is not valid.
+func (cfs *FS) SetCodec(algo Algorithm, level int) error {
+	if err := validateCodec(algo, level); err != nil {
+		return err
+	}
+
+	cfs.mu.Lock()
+	defer cfs.mu.Unlock()
+	cfs.config.Algorithm = algo
 	cfs.config.Level = level
 	return nil
 }
 
+// Codec returns the compression algorithm and level in effect for newly
+// opened files, read together
+func (cfs *FS) Codec() (Algorithm, int) {
+	cfs.mu.RLock()
+	defer cfs.mu.RUnlock()
+	return cfs.config.Algorithm, cfs.config.Level
+}
+
 // ============================================================================
 // absfs.FileSystem interface implementation
 // ============================================================================
@@ -517,23 +1278,23 @@ func (cfs *FS) Rename(oldpath, newpath string) error {
 	config := cfs.config
 	cfs.mu.RUnlock()
 
+	if cfs.layered {
+		return cfs.base.Rename(oldpath, newpath)
+	}
+
 	// Determine actual file names considering compression extensions
 	actualOldpath := oldpath
 	actualNewpath := newpath
 
 	// For oldpath, try to find the actual file with compression extension
 	if config.StripExtension {
-		for _, algo := range []Algorithm{config.Algorithm, AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy} {
-			ext := GetExtension(algo)
-			if ext == "" {
-				continue
-			}
-			testName := oldpath + ext
+		for _, ext := range cfs.probeExtensions(config) {
+			testName := cfs.variantName(oldpath, ext)
 			if _, err := cfs.base.Stat(testName); err == nil {
 				actualOldpath = testName
 				// If we found a compressed file, the new path should also have the extension
-				if !HasCompressionExtension(newpath) {
-					actualNewpath = newpath + ext
+				if _, _, placed := cfs.stripPlacedExtension(newpath); !placed {
+					actualNewpath = cfs.variantName(newpath, ext)
 				}
 				break
 			}
@@ -557,12 +1318,8 @@ func (cfs *FS) Chmod(name string, mode os.FileMode) error {
 
 	// If StripExtension is enabled, try with compression extensions
 	if config.StripExtension {
-		for _, algo := range []Algorithm{config.Algorithm, AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy} {
-			ext := GetExtension(algo)
-			if ext == "" {
-				continue
-			}
-			testName := name + ext
+		for _, ext := range cfs.probeExtensions(config) {
+			testName := cfs.variantName(name, ext)
 			if err := cfs.base.Chmod(testName, mode); err == nil {
 				return nil
 			}
@@ -586,12 +1343,8 @@ func (cfs *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
 
 	// If StripExtension is enabled, try with compression extensions
 	if config.StripExtension {
-		for _, algo := range []Algorithm{config.Algorithm, AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy} {
-			ext := GetExtension(algo)
-			if ext == "" {
-				continue
-			}
-			testName := name + ext
+		for _, ext := range cfs.probeExtensions(config) {
+			testName := cfs.variantName(name, ext)
 			if err := cfs.base.Chtimes(testName, atime, mtime); err == nil {
 				return nil
 			}
@@ -615,12 +1368,8 @@ func (cfs *FS) Chown(name string, uid, gid int) error {
 
 	// If StripExtension is enabled, try with compression extensions
 	if config.StripExtension {
-		for _, algo := range []Algorithm{config.Algorithm, AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy} {
-			ext := GetExtension(algo)
-			if ext == "" {
-				continue
-			}
-			testName := name + ext
+		for _, ext := range cfs.probeExtensions(config) {
+			testName := cfs.variantName(name, ext)
 			if err := cfs.base.Chown(testName, uid, gid); err == nil {
 				return nil
 			}
@@ -692,12 +1441,8 @@ func (cfs *FS) RemoveAll(path string) error {
 
 	// If StripExtension is enabled, try with compression extensions
 	if config.StripExtension {
-		for _, algo := range []Algorithm{config.Algorithm, AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy} {
-			ext := GetExtension(algo)
-			if ext == "" {
-				continue
-			}
-			testName := path + ext
+		for _, ext := range cfs.probeExtensions(config) {
+			testName := cfs.variantName(path, ext)
 			if err := cfs.base.RemoveAll(testName); err == nil {
 				return nil
 			}
@@ -716,12 +1461,8 @@ func (cfs *FS) Truncate(name string, size int64) error {
 	// Determine actual filename considering compression extension
 	actualName := name
 	if config.StripExtension {
-		for _, algo := range []Algorithm{config.Algorithm, AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy} {
-			ext := GetExtension(algo)
-			if ext == "" {
-				continue
-			}
-			testName := name + ext
+		for _, ext := range cfs.probeExtensions(config) {
+			testName := cfs.variantName(name, ext)
 			if _, err := cfs.base.Stat(testName); err == nil {
 				actualName = testName
 				break
@@ -2,8 +2,15 @@ package compressfs
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/absfs/absfs"
 )
 
 func TestNewCompressFS(t *testing.T) {
@@ -208,6 +215,61 @@ func TestStats(t *testing.T) {
 	}
 }
 
+// TestStatsByAlgorithm tests per-algorithm byte totals for files routed to
+// different algorithms by rules, and that GetStats returns a copy of them
+func TestStatsByAlgorithm(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		AlgorithmRules: []AlgorithmRule{
+			{Pattern: `\.log$`, Algorithm: AlgorithmLZ4, Level: -1},
+			{Pattern: `\.json$`, Algorithm: AlgorithmZstd, Level: -1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	writeTestFile(t, cfs, "app.log", bytes.Repeat([]byte("log line\n"), 1000))
+	writeTestFile(t, cfs, "old.log", bytes.Repeat([]byte("log line\n"), 500))
+	writeTestFile(t, cfs, "data.json", bytes.Repeat([]byte(`{"k":1}`), 300))
+	writeTestFile(t, cfs, "notes.txt", []byte("plain notes"))
+
+	stats := cfs.GetStats()
+	want := map[Algorithm]int64{AlgorithmLZ4: 13500, AlgorithmZstd: 2100, AlgorithmGzip: 11}
+	stored := map[Algorithm]int64{}
+	err = cfs.WalkStats(".", func(path string, info CompressedFileInfo) error {
+		stored[info.Algorithm] += info.CompressedSize
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkStats failed: %v", err)
+	}
+	for algo, n := range want {
+		if got := stats.GetBytesWrittenByAlgorithm(algo); got != n {
+			t.Errorf("BytesWrittenByAlgorithm[%s] = %d, expected %d", algo, got, n)
+		}
+		if got := stats.GetBytesCompressedByAlgorithm(algo); got != stored[algo] {
+			t.Errorf("BytesCompressedByAlgorithm[%s] = %d, expected the %d bytes stored", algo, got, stored[algo])
+		}
+	}
+	if n := stats.GetAlgorithmCount(AlgorithmLZ4); n != 2 {
+		t.Errorf("AlgorithmCounts[lz4] = %d, expected 2", n)
+	}
+
+	// The copy is not affected by later writes or a reset
+	writeTestFile(t, cfs, "more.log", []byte("another line"))
+	cfs.ResetStats()
+	if got := stats.GetBytesWrittenByAlgorithm(AlgorithmLZ4); got != 13500 {
+		t.Errorf("Copied BytesWrittenByAlgorithm[lz4] changed to %d", got)
+	}
+	if got := cfs.GetStats().GetBytesWrittenByAlgorithm(AlgorithmLZ4); got != 0 {
+		t.Errorf("BytesWrittenByAlgorithm[lz4] = %d after reset, expected 0", got)
+	}
+}
+
 func TestMinSize(t *testing.T) {
 	base := NewMemFS()
 	cfs, err := New(base, &Config{
@@ -271,3 +333,367 @@ func TestReadDir(t *testing.T) {
 		t.Errorf("Expected file1.txt and file2.txt, got: %v", names)
 	}
 }
+
+func TestPartialConfigDefaults(t *testing.T) {
+	base := NewMemFS()
+	config := &Config{Algorithm: AlgorithmGzip}
+	cfs, err := New(base, config)
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	effective := cfs.EffectiveConfig()
+	if effective.BufferSize != 64*1024 {
+		t.Errorf("Expected BufferSize 64KB, got %d", effective.BufferSize)
+	}
+	if effective.AutoTuneSizeThreshold != 1024*1024 {
+		t.Errorf("Expected AutoTuneSizeThreshold 1MB, got %d", effective.AutoTuneSizeThreshold)
+	}
+	if effective.ParallelThreshold != 10*1024*1024 {
+		t.Errorf("Expected ParallelThreshold 10MB, got %d", effective.ParallelThreshold)
+	}
+	if effective.ParallelChunkSize != 1024*1024 {
+		t.Errorf("Expected ParallelChunkSize 1MB, got %d", effective.ParallelChunkSize)
+	}
+
+	// The caller's config is left untouched
+	if config.BufferSize != 0 {
+		t.Errorf("Caller config should not be modified, got BufferSize %d", config.BufferSize)
+	}
+}
+
+func TestUnsetLevelDefault(t *testing.T) {
+	data := bytes.Repeat([]byte("default level payload with some variety 0123456789\n"), 2000)
+
+	tests := []struct {
+		name   string
+		config *Config
+		level  int
+	}{
+		{"unset", &Config{Algorithm: AlgorithmZstd, PreserveExtension: true}, 3},
+		{"explicit zero", &Config{Algorithm: AlgorithmZstd, PreserveExtension: true, LevelSet: true}, 0},
+	}
+
+	for _, tt := range tests {
+		base := NewMemFS()
+		cfs, err := New(base, tt.config)
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+		if got := cfs.EffectiveConfig().Level; got != tt.level {
+			t.Errorf("%s: expected effective Level %d, got %d", tt.name, tt.level, got)
+		}
+
+		writeTestFile(t, cfs, "level.txt", data)
+		want, err := CompressBytes(data, AlgorithmZstd, tt.level)
+		if err != nil {
+			t.Fatalf("CompressBytes failed: %v", err)
+		}
+		if got := readBaseFile(t, base, "level.txt.zst"); !bytes.Equal(got, want) {
+			t.Errorf("%s: stored %d bytes, want zstd level %d output of %d bytes", tt.name, len(got), tt.level, len(want))
+		}
+	}
+}
+
+func TestMisnamedGzipPlaintext(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		AutoDetect:        true,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	plain := []byte("just some notes, not gzip at all\n")
+	f, err := base.OpenFile("notes.gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("Create base file failed: %v", err)
+	}
+	f.Write(plain)
+	f.Close()
+
+	// Both by stored name and by the logical name the extension implies
+	for _, name := range []string{"notes.gz", "notes"} {
+		rf, err := cfs.Open(name)
+		if err != nil {
+			t.Fatalf("Open %s failed: %v", name, err)
+		}
+		if algo := rf.(*compressedFile).Algorithm(); algo != "" {
+			t.Errorf("%s: expected no read algorithm, got %q", name, algo)
+		}
+		got, err := io.ReadAll(rf)
+		rf.Close()
+		if err != nil {
+			t.Fatalf("Read %s failed: %v", name, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%s: expected raw content %q, got %q", name, plain, got)
+		}
+	}
+
+	stats := cfs.GetStats()
+	if stats.FilesDecompressed != 0 || stats.BytesDecompressed != 0 {
+		t.Errorf("Raw reads recorded %d decompressed files and %d bytes", stats.FilesDecompressed, stats.BytesDecompressed)
+	}
+	if n := cfs.stats.GetAlgorithmCount(AlgorithmGzip); n != 0 {
+		t.Errorf("Raw reads recorded %d gzip uses", n)
+	}
+}
+
+func TestReadDirLogicalInfo(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	// gzip records the original size; lz4 has to be decompressed to find it
+	sizes := map[string]int{"sized.txt": 5000, "unsized.txt": 7000}
+	writeTestFile(t, cfs, "sized.txt", bytes.Repeat([]byte("s"), sizes["sized.txt"]))
+	cfs.SetAlgorithm(AlgorithmLZ4)
+	writeTestFile(t, cfs, "unsized.txt", bytes.Repeat([]byte("u"), sizes["unsized.txt"]))
+
+	entries, err := cfs.ReadDir(".")
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(entries) != len(sizes) {
+		t.Fatalf("Expected %d entries, got %d", len(sizes), len(entries))
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatalf("Info %s failed: %v", entry.Name(), err)
+		}
+		want, ok := sizes[info.Name()]
+		if !ok {
+			t.Errorf("Info().Name() %q should be the logical name", info.Name())
+			continue
+		}
+		if info.Size() != int64(want) {
+			t.Errorf("%s: expected logical size %d, got %d", info.Name(), want, info.Size())
+		}
+	}
+}
+
+// TestSetCodec tests that concurrent codec switches never leave a file with
+// one codec's algorithm and the other's level, and race with neither writes
+// nor reads, as under go test -race
+func TestSetCodec(t *testing.T) {
+	var log []Decision
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             1,
+		PreserveExtension: true,
+		StripExtension:    true,
+		DecisionLog:       &log,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	if err := cfs.SetCodec("gzpi", 1); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("SetCodec(gzpi) = %v, expected ErrUnsupportedAlgorithm", err)
+	}
+	if err := cfs.SetCodec(AlgorithmGzip, 12); !errors.Is(err, ErrInvalidLevel) {
+		t.Errorf("SetCodec(gzip, 12) = %v, expected ErrInvalidLevel", err)
+	}
+	if algo, level := cfs.Codec(); algo != AlgorithmGzip || level != 1 {
+		t.Errorf("Rejected SetCodec changed the codec to %s/%d", algo, level)
+	}
+
+	valid := map[Algorithm]int{AlgorithmGzip: 1, AlgorithmZstd: 19}
+	done := make(chan struct{})
+	var switcher sync.WaitGroup
+	switcher.Add(1)
+	go func() {
+		defer switcher.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				cfs.SetCodec(AlgorithmZstd, 19)
+			} else {
+				cfs.SetCodec(AlgorithmGzip, 1)
+			}
+			if algo, level := cfs.Codec(); valid[algo] != level {
+				t.Errorf("Codec() = %s/%d, a mix of two codecs", algo, level)
+			}
+		}
+	}()
+
+	data := bytes.Repeat([]byte("codec switch "), 100)
+	var writers sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := 0; i < 50; i++ {
+				f, err := cfs.Create(fmt.Sprintf("w%d-%d.txt", w, i))
+				if err != nil {
+					t.Errorf("Create failed: %v", err)
+					return
+				}
+				f.Write(data)
+				f.Close()
+				if got, err := cfs.ReadFile(fmt.Sprintf("w%d-%d.txt", w, i)); err != nil || !bytes.Equal(got, data) {
+					t.Errorf("ReadFile = %d bytes, %v", len(got), err)
+				}
+			}
+		}(w)
+	}
+	writers.Wait()
+	close(done)
+	switcher.Wait()
+
+	decisions := cfs.Decisions()
+	if len(decisions) != 200 {
+		t.Fatalf("Recorded %d decisions, expected 200", len(decisions))
+	}
+	for _, d := range decisions {
+		if level, ok := valid[d.Algorithm]; !ok || d.Level != level {
+			t.Errorf("%s written with %s/%d, a mix of two codecs", d.Name, d.Algorithm, d.Level)
+		}
+	}
+}
+
+// TestSetAlgorithmLevelValidation tests that SetAlgorithm and SetLevel
+// reject unknown algorithms and out-of-range levels at the call
+func TestSetAlgorithmLevelValidation(t *testing.T) {
+	tests := []struct {
+		algo   Algorithm
+		lo, hi int
+	}{
+		{AlgorithmGzip, -2, 9},
+		{AlgorithmZstd, 0, 22},
+		{AlgorithmLZ4, 0, 16},
+		{AlgorithmBrotli, 0, 11},
+		{AlgorithmSnappy, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.algo), func(t *testing.T) {
+			cfs, err := New(NewMemFS(), nil)
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+			if err := cfs.SetAlgorithm(tt.algo); err != nil {
+				t.Fatalf("SetAlgorithm(%s) failed: %v", tt.algo, err)
+			}
+			for _, level := range []int{tt.lo, tt.hi} {
+				if err := cfs.SetLevel(level); err != nil {
+					t.Errorf("SetLevel(%d) = %v, expected it to be valid", level, err)
+				}
+			}
+			for _, level := range []int{tt.lo - 1, tt.hi + 1} {
+				if err := cfs.SetLevel(level); !errors.Is(err, ErrInvalidLevel) {
+					t.Errorf("SetLevel(%d) = %v, expected ErrInvalidLevel", level, err)
+				}
+			}
+			if _, level := cfs.Codec(); level != tt.hi {
+				t.Errorf("Rejected SetLevel changed the level to %d", level)
+			}
+		})
+	}
+
+	cfs, err := New(NewMemFS(), &Config{Algorithm: AlgorithmGzip})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	for _, algo := range []Algorithm{"gzpi", "", AlgorithmAuto} {
+		if err := cfs.SetAlgorithm(algo); !errors.Is(err, ErrUnsupportedAlgorithm) {
+			t.Errorf("SetAlgorithm(%q) = %v, expected ErrUnsupportedAlgorithm", algo, err)
+		}
+	}
+	if algo, _ := cfs.Codec(); algo != AlgorithmGzip {
+		t.Errorf("Rejected SetAlgorithm changed the algorithm to %s", algo)
+	}
+
+	// A level outside the new algorithm's range falls back to its default
+	if err := cfs.SetCodec(AlgorithmZstd, 19); err != nil {
+		t.Fatalf("SetCodec(zstd, 19) failed: %v", err)
+	}
+	if err := cfs.SetAlgorithm(AlgorithmGzip); err != nil {
+		t.Fatalf("SetAlgorithm(gzip) failed: %v", err)
+	}
+	if algo, level := cfs.Codec(); algo != AlgorithmGzip || level != cfs.getDefaultLevel(AlgorithmGzip) {
+		t.Errorf("Codec after SetAlgorithm(gzip) = %s/%d, expected gzip at its default", algo, level)
+	}
+	if err := cfs.SetLevel(2); err != nil {
+		t.Fatalf("SetLevel(2) failed: %v", err)
+	}
+	if err := cfs.SetAlgorithm(AlgorithmBrotli); err != nil {
+		t.Fatalf("SetAlgorithm(brotli) failed: %v", err)
+	}
+	if _, level := cfs.Codec(); level != 2 {
+		t.Errorf("SetAlgorithm(brotli) changed the in-range level 2 to %d", level)
+	}
+}
+
+// TestValidateConfig tests that New rejects invalid codecs, sizes and rules
+// with the matching sentinel error
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		want   error
+	}{
+		{"nil config", nil, nil},
+		{"no algorithm", &Config{}, nil},
+		{"unset level", &Config{Algorithm: AlgorithmBrotli}, nil},
+		{"explicit zero level", &Config{Algorithm: AlgorithmGzip, LevelSet: true}, nil},
+		{"unknown algorithm", &Config{Algorithm: "gzpi"}, ErrUnsupportedAlgorithm},
+		{"level out of range", &Config{Algorithm: AlgorithmZstd, Level: 23}, ErrInvalidLevel},
+		{"negative level", &Config{Algorithm: AlgorithmBrotli, Level: -1}, ErrInvalidLevel},
+		{"negative buffer size", &Config{Algorithm: AlgorithmGzip, BufferSize: -1}, ErrInvalidConfig},
+		{"negative min size", &Config{Algorithm: AlgorithmGzip, MinSize: -1}, ErrInvalidConfig},
+		{"default rule level", &Config{Algorithm: AlgorithmGzip, AlgorithmRules: []AlgorithmRule{
+			{Pattern: `\.log$`, Algorithm: AlgorithmZstd, Level: -1},
+		}}, nil},
+		{"unknown rule algorithm", &Config{Algorithm: AlgorithmGzip, AlgorithmRules: []AlgorithmRule{
+			{Pattern: `\.log$`, Algorithm: "lzma", Level: -1},
+		}}, ErrUnsupportedAlgorithm},
+		{"rule level out of range", &Config{Algorithm: AlgorithmGzip, AlgorithmRules: []AlgorithmRule{
+			{Pattern: `\.log$`, Algorithm: AlgorithmBrotli, Level: 12},
+		}}, ErrInvalidLevel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(NewMemFS(), tt.config)
+			if tt.want == nil && err != nil {
+				t.Errorf("New failed: %v", err)
+			} else if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("New = %v, expected %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestNewNilBase tests that nil bases, including typed nils, are rejected
+// up front rather than panicking on first use
+func TestNewNilBase(t *testing.T) {
+	var nilFS absfs.FileSystem
+	var nilMem *memFS
+
+	for name, base := range map[string]interface{}{
+		"nil interface": nilFS,
+		"typed nil":     nilMem,
+	} {
+		cfs, err := New(base, nil)
+		if err == nil || !strings.Contains(err.Error(), "nil") {
+			t.Errorf("%s: New = %v, %v, expected a nil base error", name, cfs, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package compressfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+)
+
+// compareChunkSize is the chunk size used when comparing file contents
+const compareChunkSize = 32 * 1024
+
+// SameContent reports whether the files a and b have identical decompressed
+// content. Both streams are compared chunk by chunk and the comparison stops
+// at the first difference, so neither file is fully loaded into memory.
+//
+// When both files are stored with the same algorithm and their compressed
+// bytes are identical, the decompression step is skipped entirely.
+func (cfs *FS) SameContent(a, b string) (bool, error) {
+	cfs.mu.RLock()
+	config := cfs.config
+	cfs.mu.RUnlock()
+
+	storedA, algoA, okA := cfs.resolveStored(a, config)
+	storedB, algoB, okB := cfs.resolveStored(b, config)
+
+	// Fast path: same algorithm and byte-identical stored files
+	if okA && okB && algoA == algoB {
+		same, err := cfs.sameStoredBytes(storedA, storedB)
+		if err != nil {
+			return false, err
+		}
+		if same {
+			return true, nil
+		}
+	}
+
+	fa, err := cfs.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+
+	fb, err := cfs.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	return sameReaders(fa, fb)
+}
+
+// sameStoredBytes compares two base files byte for byte without decompression
+func (cfs *FS) sameStoredBytes(a, b string) (bool, error) {
+	infoA, err := cfs.base.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := cfs.base.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	fa, err := cfs.base.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+
+	fb, err := cfs.base.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	return sameReaders(fa, fb)
+}
+
+// sameReaders compares two streams chunk by chunk, stopping at the first difference
+func sameReaders(a, b io.Reader) (bool, error) {
+	bufA := make([]byte, compareChunkSize)
+	bufB := make([]byte, compareChunkSize)
+
+	for {
+		nA, errA := io.ReadFull(a, bufA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return false, errA
+		}
+		nB, errB := io.ReadFull(b, bufB)
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+
+		if nA != nB || !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+
+		// A short read means both streams ended at the same point
+		if errA != nil || errB != nil {
+			return errA != nil && errB != nil, nil
+		}
+	}
+}
+
+// ConcatTo decompresses each named file in order and writes the plaintext to
+// w, streaming one file at a time. Inputs may use different algorithms. It
+// returns the total number of bytes written.
+func (cfs *FS) ConcatTo(w io.Writer, names ...string) (int64, error) {
+	var total int64
+	buf := make([]byte, copyBufferSize)
+
+	for _, name := range names {
+		f, err := cfs.Open(name)
+		if err != nil {
+			return total, err
+		}
+		n, err := io.CopyBuffer(w, onlyReader{f}, buf)
+		total += n
+		f.Close()
+		if err != nil {
+			return total, fmt.Errorf("compressfs: concat %s: %w", name, err)
+		}
+	}
+	return total, nil
+}
+
+// DecompressTo decompresses the named file to w, feeding the same plaintext
+// to h in the same pass so callers can verify a content hash while
+// extracting. h may be nil. It returns the number of bytes written to w.
+func (cfs *FS) DecompressTo(name string, w io.Writer, h hash.Hash) (int64, error) {
+	f, err := cfs.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if h != nil {
+		w = io.MultiWriter(w, h)
+	}
+	n, err := io.CopyBuffer(w, onlyReader{f}, make([]byte, copyBufferSize))
+	if err != nil {
+		return n, fmt.Errorf("compressfs: decompress %s: %w", name, err)
+	}
+	return n, nil
+}
+
+// OpenBuffered opens the named file and returns its decompressed content as
+// a *bufio.Reader, so callers can Peek at a header or Discard bytes before
+// reading. The returned func closes the underlying file.
+func (cfs *FS) OpenBuffered(name string) (*bufio.Reader, func() error, error) {
+	cfs.mu.RLock()
+	size := cfs.config.BufferSize
+	cfs.mu.RUnlock()
+
+	f, err := cfs.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bufio.NewReaderSize(f, size), f.Close, nil
+}
+
+// Head returns the first n plaintext bytes of the named file, or the whole
+// file if it is shorter. Only as much of the stream as is needed to produce
+// n bytes is decompressed before the file is closed, and the result grows
+// with what is read, so a large n costs no more than the file. A negative
+// n is rejected with fs.ErrInvalid.
+func (cfs *FS) Head(name string, n int) ([]byte, error) {
+	if n < 0 {
+		return nil, &fs.PathError{Op: "head", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := cfs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(f, int64(n)))
+	if err != nil {
+		return nil, fmt.Errorf("compressfs: head %s: %w", name, err)
+	}
+	return buf, nil
+}
@@ -0,0 +1,305 @@
+package compressfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/absfs/absfs"
+)
+
+// writeTestFile writes data through the compressed filesystem
+func writeTestFile(t *testing.T, cfs *FS, name string, data []byte) {
+	t.Helper()
+	f, err := cfs.Create(name)
+	if err != nil {
+		t.Fatalf("Create %s failed: %v", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write %s failed: %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close %s failed: %v", name, err)
+	}
+}
+
+// writeBaseFile writes raw data directly to the base filesystem
+func writeBaseFile(t *testing.T, base absfs.Filer, name string, data []byte) {
+	t.Helper()
+	f, err := base.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("Create base %s failed: %v", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write base %s failed: %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close base %s failed: %v", name, err)
+	}
+}
+
+// readBaseFile reads raw stored bytes directly from the base filesystem
+func readBaseFile(t *testing.T, base absfs.Filer, name string) []byte {
+	t.Helper()
+	f, err := base.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("Open base %s failed: %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("Read base %s failed: %v", name, err)
+	}
+	return data
+}
+
+// TestSameContent tests content comparison across algorithms
+func TestSameContent(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("identical plaintext content "), 5000)
+	writeTestFile(t, cfs, "a.txt", data)
+
+	cfs.SetAlgorithm(AlgorithmZstd)
+	writeTestFile(t, cfs, "b.txt", data)
+
+	different := append([]byte{}, data...)
+	different[len(different)-1] = '!'
+	writeTestFile(t, cfs, "c.txt", different)
+
+	if _, err := base.Stat("a.txt.gz"); err != nil {
+		t.Fatalf("Expected a.txt.gz: %v", err)
+	}
+	if _, err := base.Stat("b.txt.zst"); err != nil {
+		t.Fatalf("Expected b.txt.zst: %v", err)
+	}
+
+	same, err := cfs.SameContent("a.txt", "b.txt")
+	if err != nil {
+		t.Fatalf("SameContent failed: %v", err)
+	}
+	if !same {
+		t.Error("Expected identical plaintext to compare equal")
+	}
+
+	same, err = cfs.SameContent("a.txt", "c.txt")
+	if err != nil {
+		t.Fatalf("SameContent failed: %v", err)
+	}
+	if same {
+		t.Error("Expected differing plaintext to compare unequal")
+	}
+
+	// Same stored bytes take the fast path
+	same, err = cfs.SameContent("b.txt", "b.txt")
+	if err != nil {
+		t.Fatalf("SameContent failed: %v", err)
+	}
+	if !same {
+		t.Error("Expected a file to compare equal to itself")
+	}
+}
+
+// TestConcatTo tests concatenating files with mixed algorithms
+func TestConcatTo(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	first := bytes.Repeat([]byte("first log line\n"), 300)
+	second := bytes.Repeat([]byte("second log line\n"), 300)
+	writeTestFile(t, cfs, "a.log", first)
+	cfs.SetAlgorithm(AlgorithmZstd)
+	writeTestFile(t, cfs, "b.log", second)
+
+	if _, err := base.Stat("a.log.gz"); err != nil {
+		t.Fatalf("Expected a.log.gz: %v", err)
+	}
+	if _, err := base.Stat("b.log.zst"); err != nil {
+		t.Fatalf("Expected b.log.zst: %v", err)
+	}
+
+	var out bytes.Buffer
+	n, err := cfs.ConcatTo(&out, "a.log", "b.log")
+	if err != nil {
+		t.Fatalf("ConcatTo failed: %v", err)
+	}
+
+	want := append(append([]byte{}, first...), second...)
+	if n != int64(len(want)) {
+		t.Errorf("Expected %d bytes, got %d", len(want), n)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Error("Concatenated output is wrong or out of order")
+	}
+
+	if _, err := cfs.ConcatTo(&out, "missing.log"); err == nil {
+		t.Error("Expected error for missing input")
+	}
+}
+
+// TestOpenBuffered tests peeking at decompressed content without consuming it
+func TestOpenBuffered(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmZstd,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := append([]byte("MAGIC-HEADER\n"), bytes.Repeat([]byte("body "), 500)...)
+	writeTestFile(t, cfs, "framed.dat", data)
+
+	r, closeFn, err := cfs.OpenBuffered("framed.dat")
+	if err != nil {
+		t.Fatalf("OpenBuffered failed: %v", err)
+	}
+	defer closeFn()
+
+	head, err := r.Peek(5)
+	if err != nil {
+		t.Fatalf("Peek failed: %v", err)
+	}
+	if string(head) != "MAGIC" {
+		t.Errorf("Expected to peek MAGIC, got %q", head)
+	}
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("Peeked bytes were consumed or content is wrong")
+	}
+}
+
+// TestDecompressTo tests extracting a file while hashing its plaintext
+func TestDecompressTo(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmZstd,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("hashed while extracting\n"), 4000)
+	writeTestFile(t, cfs, "extract.txt", data)
+
+	var out bytes.Buffer
+	h := sha256.New()
+	n, err := cfs.DecompressTo("extract.txt", &out, h)
+	if err != nil {
+		t.Fatalf("DecompressTo failed: %v", err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("Expected %d plaintext bytes, got %d", len(data), n)
+	}
+
+	want := sha256.Sum256(data)
+	if got := h.Sum(nil); !bytes.Equal(got, want[:]) {
+		t.Errorf("Expected SHA-256 %x, got %x", want, got)
+	}
+}
+
+// readCountingFiler counts bytes read from files opened on the base
+type readCountingFiler struct {
+	absfs.Filer
+	read int64
+}
+
+type readCountingFile struct {
+	absfs.File
+	filer *readCountingFiler
+}
+
+func (c *readCountingFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	f, err := c.Filer.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &readCountingFile{File: f, filer: c}, nil
+}
+
+func (f *readCountingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.filer.read += int64(n)
+	return n, err
+}
+
+// TestHead tests that Head decompresses only the start of a large file
+func TestHead(t *testing.T) {
+	base := &readCountingFiler{Filer: NewMemFS()}
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	// Pseudo-random text compresses poorly, so the stored file stays large
+	rng := rand.New(rand.NewSource(7))
+	data := make([]byte, 4<<20)
+	for i := range data {
+		data[i] = 'a' + byte(rng.Intn(26))
+	}
+	writeTestFile(t, cfs, "large.txt", data)
+	stored, err := base.Stat("large.txt.gz")
+	if err != nil {
+		t.Fatalf("Expected large.txt.gz: %v", err)
+	}
+
+	base.read = 0
+	head, err := cfs.Head("large.txt", 16)
+	if err != nil {
+		t.Fatalf("Head failed: %v", err)
+	}
+	if !bytes.Equal(head, data[:16]) {
+		t.Errorf("Expected %q, got %q", data[:16], head)
+	}
+	if base.read*20 > stored.Size() {
+		t.Errorf("Head read %d of %d stored bytes, expected under 5%%", base.read, stored.Size())
+	}
+
+	// A file shorter than n is returned whole
+	writeTestFile(t, cfs, "short.txt", []byte("tiny"))
+	if head, err := cfs.Head("short.txt", 16); err != nil || string(head) != "tiny" {
+		t.Errorf("Expected whole short file, got %q, %v", head, err)
+	}
+
+	// A huge n is bounded by the file rather than allocated up front
+	if head, err := cfs.Head("short.txt", math.MaxInt); err != nil || string(head) != "tiny" {
+		t.Errorf("Expected whole short file for a huge n, got %q, %v", head, err)
+	}
+	if _, err := cfs.Head("short.txt", -1); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected fs.ErrInvalid for a negative n, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package compressfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+// countdownContext reports cancellation once Err has been called n times,
+// to cancel at a chosen point inside a flush
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+// assertNoPartialFile fails the test if the logical name or its stored
+// variant exists after a cancelled write
+func assertNoPartialFile(t *testing.T, cfs *FS, base *memFS, name, stored string) {
+	t.Helper()
+	if _, err := base.Stat(stored); err == nil {
+		t.Errorf("Partial file %s left behind", stored)
+	}
+	if _, err := cfs.Stat(name); err == nil {
+		t.Errorf("Cancelled write of %s is visible", name)
+	}
+}
+
+// TestOpenFileContextCancelledWrite tests that cancelling a streaming
+// write, sequential or parallel, fails it and leaves no file behind
+func TestOpenFileContextCancelledWrite(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		base := NewMemFS().(*memFS)
+		cfs, err := New(base, &Config{
+			Algorithm:                 AlgorithmGzip,
+			Level:                     1,
+			PreserveExtension:         true,
+			StripExtension:            true,
+			EnableParallelCompression: parallel,
+			ParallelThreshold:         1 << 20,
+			ParallelChunkSize:         256 << 10,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		f, err := cfs.OpenFileContext(ctx, "big.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			t.Fatalf("OpenFileContext failed: %v", err)
+		}
+		chunk := bytes.Repeat([]byte("highly compressible "), 1<<16)
+		for i := 0; i < 4; i++ {
+			if _, err := f.Write(chunk); err != nil {
+				t.Fatalf("Write before cancel failed: %v", err)
+			}
+		}
+		cancel()
+		if _, err := f.Write(chunk); !errors.Is(err, context.Canceled) {
+			t.Errorf("parallel=%v: Write after cancel = %v, expected context.Canceled", parallel, err)
+		}
+		if err := f.Close(); !errors.Is(err, context.Canceled) {
+			t.Errorf("parallel=%v: Close = %v, expected context.Canceled", parallel, err)
+		}
+		assertNoPartialFile(t, cfs, base, "big.txt", "big.txt.gz")
+	}
+}
+
+// TestOpenFileContextCancelledFlush tests that a cancellation during the
+// Close-time compression of a buffered write stops it promptly
+func TestOpenFileContextCancelledFlush(t *testing.T) {
+	base := NewMemFS().(*memFS)
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmZstd,
+		PreserveExtension: true,
+		StripExtension:    true,
+		ZstdContentSize:   true, // Buffers the whole file until Close
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	// One check per write and at the start of Close, then a few pieces
+	parent, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx := &countdownContext{Context: parent, n: 4 + 1 + 3}
+	f, err := cfs.OpenFileContext(ctx, "big.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("OpenFileContext failed: %v", err)
+	}
+	chunk := bytes.Repeat([]byte("highly compressible "), 1<<16)
+	for i := 0; i < 4; i++ {
+		if _, err := f.Write(chunk); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := f.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close = %v, expected context.Canceled", err)
+	}
+	if ctx.n > 0 {
+		t.Errorf("Flush finished with %d context checks to spare", ctx.n)
+	}
+	assertNoPartialFile(t, cfs, base, "big.txt", "big.txt.zst")
+}
+
+// TestOpenFileContextRead tests that a streaming read stops once its
+// context is cancelled
+func TestOpenFileContextRead(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, cfs, "data.txt", bytes.Repeat([]byte("streamed read "), 10000))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := cfs.OpenFileContext(ctx, "data.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFileContext failed: %v", err)
+	}
+	defer f.Close()
+	if _, err := io.ReadFull(f, make([]byte, 1024)); err != nil {
+		t.Fatalf("Read before cancel failed: %v", err)
+	}
+	cancel()
+	if _, err := f.Read(make([]byte, 1024)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Read after cancel = %v, expected context.Canceled", err)
+	}
+
+	if _, err := cfs.OpenFileContext(ctx, "data.txt", os.O_RDONLY, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("OpenFileContext with a done context = %v, expected context.Canceled", err)
+	}
+}
@@ -2,9 +2,16 @@ package compressfs
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/absfs/absfs"
 )
 
 // TestFSOperations tests filesystem operations like Mkdir, Remove, Rename, etc.
@@ -586,6 +593,46 @@ func TestWriteStringAndTruncate(t *testing.T) {
 	f.Close()
 }
 
+// TestTruncateEmptyWrite tests no-op truncation of pending compressed writes
+func TestTruncateEmptyWrite(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	f, err := cfs.Create("fresh.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := f.Truncate(0); err != nil {
+		t.Errorf("Truncate(0) after Create failed: %v", err)
+	}
+
+	data := []byte("written after truncate")
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := f.Truncate(int64(len(data))); err != nil {
+		t.Errorf("Truncate to the buffered length failed: %v", err)
+	}
+	if err := f.Truncate(5); err != ErrSeekNotSupported {
+		t.Errorf("Expected ErrSeekNotSupported for arbitrary truncation, got %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if got := readTestFile(t, cfs, "fresh.txt"); !bytes.Equal(got, data) {
+		t.Errorf("Expected %q, got %q", data, got)
+	}
+}
+
 // TestFileNameMethod tests the Name method on files
 func TestFileNameMethod(t *testing.T) {
 	base := NewMemFS()
@@ -632,6 +679,68 @@ func TestReadAtWriteAt(t *testing.T) {
 	f.Close()
 }
 
+// TestReadAtSkippedFile tests that ReadAt on uncompressed files matches os.File
+func TestReadAtSkippedFile(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		SkipPatterns:      []string{`\.bin$`},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	writeTestFile(t, cfs, "skipped.bin", data)
+
+	osPath := filepath.Join(t.TempDir(), "skipped.bin")
+	if err := os.WriteFile(osPath, data, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	osFile, err := os.Open(osPath)
+	if err != nil {
+		t.Fatalf("os.Open failed: %v", err)
+	}
+	defer osFile.Close()
+
+	f, err := cfs.Open("skipped.bin")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	reads := []struct {
+		off  int64
+		size int
+	}{
+		{0, 4}, {10, 8}, {30, 6}, {32, 10}, {36, 1},
+	}
+	for _, r := range reads {
+		want := make([]byte, r.size)
+		wantN, wantErr := osFile.ReadAt(want, r.off)
+		got := make([]byte, r.size)
+		gotN, gotErr := f.ReadAt(got, r.off)
+
+		if gotN != wantN || !bytes.Equal(got[:gotN], want[:wantN]) || (gotErr == nil) != (wantErr == nil) {
+			t.Errorf("ReadAt(%d, %d) = %d %q %v, os.File gave %d %q %v",
+				r.off, r.size, gotN, got[:gotN], gotErr, wantN, want[:wantN], wantErr)
+		}
+	}
+
+	// Compressed streams have no random access
+	writeTestFile(t, cfs, "packed.txt", data)
+	packed, err := cfs.Open("packed.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer packed.Close()
+	if _, err := packed.ReadAt(make([]byte, 4), 0); err != ErrSeekNotSupported {
+		t.Errorf("Expected ErrSeekNotSupported for compressed ReadAt, got %v", err)
+	}
+}
+
 // TestReaddirAndReaddirnames tests directory listing
 func TestReaddirAndReaddirnames(t *testing.T) {
 	base := NewMemFS()
@@ -717,3 +826,86 @@ func TestDoubleClose(t *testing.T) {
 		t.Errorf("Second close failed: %v", err)
 	}
 }
+
+// dirFiler returns directory handles that report a nonzero size, like
+// directories on a real filesystem, and counts reads against them
+type dirFiler struct {
+	absfs.Filer
+	reads int64
+}
+
+type sizedDir struct {
+	absfs.File
+	filer *dirFiler
+}
+
+func (d *dirFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	f, err := d.Filer.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return &sizedDir{File: f, filer: d}, nil
+	}
+	return f, nil
+}
+
+func (d *sizedDir) Stat() (fs.FileInfo, error) {
+	info, err := d.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return sizedDirInfo{info}, nil
+}
+
+func (d *sizedDir) Read(p []byte) (int, error) {
+	atomic.AddInt64(&d.filer.reads, 1)
+	return 0, errors.New("read on directory")
+}
+
+type sizedDirInfo struct{ fs.FileInfo }
+
+func (sizedDirInfo) Size() int64 { return 4096 }
+
+// TestOpenDirectory tests that directories open as pass-through handles
+func TestOpenDirectory(t *testing.T) {
+	base := &dirFiler{Filer: NewMemFS()}
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		AutoDetect:        true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	writeTestFile(t, cfs, "a.txt", []byte("first file"))
+	writeTestFile(t, cfs, "b.txt", []byte("second file"))
+
+	dir, err := cfs.Open(".")
+	if err != nil {
+		t.Fatalf("Open(\".\") failed: %v", err)
+	}
+	defer dir.Close()
+
+	if got := atomic.LoadInt64(&base.reads); got != 0 {
+		t.Errorf("Expected no reads from the directory handle, got %d", got)
+	}
+
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %d", len(entries))
+	}
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Readdirnames failed: %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("Expected 2 names, got %v", names)
+	}
+}
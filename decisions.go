@@ -0,0 +1,130 @@
+package compressfs
+
+// DecisionReason explains why a file was or was not compressed
+type DecisionReason string
+
+const (
+	// ReasonCompressed means the file was written or read through a codec
+	ReasonCompressed DecisionReason = "compressed"
+
+	// ReasonSkipPattern means the name matched a SkipPatterns entry
+	ReasonSkipPattern DecisionReason = "skip-pattern"
+
+	// ReasonMinSize means the file was smaller than MinSize
+	ReasonMinSize DecisionReason = "min-size"
+
+	// ReasonAlreadyCompressed means the name already carries a compression
+	// extension, so its content is stored as-is
+	ReasonAlreadyCompressed DecisionReason = "already-compressed"
+
+	// ReasonIncompressible means the StreamingSample head did not compress well
+	ReasonIncompressible DecisionReason = "incompressible"
+
+	// ReasonContentType means SkipByContentType found an already-compressed
+	// media type in the head of the file
+	ReasonContentType DecisionReason = "content-type"
+
+	// ReasonEmpty means nothing was written
+	ReasonEmpty DecisionReason = "empty"
+
+	// ReasonNotCompressed means a read found no compressed content
+	ReasonNotCompressed DecisionReason = "not-compressed"
+)
+
+// DecisionEvent records the compression decision made for one open file.
+// Writes report their decision on Close, once the final size is known;
+// reads report it as soon as detection completes.
+type DecisionEvent struct {
+	// Name is the logical file name
+	Name string
+
+	// StoredName is the name resolved on the base filesystem
+	StoredName string
+
+	// Write is true for files opened for writing
+	Write bool
+
+	// Skipped is true if the file bypassed compression
+	Skipped bool
+
+	// Reason explains the decision
+	Reason DecisionReason
+
+	// Algorithm and Level are the codec chosen, if any
+	Algorithm Algorithm
+	Level     int
+
+	// Detected is the algorithm found by magic-byte detection on read
+	Detected Algorithm
+}
+
+// Decision is the compression decision made for one written file, as
+// recorded in Config.DecisionLog
+type Decision struct {
+	Name      string
+	Algorithm Algorithm
+	Level     int
+	Skipped   bool
+	Reason    DecisionReason
+}
+
+// emitDecision reports a decision to Config.OnDecision and records write
+// decisions in Config.DecisionLog when set
+func (cfs *FS) emitDecision(ev DecisionEvent) {
+	if ev.Write && cfs.config.DecisionLog != nil {
+		cfs.decisionMu.Lock()
+		*cfs.config.DecisionLog = append(*cfs.config.DecisionLog, Decision{
+			Name:      ev.Name,
+			Algorithm: ev.Algorithm,
+			Level:     ev.Level,
+			Skipped:   ev.Skipped,
+			Reason:    ev.Reason,
+		})
+		cfs.decisionMu.Unlock()
+	}
+	if cfs.config.OnDecision != nil {
+		cfs.config.OnDecision(ev)
+	}
+}
+
+// Decisions returns a copy of the decisions recorded in Config.DecisionLog
+// so far, in the order files were closed, or nil if no log is configured
+func (cfs *FS) Decisions() []Decision {
+	if cfs.config.DecisionLog == nil {
+		return nil
+	}
+	cfs.decisionMu.Lock()
+	defer cfs.decisionMu.Unlock()
+	return append([]Decision(nil), *cfs.config.DecisionLog...)
+}
+
+// writeDecision builds a decision event for this file's write path
+func (cf *compressedFile) writeDecision(reason DecisionReason, algo Algorithm, level int) {
+	cf.cfs.emitDecision(DecisionEvent{
+		Name:       cf.originalName,
+		StoredName: cf.compressedName,
+		Write:      true,
+		Skipped:    reason != ReasonCompressed,
+		Reason:     reason,
+		Algorithm:  algo,
+		Level:      level,
+	})
+}
+
+// readDecision reports the outcome of read-side detection for this file
+func (cf *compressedFile) readDecision() {
+	ev := DecisionEvent{
+		Name:       cf.originalName,
+		StoredName: cf.compressedName,
+		Skipped:    cf.decompressor == nil,
+		Reason:     ReasonNotCompressed,
+	}
+	if cf.decompressor != nil {
+		ev.Reason = ReasonCompressed
+		ev.Algorithm = cf.readAlgo
+		ev.Detected = cf.readAlgo
+	} else if cf.cfs.shouldSkip(cf.originalName) {
+		ev.Reason = ReasonSkipPattern
+	}
+	cf.cfs.emitDecision(ev)
+}
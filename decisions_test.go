@@ -0,0 +1,180 @@
+package compressfs
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// decisionRecorder collects decision events
+type decisionRecorder struct {
+	mu     sync.Mutex
+	events []DecisionEvent
+}
+
+func (r *decisionRecorder) record(ev DecisionEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func (r *decisionRecorder) find(name string, write bool) (DecisionEvent, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ev := range r.events {
+		if ev.Name == name && ev.Write == write {
+			return ev, true
+		}
+	}
+	return DecisionEvent{}, false
+}
+
+// TestOnDecision tests that per-open decisions are reported with reasons
+func TestOnDecision(t *testing.T) {
+	rec := &decisionRecorder{}
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+		AutoDetect:        true,
+		MinSize:           100,
+		SkipPatterns:      []string{`\.jpg$`},
+		OnDecision:        rec.record,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	writeTestFile(t, cfs, "photo.jpg", []byte("jpeg bytes"))
+	writeTestFile(t, cfs, "tiny.txt", []byte("small"))
+	writeTestFile(t, cfs, "big.txt", bytes.Repeat([]byte("compress me "), 50))
+	readTestFile(t, cfs, "big.txt")
+
+	tests := []struct {
+		name    string
+		write   bool
+		skipped bool
+		reason  DecisionReason
+		algo    Algorithm
+	}{
+		{"photo.jpg", true, true, ReasonSkipPattern, ""},
+		{"tiny.txt", true, true, ReasonMinSize, ""},
+		{"big.txt", true, false, ReasonCompressed, AlgorithmGzip},
+		{"big.txt", false, false, ReasonCompressed, AlgorithmGzip},
+	}
+
+	for _, tt := range tests {
+		ev, ok := rec.find(tt.name, tt.write)
+		if !ok {
+			t.Errorf("No decision recorded for %s (write=%v)", tt.name, tt.write)
+			continue
+		}
+		if ev.Skipped != tt.skipped || ev.Reason != tt.reason || ev.Algorithm != tt.algo {
+			t.Errorf("%s (write=%v): got skipped=%v reason=%s algo=%s, want skipped=%v reason=%s algo=%s",
+				tt.name, tt.write, ev.Skipped, ev.Reason, ev.Algorithm, tt.skipped, tt.reason, tt.algo)
+		}
+	}
+
+	ev, _ := rec.find("big.txt", true)
+	if ev.StoredName != "big.txt.gz" || ev.Level != 6 {
+		t.Errorf("Expected big.txt.gz at level 6, got %s at level %d", ev.StoredName, ev.Level)
+	}
+	ev, _ = rec.find("big.txt", false)
+	if ev.Detected != AlgorithmGzip {
+		t.Errorf("Expected gzip detection on read, got %q", ev.Detected)
+	}
+}
+
+// TestDecisionLog tests that write decisions are recorded in order
+func TestDecisionLog(t *testing.T) {
+	var log []Decision
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+		MinSize:           100,
+		SkipPatterns:      []string{`\.jpg$`},
+		AlgorithmRules:    []AlgorithmRule{{Pattern: `\.log$`, Algorithm: AlgorithmZstd, Level: 6}},
+		DecisionLog:       &log,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	large := bytes.Repeat([]byte("decision log entry "), 50)
+	writeTestFile(t, cfs, "notes.txt", large)
+	writeTestFile(t, cfs, "photo.jpg", large)
+	writeTestFile(t, cfs, "tiny.txt", []byte("small"))
+	writeTestFile(t, cfs, "app.log", large)
+	readTestFile(t, cfs, "notes.txt")
+
+	want := []Decision{
+		{Name: "notes.txt", Algorithm: AlgorithmGzip, Level: 6, Reason: ReasonCompressed},
+		{Name: "photo.jpg", Skipped: true, Reason: ReasonSkipPattern},
+		{Name: "tiny.txt", Skipped: true, Reason: ReasonMinSize},
+		{Name: "app.log", Algorithm: AlgorithmZstd, Level: 6, Reason: ReasonCompressed},
+	}
+
+	got := cfs.Decisions()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d decisions, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Decision %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if len(log) != len(want) {
+		t.Errorf("Expected the configured log to hold %d entries, got %d", len(want), len(log))
+	}
+}
+
+// TestSubFSDecisionLog tests that a SubFS appends to its parent's
+// DecisionLog under the same lock, as under go test -race
+func TestSubFSDecisionLog(t *testing.T) {
+	var log []Decision
+	base := &subDirFiler{Filer: NewMemFS(), dirs: map[string]bool{"/sub": true}}
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		DecisionLog:       &log,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	sub, err := cfs.SubFS("sub")
+	if err != nil {
+		t.Fatalf("SubFS failed: %v", err)
+	}
+
+	const writes = 20
+	data := bytes.Repeat([]byte("logged from both filesystems "), 20)
+	var wg sync.WaitGroup
+	for _, fsys := range []*FS{cfs, sub} {
+		wg.Add(1)
+		go func(fsys *FS) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				f, err := fsys.Create(fmt.Sprintf("f%d.txt", i))
+				if err != nil {
+					t.Errorf("Create failed: %v", err)
+					return
+				}
+				f.Write(data)
+				if err := f.Close(); err != nil {
+					t.Errorf("Close failed: %v", err)
+				}
+			}
+		}(fsys)
+	}
+	wg.Wait()
+
+	if got := len(cfs.Decisions()); got != 2*writes {
+		t.Errorf("Expected %d decisions from the parent and the sub, got %d", 2*writes, got)
+	}
+}
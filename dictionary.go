@@ -0,0 +1,81 @@
+package compressfs
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdFrameDictID extracts the Dictionary_ID field from a zstd frame header.
+// It returns false if head is not the start of a zstd frame or is too short.
+// A frame written without a dictionary reports ID 0.
+func zstdFrameDictID(head []byte) (uint32, bool) {
+	magic := magicBytes[AlgorithmZstd]
+	if len(head) < len(magic)+1 || string(head[:len(magic)]) != string(magic) {
+		return 0, false
+	}
+
+	descriptor := head[len(magic)]
+	singleSegment := descriptor&0x20 != 0
+	offset := len(magic) + 1
+	if !singleSegment {
+		offset++ // Window_Descriptor
+	}
+
+	var size int
+	switch descriptor & 0x03 {
+	case 0:
+		return 0, true
+	case 1:
+		size = 1
+	case 2:
+		size = 2
+	case 3:
+		size = 4
+	}
+	if len(head) < offset+size {
+		return 0, false
+	}
+
+	field := make([]byte, 4)
+	copy(field, head[offset:offset+size])
+	return binary.LittleEndian.Uint32(field), true
+}
+
+// zstdDictionaryID returns the ID of a zstd dictionary, or 0 for raw
+// content dictionaries and invalid input
+func zstdDictionaryID(dict []byte) uint32 {
+	if len(dict) == 0 {
+		return 0
+	}
+	d, err := zstd.InspectDictionary(dict)
+	if err != nil {
+		return 0
+	}
+	return d.ID()
+}
+
+// selectZstdDictionary picks the dictionary needed to decode the zstd frame
+// starting with head. Frames without a dictionary ID use ZstdDictionary as
+// before; otherwise the ID must match ZstdDictionary or an entry in
+// Dictionaries, and a mismatch is reported as corrupted data.
+func (cfs *FS) selectZstdDictionary(head []byte) ([]byte, error) {
+	configured := cfs.config.ZstdDictionary
+
+	id, ok := zstdFrameDictID(head)
+	if !ok || id == 0 {
+		return configured, nil
+	}
+
+	configuredID := zstdDictionaryID(configured)
+	if id == configuredID {
+		return configured, nil
+	}
+	if dict, ok := cfs.config.Dictionaries[id]; ok {
+		return dict, nil
+	}
+
+	return nil, fmt.Errorf("%w: dictionary mismatch: frame requires dictionary %d, configured dictionary is %d",
+		ErrCorruptedData, id, configuredID)
+}
@@ -0,0 +1,157 @@
+package compressfs
+
+import (
+	"bytes"
+	"io"
+)
+
+// estimateSampleSize is how much of the head of a file is compressed to
+// estimate its stored size, by DiffConfig and for Config.Preallocate
+const estimateSampleSize = 64 << 10
+
+// ReencodePlan describes how a file would be stored under a new config
+type ReencodePlan struct {
+	Path          string    // Stored path on the base filesystem
+	Algorithm     Algorithm // Algorithm the file is stored with, "" if uncompressed
+	Level         int       // Level the file is assumed to be stored at
+	NewAlgorithm  Algorithm // Algorithm the new config selects, "" to store it uncompressed
+	NewLevel      int       // Level the new config selects
+	Reencode      bool      // Whether the new config chooses a different algorithm or level
+	Size          int64     // Current stored size
+	EstimatedSize int64     // Estimated stored size under the new config
+}
+
+// SizeChange returns the estimated change in stored size, negative when
+// re-encoding would save space
+func (p ReencodePlan) SizeChange() int64 {
+	return p.EstimatedSize - p.Size
+}
+
+// DiffConfig walks the base filesystem under root and reports, for every
+// file, whether newCfg would store it with a different algorithm or level
+// than it has now. Stored files do not record their level, so a file is
+// assumed to be at the level the current config selects for it, or at the
+// default level of its algorithm when the current config would choose
+// another. The size under newCfg is estimated by compressing the first 64KB
+// of the content and scaling the ratio to the whole file. Nothing is
+// written.
+func (cfs *FS) DiffConfig(root string, newCfg *Config) ([]ReencodePlan, error) {
+	target, err := New(cfs.base, newCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var plans []ReencodePlan
+	err = cfs.walkBase(cleanPath(root), func(name string) error {
+		plan, err := cfs.planReencode(target, name)
+		if err != nil {
+			return err
+		}
+		plans = append(plans, plan)
+		return nil
+	})
+	return plans, err
+}
+
+// planReencode compares how the stored file name is stored now with how
+// target would store it
+func (cfs *FS) planReencode(target *FS, name string) (ReencodePlan, error) {
+	info, err := cfs.base.Stat(name)
+	if err != nil {
+		return ReencodePlan{}, err
+	}
+	logical, _, ok := cfs.stripPlacedExtension(name)
+	if !ok {
+		logical = name
+	}
+
+	// Without StripExtension the stored name is the one files are read by
+	open := name
+	if cfs.config.StripExtension {
+		open = logical
+	}
+	f, fi, err := cfs.OpenWithInfo(open)
+	if err != nil {
+		return ReencodePlan{}, err
+	}
+	defer f.Close()
+
+	sample := make([]byte, estimateSampleSize)
+	n, err := io.ReadFull(f, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return ReencodePlan{}, err
+	}
+	sample = sample[:n]
+
+	size := fi.OriginalSize
+	if n < estimateSampleSize {
+		size = int64(n)
+	} else if size < 0 {
+		rest, err := io.Copy(io.Discard, f)
+		if err != nil {
+			return ReencodePlan{}, err
+		}
+		size = int64(n) + rest
+	}
+
+	plan := ReencodePlan{Path: name, Algorithm: fi.Algorithm, Size: info.Size()}
+	if plan.Algorithm != "" {
+		algo, level, _ := cfs.selectAlgorithm(logical, size)
+		if algo != plan.Algorithm {
+			level = cfs.getDefaultLevel(plan.Algorithm)
+		}
+		plan.Level = normalizeLevel(plan.Algorithm, level)
+	}
+
+	plan.NewAlgorithm, plan.NewLevel = target.planCodec(logical, size, sample)
+	plan.Reencode = plan.NewAlgorithm != plan.Algorithm || plan.NewLevel != plan.Level
+	if !plan.Reencode {
+		plan.EstimatedSize = plan.Size
+		return plan, nil
+	}
+	if plan.NewAlgorithm == "" || size == 0 {
+		plan.EstimatedSize = size
+		return plan, nil
+	}
+
+	plan.EstimatedSize, err = target.estimateStoredSize(plan.NewAlgorithm, plan.NewLevel, sample, size)
+	if err != nil {
+		return ReencodePlan{}, err
+	}
+	return plan, nil
+}
+
+// estimateStoredSize estimates the compressed size of size bytes of content
+// starting with sample, by compressing the sample and scaling the ratio
+func (cfs *FS) estimateStoredSize(algo Algorithm, level int, sample []byte, size int64) (int64, error) {
+	if len(sample) == 0 {
+		return 0, nil
+	}
+	var probe bytes.Buffer
+	compressor, err := cfs.newCompressor(algo, level, &probe)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := compressor.Write(sample); err != nil {
+		compressor.Close()
+		return 0, err
+	}
+	if err := compressor.Close(); err != nil {
+		return 0, err
+	}
+	return int64(float64(probe.Len()) / float64(len(sample)) * float64(size)), nil
+}
+
+// planCodec returns the algorithm and level a write of name with size bytes
+// starting with head would be stored with, or "" when it would be stored
+// uncompressed
+func (cfs *FS) planCodec(name string, size int64, head []byte) (Algorithm, int) {
+	if cfs.shouldSkip(name) || size < cfs.config.MinSize {
+		return "", 0
+	}
+	if _, ok := compressedMediaType(head); ok && cfs.config.SkipByContentType {
+		return "", 0
+	}
+	algo, level, _ := cfs.selectAlgorithm(name, size)
+	return algo, normalizeLevel(algo, level)
+}
@@ -0,0 +1,57 @@
+package compressfs
+
+import (
+	"testing"
+)
+
+// TestDiffConfig tests that files written under a gzip config are flagged for
+// re-encoding under a zstd config, and that an identical config flags nothing
+func TestDiffConfig(t *testing.T) {
+	base := NewMemFS()
+	current := &Config{Algorithm: AlgorithmGzip, Level: 6, PreserveExtension: true, StripExtension: true}
+	cfs, err := New(base, current)
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	files := map[string][]byte{
+		"/readme.txt": cdcTestData(4 << 10),
+		"/app.log":    cdcTestData(200 << 10),
+		"/data.csv":   cdcTestData(100 << 10),
+	}
+	for name, data := range files {
+		writeTestFile(t, cfs, name, data)
+	}
+
+	plans, err := cfs.DiffConfig("/", &Config{Algorithm: AlgorithmZstd, Level: 3, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("DiffConfig failed: %v", err)
+	}
+	if len(plans) != len(files) {
+		t.Fatalf("DiffConfig returned %d plans, expected %d", len(plans), len(files))
+	}
+	for _, plan := range plans {
+		if !plan.Reencode {
+			t.Errorf("%s not flagged for re-encoding", plan.Path)
+		}
+		if plan.Algorithm != AlgorithmGzip || plan.Level != 6 {
+			t.Errorf("%s reported as %s level %d, expected gzip level 6", plan.Path, plan.Algorithm, plan.Level)
+		}
+		if plan.NewAlgorithm != AlgorithmZstd || plan.NewLevel != 3 {
+			t.Errorf("%s planned as %s level %d, expected zstd level 3", plan.Path, plan.NewAlgorithm, plan.NewLevel)
+		}
+		if plan.EstimatedSize <= 0 || plan.EstimatedSize > 2*plan.Size {
+			t.Errorf("%s estimated at %d bytes, stored in %d", plan.Path, plan.EstimatedSize, plan.Size)
+		}
+	}
+
+	plans, err = cfs.DiffConfig("/", current)
+	if err != nil {
+		t.Fatalf("DiffConfig failed: %v", err)
+	}
+	for _, plan := range plans {
+		if plan.Reencode || plan.SizeChange() != 0 {
+			t.Errorf("%s flagged for re-encoding under the same config", plan.Path)
+		}
+	}
+}
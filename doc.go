@@ -61,6 +61,9 @@
 //   - Brotli:   6 MB/s  (best compression)
 //   - Zstd:     4 MB/s  (recommended - best ratio/speed balance)
 //
+// These figures vary widely between hosts; CalibrateThroughput measures
+// each algorithm on the machine it runs on.
+//
 // # Configuration Options
 //
 // Extension Handling:
@@ -1,9 +1,14 @@
 package compressfs
 
 import (
+	"bufio"
 	"bytes"
+	"fmt"
 	"io"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -14,6 +19,9 @@ var extensionMap = map[Algorithm]string{
 	AlgorithmLZ4:    ".lz4",
 	AlgorithmBrotli: ".br",
 	AlgorithmSnappy: ".sz",
+	AlgorithmXz:     ".xz",
+	AlgorithmZlib:   ".zz",
+	AlgorithmBzip2:  ".bz2",
 }
 
 // Reverse extension mapping (extension -> algorithm)
@@ -26,15 +34,32 @@ var reverseExtensionMap = map[string]Algorithm{
 	".br":     AlgorithmBrotli,
 	".sz":     AlgorithmSnappy,
 	".snappy": AlgorithmSnappy,
+	".xz":     AlgorithmXz,
+	".zz":     AlgorithmZlib,
+	".zlib":   AlgorithmZlib,
+	".bz2":    AlgorithmBzip2,
 }
 
-// Magic bytes for compression format detection
+// Magic bytes for compression format detection. Brotli streams have no
+// magic bytes and are only recognized by extension or by TryBrotli probing.
+// Zlib headers vary with the level and are matched by zlibHeaders instead.
 var magicBytes = map[Algorithm][]byte{
 	AlgorithmGzip:   {0x1f, 0x8b},                                         // gzip
 	AlgorithmZstd:   {0x28, 0xb5, 0x2f, 0xfd},                             // zstd
 	AlgorithmLZ4:    {0x04, 0x22, 0x4d, 0x18},                             // lz4
-	AlgorithmBrotli: {0xce, 0xb2, 0xcf, 0x81},                             // brotli (partial, first frame)
 	AlgorithmSnappy: {0xff, 0x06, 0x00, 0x00, 0x73, 0x4e, 0x61, 0x50}, // snappy framed
+	AlgorithmXz:     {0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00},             // xz "\xfd7zXZ\x00"
+	AlgorithmBzip2:  {0x42, 0x5a, 0x68},                               // bzip2 "BZh"
+}
+
+// zlibHeaders are the two header bytes zlib writes with a 32KB window at
+// each compression level class: fastest, fast, default and best. Tools that
+// store zlib streams under a .gz name are detected by these.
+var zlibHeaders = [][]byte{
+	{0x78, 0x01},
+	{0x78, 0x5e},
+	{0x78, 0x9c},
+	{0x78, 0xda},
 }
 
 // GetExtension returns the file extension for an algorithm
@@ -54,24 +79,28 @@ func DetectAlgorithmFromExtension(name string) (Algorithm, bool) {
 	return "", false
 }
 
-// DetectAlgorithm detects compression algorithm from magic bytes
+// magicProbeSize is enough leading bytes to detect any format
+const magicProbeSize = 10
+
+// DetectAlgorithm detects compression algorithm from magic bytes, consuming
+// up to the first 10 bytes of r
 func DetectAlgorithm(r io.Reader) (Algorithm, error) {
-	// Read enough bytes to detect any format
-	buf := make([]byte, 10)
-	n, err := io.ReadFull(r, buf)
-	if err != nil && err != io.ErrUnexpectedEOF {
+	// The limit keeps the buffer from reading past the probe
+	return peekAlgorithm(bufio.NewReader(io.LimitReader(r, magicProbeSize)))
+}
+
+// peekAlgorithm detects the compression algorithm of the stream behind br
+// from its magic bytes without consuming them, so detection needs no seek
+// back on readers that cannot seek. It returns "" when no format matches.
+func peekAlgorithm(br *bufio.Reader) (Algorithm, error) {
+	head, err := br.Peek(magicProbeSize)
+	if err != nil && err != io.EOF {
 		return "", err
 	}
-	buf = buf[:n]
 
 	// Check each algorithm's magic bytes
-	for algo, magic := range magicBytes {
-		if len(buf) >= len(magic) && bytes.Equal(buf[:len(magic)], magic) {
-			return algo, nil
-		}
-	}
-
-	return "", nil // No compression detected
+	algo, _ := IsCompressed(head)
+	return algo, nil
 }
 
 // AddExtension adds the compression extension to a filename
@@ -108,12 +137,149 @@ func HasCompressionExtension(name string) bool {
 	return ok
 }
 
-// IsCompressed checks if data appears to be compressed based on magic bytes
+// IsCompressed checks if data appears to be compressed based on magic bytes.
+// Brotli data is never reported, having no magic bytes to match, nor is
+// snappy's block format: only framed snappy streams carry a magic.
+// DetectCompression also recognizes snappy blocks. Formats are checked in
+// probeOrder, so the result never depends on map iteration.
 func IsCompressed(data []byte) (Algorithm, bool) {
-	for algo, magic := range magicBytes {
-		if len(data) >= len(magic) && bytes.Equal(data[:len(magic)], magic) {
+	for _, algo := range probeOrder {
+		magic, ok := magicBytes[algo]
+		if ok && len(data) >= len(magic) && bytes.Equal(data[:len(magic)], magic) {
 			return algo, true
 		}
 	}
+	for _, header := range zlibHeaders {
+		if bytes.HasPrefix(data, header) {
+			return AlgorithmZlib, true
+		}
+	}
 	return "", false
 }
+
+// Confidence rates a detection by DetectCompression
+type Confidence int
+
+const (
+	// ConfidenceNone means no compression format was recognized
+	ConfidenceNone Confidence = iota
+
+	// ConfidenceProbable means the data decodes as a format that has no
+	// magic bytes, which plain data may also do by chance
+	ConfidenceProbable
+
+	// ConfidenceCertain means the magic bytes of a format matched
+	ConfidenceCertain
+)
+
+// DetectCompression is IsCompressed with a confidence, also recognizing
+// snappy's block format, as snappy.Encode produces it. A block has no magic
+// bytes and is only recognized by decoding it, so data must hold the whole
+// block, and the detection is probable rather than certain.
+func DetectCompression(data []byte) (Algorithm, Confidence) {
+	if algo, ok := IsCompressed(data); ok {
+		return algo, ConfidenceCertain
+	}
+	if _, ok := decodeSnappyBlock(data); ok {
+		return AlgorithmSnappy, ConfidenceProbable
+	}
+	return "", ConfidenceNone
+}
+
+// extensionTemplate places the codec extension according to a
+// Config.ExtensionPlacement template such as "{name}.{algo}{ext}", where
+// {name} is the base name without its extension, {ext} is the original
+// extension including the dot, and {algo} is the codec extension without it
+type extensionTemplate struct {
+	template string
+	pattern  *regexp.Regexp // matches stored base names produced by template
+}
+
+// parseExtensionTemplate compiles a placement template for resolving stored
+// names back to logical names
+func parseExtensionTemplate(template string) (*extensionTemplate, error) {
+	if !strings.Contains(template, "{name}") || !strings.Contains(template, "{algo}") {
+		return nil, fmt.Errorf("%w: ExtensionPlacement %q must contain {name} and {algo}", ErrInvalidConfig, template)
+	}
+
+	algos := make([]string, 0, len(reverseExtensionMap))
+	for ext := range reverseExtensionMap {
+		algos = append(algos, regexp.QuoteMeta(strings.TrimPrefix(ext, ".")))
+	}
+	// Prefer the longest alias so "gzip" is not read as "gz" plus "ip"
+	sort.Slice(algos, func(i, j int) bool { return len(algos[i]) > len(algos[j]) })
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for rest := template; rest != ""; {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			expr.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		expr.WriteString(regexp.QuoteMeta(rest[:i]))
+		rest = rest[i:]
+
+		switch {
+		case strings.HasPrefix(rest, "{name}"):
+			expr.WriteString(`(?P<name>.+?)`)
+			rest = rest[len("{name}"):]
+		case strings.HasPrefix(rest, "{ext}"):
+			expr.WriteString(`(?P<ext>\.[^.]*)?`)
+			rest = rest[len("{ext}"):]
+		case strings.HasPrefix(rest, "{algo}"):
+			expr.WriteString(`(?P<algo>(?i:` + strings.Join(algos, "|") + `))`)
+			rest = rest[len("{algo}"):]
+		default:
+			expr.WriteString(regexp.QuoteMeta("{"))
+			rest = rest[1:]
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, err
+	}
+	return &extensionTemplate{template: template, pattern: re}, nil
+}
+
+// place returns the stored name for a logical name and codec extension
+func (t *extensionTemplate) place(name, ext string) string {
+	dir, file := path.Split(name)
+	origExt := path.Ext(file)
+	r := strings.NewReplacer(
+		"{name}", strings.TrimSuffix(file, origExt),
+		"{ext}", origExt,
+		"{algo}", strings.TrimPrefix(ext, "."),
+	)
+	return dir + r.Replace(t.template)
+}
+
+// strip returns the logical name and algorithm for a stored name produced
+// by the template
+func (t *extensionTemplate) strip(name string) (string, Algorithm, bool) {
+	dir, file := path.Split(name)
+	m := t.pattern.FindStringSubmatch(file)
+	if m == nil {
+		return name, "", false
+	}
+
+	var stem, origExt, codec string
+	for i, group := range t.pattern.SubexpNames() {
+		switch group {
+		case "name":
+			stem = m[i]
+		case "ext":
+			origExt = m[i]
+		case "algo":
+			codec = m[i]
+		}
+	}
+
+	algo, ok := reverseExtensionMap["."+strings.ToLower(codec)]
+	if !ok {
+		return name, "", false
+	}
+	return dir + stem + origExt, algo, true
+}
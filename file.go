@@ -1,11 +1,17 @@
 package compressfs
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/absfs/absfs"
 )
@@ -15,6 +21,7 @@ type compressedFile struct {
 	cfs  *FS
 	base absfs.File
 	flag int
+	ctx  context.Context // Set by OpenFileContext, otherwise never done
 
 	// Original and compressed names
 	originalName   string
@@ -23,31 +30,61 @@ type compressedFile struct {
 	// Compression state (write mode)
 	writeBuffer    *bytes.Buffer
 	compressor     io.WriteCloser
+	output         *bufio.Writer // BufferSize buffer compressors write the stored stream to
 	writeAlgo      Algorithm
 	writeLevel     int
 	shouldCompress bool
+	passthrough    bool   // StreamingSample chose raw storage
+	skippedType    string // Media type SkipByContentType stored raw
+	durable        bool   // Synced and finished before Close returns, for Replace
+	renameTo       string // Stored name to move to after close, if it changed
+	rewrite        string // Stored file an append rewrite replaces on close
+	appendMember   bool   // O_APPEND adds a new member after the stored data
+	forced         bool   // writeAlgo and writeLevel are not re-evaluated
+	normalize      bool   // CRLF line endings are converted before compression
+	crlf           bool   // The buffered text was converted from CRLF
+	preallocated   bool   // The base was extended to the estimated size under Preallocate
 
 	// Decompression state (read mode)
 	decompressor io.ReadCloser
 	readAlgo     Algorithm
+	originalSize int64         // Size recorded by the stream itself, -1 if unknown
+	sizeResolved bool          // originalSize has been read from the stream
+	meta         *fileMetadata // Footer recorded under EmbedFileMetadata, if any
+
+	// Sequential ReadAt state (read mode, AllowSequentialReadAt)
+	readAtDecoder io.ReadCloser // Decoder serving ReadAt, nil until first use
+	readAtPos     int64         // Logical offset readAtDecoder has reached
 
 	// Metadata
 	bytesRead    int64
 	bytesWritten int64
+	storedBytes  int64         // Compressed bytes written to the base, without footers
+	hasher       hash.Hash     // StoreContentHash hash of the bytes written
+	codecTime    time.Duration // Time spent in the compressor or decompressor
+	ratio        float64       // Stored size over bytes written, -1 until closed
 	closed       bool
 	mu           sync.Mutex
 }
 
 // newCompressedFile creates a new compressed file wrapper
-func newCompressedFile(cfs *FS, base absfs.File, originalName, compressedName string, flag int, algo Algorithm) (*compressedFile, error) {
+func newCompressedFile(cfs *FS, base absfs.File, originalName, compressedName string, flag int, algo Algorithm, level int) (*compressedFile, error) {
 	cf := &compressedFile{
 		cfs:            cfs,
 		base:           base,
 		flag:           flag,
+		ctx:            context.Background(),
 		originalName:   originalName,
 		compressedName: compressedName,
 		writeAlgo:      algo,
 		readAlgo:       algo,
+		originalSize:   -1,
+		ratio:          -1,
+	}
+
+	// Directories pass through untouched: there is nothing to sniff or compress
+	if info, err := base.Stat(); err == nil && info.IsDir() {
+		return cf, nil
 	}
 
 	var isCreate = (flag & os.O_CREATE) != 0
@@ -60,6 +97,9 @@ func newCompressedFile(cfs *FS, base absfs.File, originalName, compressedName st
 	// Setup for writing
 	if isWrite && cf.shouldCompress {
 		cf.writeBuffer = new(bytes.Buffer)
+		if cfs.config.StoreContentHash {
+			cf.hasher = newContentHasher(cfs.config.ContentHashAlgorithm)
+		}
 
 		// Select algorithm and level based on rules/auto-tuning
 		// We'll determine the final algorithm and level at close time when we know the file size
@@ -70,10 +110,19 @@ func newCompressedFile(cfs *FS, base absfs.File, originalName, compressedName st
 			cf.writeLevel = level
 		} else {
 			cf.writeAlgo = algo
-			cf.writeLevel = cfs.config.Level
+			cf.writeLevel = level
 		}
 	}
 
+	// Report skipped writes now; compressed writes are reported on Close
+	if isWrite && !cf.shouldCompress {
+		reason := ReasonSkipPattern
+		if !cfs.shouldSkip(originalName) {
+			reason = ReasonAlreadyCompressed
+		}
+		cf.writeDecision(reason, "", 0)
+	}
+
 	// Setup for reading (not on create operations)
 	if isReadOnly && !isCreate {
 		// Check if file is empty first
@@ -122,13 +171,19 @@ func newCompressedFile(cfs *FS, base absfs.File, originalName, compressedName st
 
 					if shouldDecompress {
 						var decompressor io.ReadCloser
+						var usedAlgo Algorithm
 						var err error
-
-						// Use dictionary if available for zstd
-						if useAlgo == AlgorithmZstd && len(cfs.config.ZstdDictionary) > 0 {
-							decompressor, err = createDecompressorWithDict(useAlgo, cf.base, cfs.config.Level, cfs.config.ZstdDictionary)
-						} else {
-							decompressor, err = createDecompressor(useAlgo, cf.base, cfs.config.Level)
+						if useAlgo == AlgorithmSnappy && !isCompressed {
+							// Without the framed magic, a snappy file
+							// may hold a single block
+							decompressor, err = cf.openSnappyBlock()
+							usedAlgo = AlgorithmSnappy
+						}
+						if decompressor == nil && err == nil {
+							decompressor, usedAlgo, err = cf.openWithFallbacks(useAlgo, magicBuf[:n])
+						}
+						if errors.Is(err, ErrCorruptedData) {
+							return nil, err
 						}
 
 						if err != nil {
@@ -136,21 +191,36 @@ func newCompressedFile(cfs *FS, base absfs.File, originalName, compressedName st
 							cf.shouldCompress = false
 						} else {
 							cf.decompressor = decompressor
-							cf.readAlgo = useAlgo
+							cf.readAlgo = usedAlgo
 						}
 					} else {
 						cf.shouldCompress = false
 					}
 				}
 			}
-		} else if !isEmpty && cfs.config.AutoDetect {
+		} else if !isEmpty && cfs.config.AutoDetect && !cfs.shouldSkip(originalName) {
+			// Skip decisions are authoritative: skip-matched names are
+			// stored verbatim and never decompressed
 			// Try to detect algorithm
 			if err := cf.detectAndSetupDecompressor(); err != nil {
+				if errors.Is(err, ErrCorruptedData) {
+					return nil, err
+				}
 				// If detection fails, try to read uncompressed
 				cf.shouldCompress = false
 			}
 		}
 		// If file is empty, don't set up decompressor - just read as empty
+
+		// A raw read must not be attributed to the algorithm its extension
+		// implied, such as a plaintext file that is merely named .gz
+		if cf.decompressor == nil {
+			cf.readAlgo = ""
+		}
+		if cf.readAlgo == AlgorithmZstd {
+			cf.setupSeekable()
+		}
+		cf.readDecision()
 	}
 
 	return cf, nil
@@ -169,8 +239,20 @@ func (cf *compressedFile) detectAndSetupDecompressor() error {
 		return nil // Empty file
 	}
 
-	// Detect algorithm
+	// Detect algorithm. Brotli has no magic bytes, so without an extension
+	// it is only found by probing.
 	algo, detected := IsCompressed(buf[:n])
+	if !detected && cf.cfs.config.TryBrotli {
+		if _, _, known := cf.cfs.stripPlacedExtension(cf.compressedName); !known {
+			ok, err := cf.probeBrotli()
+			if err != nil {
+				return err
+			}
+			if ok {
+				algo, detected = AlgorithmBrotli, true
+			}
+		}
+	}
 	if !detected {
 		// Not compressed, read as-is
 		cf.shouldCompress = false
@@ -181,41 +263,180 @@ func (cf *compressedFile) detectAndSetupDecompressor() error {
 		return nil
 	}
 
-	cf.readAlgo = algo
-
 	// Seek back to start for decompressor
 	if _, err := cf.base.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
 
 	// Create decompressor with dictionary support
-	var decompressor io.ReadCloser
-	if algo == AlgorithmZstd && len(cf.cfs.config.ZstdDictionary) > 0 {
-		decompressor, err = createDecompressorWithDict(algo, cf.base, cf.cfs.config.Level, cf.cfs.config.ZstdDictionary)
-	} else {
-		decompressor, err = createDecompressor(algo, cf.base, cf.cfs.config.Level)
-	}
-
+	decompressor, usedAlgo, err := cf.openWithFallbacks(algo, buf[:n])
 	if err != nil {
 		return err
 	}
 
 	cf.decompressor = decompressor
+	cf.readAlgo = usedAlgo
 	return nil
 }
 
+// openDecompressor creates a decompressor reading from the base file. For
+// zstd, head holds the start of the stream and is used to pick the
+// dictionary the frame was written with.
+func (cf *compressedFile) openDecompressor(algo Algorithm, head []byte) (io.ReadCloser, error) {
+	if algo != AlgorithmZstd {
+		return cf.cfs.newDecompressor(algo, cf.bufferedInput(cf.base), nil)
+	}
+
+	dict, err := cf.cfs.selectZstdDictionary(head)
+	if err != nil {
+		return nil, err
+	}
+	return cf.cfs.newDecompressor(algo, cf.bufferedInput(cf.base), dict)
+}
+
+// decodeProbeSize is the amount of file head decoded when probing whether
+// a stream decodes as a given algorithm
+const decodeProbeSize = 4096
+
+// decodeProbeDrain bounds the output decoded past the probe from a file
+// shorter than decodeProbeSize while checking that its stream ends cleanly
+const decodeProbeDrain = 1 << 20
+
+// probeBrotli attempts to decode the head of the base file as brotli and
+// reports whether it produced valid output. The base is rewound afterwards.
+func (cf *compressedFile) probeBrotli() (bool, error) {
+	return cf.probeDecode(AlgorithmBrotli)
+}
+
+// probeDecode attempts to decode the head of the base file as algo and
+// reports whether it produced valid output. The base is rewound afterwards.
+func (cf *compressedFile) probeDecode(algo Algorithm) (bool, error) {
+	if _, err := cf.base.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	head := make([]byte, decodeProbeSize)
+	n, err := io.ReadFull(cf.base, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	head = head[:n]
+
+	if _, err := cf.base.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	var dict []byte
+	if algo == AlgorithmZstd {
+		if dict, err = cf.cfs.selectZstdDictionary(head); err != nil {
+			return false, nil
+		}
+	}
+	decoder, err := cf.cfs.newDecompressor(algo, bytes.NewReader(head), dict)
+	if err != nil {
+		return false, nil
+	}
+	defer decoder.Close()
+
+	// A stream cut off by the end of the probe still counts as long as the
+	// decoder produced output without reporting a format error. When head
+	// holds the whole file, the stream must end cleanly.
+	out := make([]byte, decodeProbeSize)
+	decoded := 0
+	var derr error
+	for decoded < len(out) && derr == nil {
+		var m int
+		m, derr = decoder.Read(out[decoded:])
+		decoded += m
+	}
+	if decoded == 0 {
+		return false, nil
+	}
+	if n == decodeProbeSize {
+		return derr == nil || derr == io.EOF || errors.Is(derr, io.ErrUnexpectedEOF), nil
+	}
+	if derr == nil {
+		// Decode on to the end of the file, within a bound on the output
+		if _, derr = io.CopyN(io.Discard, decoder, decodeProbeDrain); derr == nil {
+			return true, nil
+		}
+	}
+	return derr == io.EOF, nil
+}
+
+// openSnappyBlock reads the base file whole and returns its content decoded
+// when it is a snappy block, or nil with the file rewound when it is not
+func (cf *compressedFile) openSnappyBlock() (io.ReadCloser, error) {
+	data, err := io.ReadAll(io.LimitReader(cf.base, snappyBlockMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if decoded, ok := decodeSnappyBlock(data); ok {
+		return io.NopCloser(bytes.NewReader(decoded)), nil
+	}
+	_, err = cf.base.Seek(0, io.SeekStart)
+	return nil, err
+}
+
+// openWithFallbacks opens a decompressor for algo, trying
+// Config.DecodeFallbacks in order when the head of the stream does not
+// decode as algo. It returns the algorithm that was used.
+func (cf *compressedFile) openWithFallbacks(algo Algorithm, head []byte) (io.ReadCloser, Algorithm, error) {
+	fallbacks := cf.cfs.config.DecodeFallbacks
+	if len(fallbacks) == 0 {
+		decompressor, err := cf.openDecompressor(algo, head)
+		return decompressor, algo, err
+	}
+
+	var firstErr error
+	for i, candidate := range append([]Algorithm{algo}, fallbacks...) {
+		if i > 0 && candidate == algo {
+			continue
+		}
+
+		ok, err := cf.probeDecode(candidate)
+		if err != nil {
+			return nil, "", err
+		}
+		if !ok {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("compressfs: stream does not decode as %s", candidate)
+			}
+			continue
+		}
+
+		decompressor, err := cf.openDecompressor(candidate, head)
+		if err == nil {
+			return decompressor, candidate, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, "", firstErr
+}
+
 // Read reads from the file with decompression
 func (cf *compressedFile) Read(p []byte) (n int, err error) {
 	cf.mu.Lock()
 	defer cf.mu.Unlock()
+	return cf.read(p)
+}
 
+// read is Read with cf.mu held
+func (cf *compressedFile) read(p []byte) (n int, err error) {
 	if cf.closed {
 		return 0, fs.ErrClosed
 	}
+	if err := cf.ctx.Err(); err != nil {
+		return 0, err
+	}
 
 	// If decompressor is set up, read from it
 	if cf.decompressor != nil {
+		start := time.Now()
 		n, err = cf.decompressor.Read(p)
+		cf.codecTime += time.Since(start)
 		if n > 0 {
 			cf.bytesRead += int64(n)
 			cf.cfs.addBytes(&cf.cfs.stats.BytesRead, int64(n))
@@ -242,16 +463,62 @@ func (cf *compressedFile) Read(p []byte) (n int, err error) {
 func (cf *compressedFile) Write(p []byte) (n int, err error) {
 	cf.mu.Lock()
 	defer cf.mu.Unlock()
+	return cf.write(p)
+}
 
+// write is Write with cf.mu held
+func (cf *compressedFile) write(p []byte) (n int, err error) {
 	if cf.closed {
 		return 0, fs.ErrClosed
 	}
+	if err := cf.ctx.Err(); err != nil {
+		return 0, err
+	}
 
-	// If we should compress, write to buffer
+	// If we should compress, write to buffer or the streaming sink
 	if cf.shouldCompress && cf.writeBuffer != nil {
-		n, err = cf.writeBuffer.Write(p)
+		// A new member is compressed whatever its size: the stored file
+		// already holds compressed data
+		if cf.appendMember && cf.compressor == nil {
+			if err := cf.startStreaming(cf.writeAlgo, cf.writeLevel); err != nil {
+				return 0, err
+			}
+		}
+		switch {
+		case cf.compressor != nil:
+			// Report consumed input bytes, never the compressed output size
+			start := time.Now()
+			n, err = cf.compressor.Write(p)
+			cf.codecTime += time.Since(start)
+			if err == nil {
+				n = len(p)
+			}
+		case cf.passthrough:
+			n, err = cf.base.Write(p)
+		default:
+			n, err = cf.writeBuffer.Write(p)
+		}
 		if n > 0 {
 			cf.bytesWritten += int64(n)
+			if cf.hasher != nil {
+				cf.hasher.Write(p[:n])
+			}
+		}
+		if err == nil && cf.compressor == nil && !cf.passthrough {
+			if cf.cfs.config.StreamingSample && len(cf.cfs.config.TrySmallest) == 0 && !cf.normalize {
+				if cf.writeBuffer.Len() >= cf.cfs.config.BufferSize {
+					err = cf.decideFromSample()
+				}
+			} else if limit, ok := cf.streamThreshold(); ok && cf.writeBuffer.Len() > 0 && int64(cf.writeBuffer.Len()) >= limit {
+				// The size decisions are settled: stream from here on
+				if skipped, serr := cf.skipByContentType(); skipped || serr != nil {
+					return n, serr
+				}
+				start := time.Now()
+				algo, level := cf.selectWriteCodec(int64(cf.writeBuffer.Len()))
+				err = cf.startStreaming(algo, level)
+				cf.codecTime += time.Since(start)
+			}
 		}
 		return n, err
 	}
@@ -265,7 +532,351 @@ func (cf *compressedFile) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
-// Close closes the file and flushes compression if needed
+// ReadFrom implements io.ReaderFrom, so io.Copy into the file takes the
+// lock once for the whole copy instead of once per chunk
+func (cf *compressedFile) ReadFrom(r io.Reader) (int64, error) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	// Nothing is consumed from r once the file is closed
+	if cf.closed {
+		return 0, fs.ErrClosed
+	}
+
+	buf := make([]byte, copyBufferSize)
+	var total int64
+	for {
+		nr, rerr := r.Read(buf)
+		if nr > 0 {
+			nw, err := cf.write(buf[:nr])
+			total += int64(nw)
+			if err != nil {
+				return total, err
+			}
+			if nw < nr {
+				return total, io.ErrShortWrite
+			}
+		}
+		if rerr == io.EOF {
+			return total, nil
+		}
+		if rerr != nil {
+			return total, rerr
+		}
+	}
+}
+
+// WriteTo implements io.WriterTo, so io.Copy out of the file takes the
+// lock once for the whole copy instead of once per chunk
+func (cf *compressedFile) WriteTo(w io.Writer) (int64, error) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	buf := make([]byte, copyBufferSize)
+	var total int64
+	for {
+		nr, rerr := cf.read(buf)
+		if nr > 0 {
+			nw, err := w.Write(buf[:nr])
+			total += int64(nw)
+			if err != nil {
+				return total, err
+			}
+			if nw < nr {
+				return total, io.ErrShortWrite
+			}
+		}
+		if rerr == io.EOF {
+			return total, nil
+		}
+		if rerr != nil {
+			return total, rerr
+		}
+	}
+}
+
+// sampleRatioThreshold is the compressed/original ratio a head sample must
+// beat for StreamingSample to enable compression
+const sampleRatioThreshold = 0.9
+
+// decideFromSample compresses the buffered head sample to judge whether the
+// stream is worth compressing, then switches to either a streaming
+// compressor or raw passthrough for the rest of the file
+func (cf *compressedFile) decideFromSample() error {
+	start := time.Now()
+	defer func() { cf.codecTime += time.Since(start) }()
+
+	if skipped, err := cf.skipByContentType(); skipped || err != nil {
+		return err
+	}
+
+	sample := cf.writeBuffer.Bytes()[:cf.cfs.config.BufferSize]
+	algo, level := cf.selectWriteCodec(int64(len(sample)))
+
+	var probe bytes.Buffer
+	compressor, err := cf.newCompressor(algo, level, &probe)
+	if err != nil {
+		return err
+	}
+	if _, err := compressor.Write(sample); err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+
+	if float64(probe.Len()) >= float64(len(sample))*sampleRatioThreshold {
+		// Incompressible head: write everything raw from here on
+		cf.passthrough = true
+		_, err := io.Copy(cf.base, cf.writeBuffer)
+		return err
+	}
+
+	return cf.startStreaming(algo, level)
+}
+
+// normalizeBuffer converts the CRLF line endings of a fully buffered write
+// to LF when it will be stored compressed. Text that would fall below
+// MinSize is left alone, since a file stored raw carries no marker to
+// restore it from.
+func (cf *compressedFile) normalizeBuffer() {
+	if !cf.normalize || cf.compressor != nil || cf.passthrough {
+		return
+	}
+	data, ok := normalizeCRLF(cf.writeBuffer.Bytes())
+	if !ok || int64(len(data)) < cf.cfs.config.MinSize {
+		return
+	}
+	cf.writeBuffer = bytes.NewBuffer(data)
+	cf.crlf = true
+}
+
+// streamThreshold returns how many bytes are buffered before a write is
+// handed to a streaming compressor: enough to settle the MinSize and
+// auto-tuning decisions, up to the last auto-tuning tier, and ParallelThreshold with parallel compression so
+// large files are known to qualify. It reports false when the whole file
+// must be buffered until Close, for TrySmallest, ZstdContentSize,
+// AsyncCompress and line ending normalization.
+func (cf *compressedFile) streamThreshold() (int64, bool) {
+	config := cf.cfs.config
+	if (len(config.TrySmallest) > 0 && !cf.forced) || config.ZstdContentSize || config.AsyncCompress || cf.normalize {
+		return 0, false
+	}
+
+	limit := config.MinSize
+	if config.EnableAutoTuning {
+		if config.AutoTuneSizeThreshold > limit {
+			limit = config.AutoTuneSizeThreshold
+		}
+		if autoTuneLargeSize+1 > limit {
+			limit = autoTuneLargeSize + 1
+		}
+	}
+	if config.EnableParallelCompression && config.ParallelThreshold > limit {
+		limit = config.ParallelThreshold
+	}
+	return limit, true
+}
+
+// startStreaming switches a buffered write to a streaming compressor on the
+// base file, flushing the buffered prefix through it first
+func (cf *compressedFile) startStreaming(algo Algorithm, level int) error {
+	cf.preallocate(algo, level)
+	var compressor io.WriteCloser
+	if cf.cfs.useContentChunking(algo) {
+		compressor = cf.cfs.newChunkingCompressor(algo, level, cf.bufferedOutput())
+	} else if cf.cfs.useParallel(algo, int64(cf.writeBuffer.Len())) {
+		compressor = cf.cfs.newParallelCompressor(cf.ctx, algo, level, cf.bufferedOutput())
+	} else {
+		var err error
+		if compressor, err = cf.newCompressor(algo, level, cf.bufferedOutput()); err != nil {
+			return err
+		}
+	}
+	cf.compressor = compressor
+	cf.writeAlgo = algo
+	cf.writeLevel = level
+	return cf.flushBuffer(cf.compressor)
+}
+
+// bufferedOutput returns the base file behind a Config.BufferSize buffer,
+// which compressors write through so their many small writes reach the
+// base in few large ones
+func (cf *compressedFile) bufferedOutput() io.Writer {
+	if cf.output == nil {
+		cf.output = bufio.NewWriterSize(baseWriter{cf}, cf.cfs.config.BufferSize)
+	}
+	return cf.output
+}
+
+// baseWriter writes the stored stream to the base file, counting it in
+// storedBytes
+type baseWriter struct {
+	cf *compressedFile
+}
+
+func (w baseWriter) Write(p []byte) (int, error) {
+	n, err := w.cf.base.Write(p)
+	w.cf.storedBytes += int64(n)
+	return n, err
+}
+
+// closeCompressor closes a compressor writing through bufferedOutput and
+// flushes what it left in the buffer to the base file
+func (cf *compressedFile) closeCompressor(compressor io.WriteCloser) error {
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	if cf.output != nil {
+		return cf.output.Flush()
+	}
+	return nil
+}
+
+// bufferedInput returns r behind a Config.BufferSize buffer for a
+// decompressor to read from
+func (cf *compressedFile) bufferedInput(r io.Reader) io.Reader {
+	return bufio.NewReaderSize(r, cf.cfs.config.BufferSize)
+}
+
+// flushBuffer copies the buffered write into compressor. For a file opened
+// with OpenFileContext the copy goes in copyBufferSize pieces, so that a
+// cancellation stops it between them.
+func (cf *compressedFile) flushBuffer(compressor io.Writer) error {
+	if cf.ctx.Done() == nil {
+		_, err := io.Copy(compressor, cf.writeBuffer)
+		return err
+	}
+	buf := make([]byte, copyBufferSize)
+	_, err := io.CopyBuffer(onlyWriter{compressor}, contextReader{ctx: cf.ctx, r: cf.writeBuffer}, buf)
+	return err
+}
+
+// contextReader fails reads once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// abortWrite closes a write whose context was cancelled and removes the
+// partially written stored file
+func (cf *compressedFile) abortWrite(err error) error {
+	if cf.compressor != nil {
+		cf.compressor.Close()
+	}
+	cf.base.Close()
+	cf.cfs.base.Remove(cf.compressedName)
+	return err
+}
+
+// selectWriteCodec chooses the final algorithm and level for a file of the given size
+func (cf *compressedFile) selectWriteCodec(size int64) (Algorithm, int) {
+	if cf.forced {
+		return cf.writeAlgo, cf.writeLevel
+	}
+
+	// Re-evaluate algorithm and level based on actual file size. A matching
+	// rule's level is used as is, including an explicit 0, and takes
+	// precedence over auto-tuning, which only adjusts the level the file
+	// was opened with.
+	finalAlgo, finalLevel, _ := cf.cfs.selectCodec(cf.originalName, size, cf.writeAlgo, cf.writeLevel)
+	return finalAlgo, finalLevel
+}
+
+// newCompressor creates a compressor writing to w
+func (cf *compressedFile) newCompressor(algo Algorithm, level int, w io.Writer) (io.WriteCloser, error) {
+	return cf.cfs.newCompressor(algo, level, w)
+}
+
+// recordCompressed updates statistics and metrics for a file written
+// compressed, counting the time since flushStart as compression time
+func (cf *compressedFile) recordCompressed(algo Algorithm, flushStart time.Time) {
+	cf.codecTime += time.Since(flushStart)
+
+	cf.cfs.incrementStat(&cf.cfs.stats.FilesCompressed)
+	cf.cfs.addBytes(&cf.cfs.stats.BytesWritten, cf.bytesWritten)
+	cf.cfs.addBytes(&cf.cfs.stats.BytesCompressed, cf.bytesWritten)
+	cf.cfs.stats.IncrementAlgorithmCount(algo)
+	cf.cfs.stats.recordAlgorithmBytes(algo, cf.bytesWritten, cf.storedBytes)
+
+	if m := cf.cfs.config.Metrics; m != nil {
+		m.ObserveCompress(algo, cf.bytesWritten, cf.storedSize(), cf.codecTime)
+	}
+}
+
+// closeUncompressed closes the base file of a file stored uncompressed and,
+// if it was opened under a compression extension, renames it to its logical
+// name to avoid confusion on read
+func (cf *compressedFile) closeUncompressed(err error) error {
+	if serr := cf.syncOnClose(); serr != nil && err == nil {
+		err = serr
+	}
+	cf.recordRatio()
+
+	if _, _, placed := cf.cfs.stripPlacedExtension(cf.compressedName); cf.rewrite == "" && (cf.compressedName == cf.originalName || !placed) {
+		if cerr := cf.base.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		return err
+	}
+
+	// Close the base file before renaming
+	if cerr := cf.base.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if cf.rewrite != "" {
+		return cf.commitRewrite(cf.originalName, err)
+	}
+
+	// Rename from compressed name to original name
+	if renameErr := cf.cfs.base.Rename(cf.compressedName, cf.originalName); renameErr != nil {
+		// If rename fails, it's not critical - we can still read the file
+		// It just might try to decompress it unnecessarily
+	}
+
+	return err
+}
+
+// commitRewrite moves an append rewrite from its temporary name to final
+// once the file is closed without error, and removes the stored file it
+// replaces if that had a different name
+func (cf *compressedFile) commitRewrite(final string, err error) error {
+	if err != nil {
+		return err
+	}
+	if err := cf.cfs.base.Rename(cf.compressedName, final); err != nil {
+		return err
+	}
+	if cf.rewrite != final {
+		if err := cf.cfs.base.Remove(cf.rewrite); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncOnClose syncs written data to stable storage before the base file is
+// closed when Config.SyncOnClose is set
+func (cf *compressedFile) syncOnClose() error {
+	if !(cf.cfs.config.SyncOnClose || cf.durable) || cf.flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) == 0 {
+		return nil
+	}
+	if err := cf.base.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) && !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
+
+// Close closes the file and flushes compression if needed. The file is
+// closed even when the flush fails: every later operation returns
+// fs.ErrClosed.
 func (cf *compressedFile) Close() error {
 	cf.mu.Lock()
 	defer cf.mu.Unlock()
@@ -275,82 +886,178 @@ func (cf *compressedFile) Close() error {
 	}
 	cf.closed = true
 
-	var err error
+	// Hand buffered writes to a worker; the file is finished under its lock
+	if cf.cfs.config.AsyncCompress && cf.shouldCompress && cf.writeBuffer != nil && cf.compressor == nil && !cf.passthrough && !cf.durable {
+		cf.cfs.async.Add(1)
+		go func() {
+			defer cf.cfs.async.Done()
+			cf.mu.Lock()
+			defer cf.mu.Unlock()
+
+			if err := cf.finish(); err != nil {
+				if fn := cf.cfs.config.OnAsyncError; fn != nil {
+					fn(cf.originalName, err)
+				}
+			}
+		}()
+		return nil
+	}
+	return cf.finish()
+}
 
-	// Flush compression on write
-	if cf.shouldCompress && cf.writeBuffer != nil {
-		bufLen := int64(cf.writeBuffer.Len())
+// finish flushes any pending compression and closes the file. The caller
+// holds cf.mu.
+func (cf *compressedFile) finish() (err error) {
+	defer cf.cfs.releaseSlot()
 
-		// Check minimum size and that buffer is not empty
-		if bufLen > 0 && bufLen >= cf.cfs.config.MinSize {
-			// Re-evaluate algorithm and level based on actual file size (auto-tuning)
-			finalAlgo, finalLevel, _ := cf.cfs.selectAlgorithm(cf.originalName, bufLen)
+	// A write cancelled through its context, before or during the flush,
+	// leaves no partial file behind
+	if cf.ctx.Done() != nil && cf.flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 {
+		if cerr := cf.ctx.Err(); cerr != nil {
+			return cf.abortWrite(cerr)
+		}
+		defer func() {
+			if cerr := cf.ctx.Err(); err != nil && cerr != nil {
+				cf.cfs.base.Remove(cf.compressedName)
+				err = cerr
+			}
+		}()
+	}
 
-			// Use the selected algorithm/level, or stick with what was determined earlier
-			// if rules were used (rules take precedence over auto-tuning)
-			if cf.writeLevel != 0 {
-				// Use the level that was set (either from rules or initial selection)
-				finalLevel = cf.writeLevel
+	// A failed append rewrite leaves the stored file as it was
+	if cf.rewrite != "" {
+		defer func() {
+			if err != nil {
+				cf.cfs.base.Remove(cf.compressedName)
 			}
+		}()
+	}
 
-			// Create compressor with dictionary support
-			var compressor io.WriteCloser
-			var cerr error
+	var written Algorithm // Algorithm the data was compressed with
 
-			// Check if we should use dictionary (only for zstd)
-			if finalAlgo == AlgorithmZstd && len(cf.cfs.config.ZstdDictionary) > 0 {
-				compressor, cerr = createCompressorWithDict(finalAlgo, cf.base, finalLevel, cf.cfs.config.ZstdDictionary)
-			} else {
-				compressor, cerr = createCompressor(finalAlgo, cf.base, finalLevel)
-			}
+	// Flush compression on write
+	if cf.shouldCompress && cf.writeBuffer != nil {
+		if _, serr := cf.skipByContentType(); serr != nil {
+			cf.base.Close()
+			return serr
+		}
+		cf.normalizeBuffer()
+		bufLen := int64(cf.writeBuffer.Len())
+		flushStart := time.Now()
 
+		if cf.compressor != nil {
+			// Streaming compressor already holds all data
+			if cerr := cf.closeCompressor(cf.compressor); cerr != nil {
+				cf.base.Close()
+				return cerr
+			}
+			cf.recordCompressed(cf.writeAlgo, flushStart)
+			written = cf.writeAlgo
+			cf.writeDecision(ReasonCompressed, cf.writeAlgo, cf.writeLevel)
+		} else if cf.passthrough {
+			// Head sample was incompressible or of an already-compressed
+			// media type, data already written raw
+			reason := ReasonIncompressible
+			if cf.skippedType != "" {
+				reason = ReasonContentType
+			}
+			cf.cfs.incrementStat(&cf.cfs.stats.FilesSkipped)
+			cf.writeDecision(reason, "", 0)
+			return cf.closeUncompressed(nil)
+		} else if bufLen > 0 && bufLen >= cf.cfs.config.MinSize && len(cf.cfs.config.TrySmallest) > 0 && !cf.forced {
+			// Keep the smallest of the candidate encodings
+			bestAlgo, bestLevel, cerr := cf.writeSmallest()
 			if cerr != nil {
 				cf.base.Close()
 				return cerr
 			}
+			cf.recordCompressed(bestAlgo, flushStart)
+			written = bestAlgo
+			cf.writeDecision(ReasonCompressed, bestAlgo, bestLevel)
+		} else if bufLen > 0 && bufLen >= cf.cfs.config.MinSize {
+			// Check minimum size and that buffer is not empty
+			finalAlgo, finalLevel := cf.selectWriteCodec(bufLen)
+			cf.preallocate(finalAlgo, finalLevel)
+
+			// Create compressor with dictionary support, splitting files
+			// at content-defined boundaries or, when large, into chunks
+			// compressed in parallel
+			var compressor io.WriteCloser
+			var cerr error
+			if cf.cfs.useContentChunking(finalAlgo) {
+				compressor = cf.cfs.newChunkingCompressor(finalAlgo, finalLevel, cf.bufferedOutput())
+			} else if cf.cfs.useParallel(finalAlgo, bufLen) {
+				compressor = cf.cfs.newParallelCompressor(cf.ctx, finalAlgo, finalLevel, cf.bufferedOutput())
+			} else if compressor, cerr = cf.cfs.newSizedCompressor(finalAlgo, finalLevel, cf.bufferedOutput(), bufLen); cerr != nil {
+				cf.base.Close()
+				return cerr
+			}
 
 			// Write buffered data through compressor
-			_, cerr = io.Copy(compressor, cf.writeBuffer)
-			if cerr != nil {
+			if cerr = cf.flushBuffer(compressor); cerr != nil {
 				compressor.Close()
 				cf.base.Close()
 				return cerr
 			}
 
 			// Close compressor
-			if cerr = compressor.Close(); cerr != nil {
+			if cerr = cf.closeCompressor(compressor); cerr != nil {
 				cf.base.Close()
 				return cerr
 			}
 
 			// Update stats
-			cf.cfs.incrementStat(&cf.cfs.stats.FilesCompressed)
-			cf.cfs.addBytes(&cf.cfs.stats.BytesWritten, cf.bytesWritten)
-			cf.cfs.addBytes(&cf.cfs.stats.BytesCompressed, cf.bytesWritten)
-			cf.cfs.stats.IncrementAlgorithmCount(finalAlgo)
+			cf.recordCompressed(finalAlgo, flushStart)
+			written = finalAlgo
+			cf.writeDecision(ReasonCompressed, finalAlgo, finalLevel)
 		} else if bufLen > 0 {
 			// File too small, write uncompressed
 			_, err = io.Copy(cf.base, cf.writeBuffer)
 			cf.cfs.incrementStat(&cf.cfs.stats.FilesSkipped)
+			cf.writeDecision(ReasonMinSize, "", 0)
+			return cf.closeUncompressed(err)
+		} else {
+			// Empty file - just close without writing anything
+			cf.writeDecision(ReasonEmpty, "", 0)
+		}
+	}
 
-			// If we have a compression extension but didn't compress,
-			// rename the file to remove the extension to avoid confusion on read
-			if cf.compressedName != cf.originalName && HasCompressionExtension(cf.compressedName) {
-				// Close the base file before renaming
-				if cerr := cf.base.Close(); cerr != nil && err == nil {
-					err = cerr
-				}
+	// Only compressed writes reach here with data; raw ones returned above
+	if cf.crlf {
+		if _, werr := cf.base.Write(crlfFooter); werr != nil {
+			cf.base.Close()
+			return werr
+		}
+	}
+	if cf.cfs.config.ReportUncompressedSize && written != "" && !cf.cfs.recordsSize(written) {
+		if _, werr := cf.base.Write(encodeSizeFooter(cf.bytesWritten)); werr != nil {
+			cf.base.Close()
+			return werr
+		}
+	}
+	if cf.hasher != nil && written != "" {
+		if _, werr := cf.base.Write(encodeHashFooter(cf.cfs.config.ContentHashAlgorithm, cf.hasher.Sum(nil))); werr != nil {
+			cf.base.Close()
+			return werr
+		}
+	}
+	if cf.cfs.config.EmbedFileMetadata && cf.shouldCompress && cf.writeBuffer != nil && (cf.compressor != nil || cf.bytesWritten > 0) {
+		if merr := cf.writeMetadata(); merr != nil {
+			cf.base.Close()
+			return merr
+		}
+	}
+	if terr := cf.trimPreallocated(); terr != nil {
+		cf.base.Close()
+		return terr
+	}
 
-				// Rename from compressed name to original name
-				if renameErr := cf.cfs.base.Rename(cf.compressedName, cf.originalName); renameErr != nil {
-					// If rename fails, it's not critical - we can still read the file
-					// It just might try to decompress it unnecessarily
-				}
+	if serr := cf.syncOnClose(); serr != nil && err == nil {
+		err = serr
+	}
 
-				return err // Already closed the base file
-			}
-		}
-		// If bufLen == 0, it's an empty file - just close without writing anything
+	if cf.readAtDecoder != nil {
+		cf.readAtDecoder.Close()
 	}
 
 	// Close decompressor if present
@@ -361,13 +1068,25 @@ func (cf *compressedFile) Close() error {
 		cf.cfs.incrementStat(&cf.cfs.stats.FilesDecompressed)
 		cf.cfs.addBytes(&cf.cfs.stats.BytesDecompressed, cf.bytesRead)
 		cf.cfs.stats.IncrementAlgorithmCount(cf.readAlgo)
+
+		if m := cf.cfs.config.Metrics; m != nil {
+			m.ObserveDecompress(cf.readAlgo, cf.bytesRead, cf.storedSize(), cf.codecTime)
+		}
 	}
 
 	// Close base file
+	cf.recordRatio()
 	if cerr := cf.base.Close(); cerr != nil && err == nil {
 		err = cerr
 	}
 
+	if cf.rewrite != "" {
+		return cf.commitRewrite(cf.renameTo, err)
+	}
+	if cf.renameTo != "" && err == nil {
+		err = cf.cfs.base.Rename(cf.compressedName, cf.renameTo)
+	}
+
 	return err
 }
 
@@ -380,7 +1099,11 @@ func (cf *compressedFile) Seek(offset int64, whence int) (int64, error) {
 		return 0, fs.ErrClosed
 	}
 
-	// Seeking is not supported in compressed mode
+	// Seeking is not supported in compressed mode, except for reads of
+	// files written with Config.Seekable
+	if s, ok := cf.decompressor.(*seekableReader); ok {
+		return s.Seek(offset, whence)
+	}
 	if cf.decompressor != nil || cf.compressor != nil {
 		return 0, ErrSeekNotSupported
 	}
@@ -388,11 +1111,40 @@ func (cf *compressedFile) Seek(offset int64, whence int) (int64, error) {
 	return cf.base.Seek(offset, whence)
 }
 
-// Stat returns file information
+// Stat returns file information. For compressed reads whose stream records
+// its decompressed size, Size reports that original size.
 func (cf *compressedFile) Stat() (fs.FileInfo, error) {
-	return cf.base.Stat()
+	info, err := cf.base.Stat()
+	if err != nil || cf.decompressor == nil {
+		return info, err
+	}
+	if size := cf.recordedSize(info.Size()); size >= 0 {
+		return &originalSizeInfo{FileInfo: info, size: size}, nil
+	}
+	return info, nil
+}
+
+// recordedSize returns the decompressed size the stream records about
+// itself, or -1. It is read on first use, since multi-member gzip files
+// must be walked member by member to count every member's size.
+func (cf *compressedFile) recordedSize(storedSize int64) int64 {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	if !cf.sizeResolved {
+		cf.originalSize = recordedOriginalSize(cf.base, cf.readAlgo, storedSize)
+		cf.sizeResolved = true
+	}
+	return cf.originalSize
 }
 
+// originalSizeInfo reports a stored file with its decompressed size
+type originalSizeInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *originalSizeInfo) Size() int64 { return i.size }
+
 // Sync syncs the file to disk
 func (cf *compressedFile) Sync() error {
 	cf.mu.Lock()
@@ -420,16 +1172,22 @@ func (cf *compressedFile) Algorithm() Algorithm {
 }
 
 // CompressionRatio returns the compression ratio (0-1, lower is better)
+// It is the stored size over the bytes written, known once a written file
+// is closed; before then, and for files opened only for reading, it is -1.
+// Files stored uncompressed report 1 and empty files 0.
 func (cf *compressedFile) CompressionRatio() float64 {
 	cf.mu.Lock()
 	defer cf.mu.Unlock()
+	return cf.ratio
+}
 
-	if cf.bytesWritten == 0 {
-		return 0
+// recordRatio caches the compression ratio of a written file. The caller
+// holds cf.mu and the base file must still be open.
+func (cf *compressedFile) recordRatio() {
+	if cf.flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) == 0 {
+		return
 	}
-
-	// This is approximate, actual compressed size is not known until close
-	return 0.5 // Placeholder
+	cf.ratio = GetCompressionRatio(cf.bytesWritten, cf.storedSize())
 }
 
 // OriginalSize returns the original uncompressed size
@@ -465,7 +1223,13 @@ func (cf *compressedFile) Name() string {
 	return cf.originalName
 }
 
-// ReadAt reads len(b) bytes from the File starting at byte offset off
+// ReadAt reads len(b) bytes from the File starting at byte offset off.
+// Files stored uncompressed are read directly through the base file's
+// io.ReaderAt, with the same semantics as os.File.ReadAt. Zstd files
+// written with Config.Seekable decode only the frames covering the range.
+// Other compressed streams have no random access and return
+// ErrSeekNotSupported; read them sequentially or buffer the decompressed
+// content instead.
 func (cf *compressedFile) ReadAt(b []byte, off int64) (n int, err error) {
 	cf.mu.Lock()
 	defer cf.mu.Unlock()
@@ -474,8 +1238,23 @@ func (cf *compressedFile) ReadAt(b []byte, off int64) (n int, err error) {
 		return 0, fs.ErrClosed
 	}
 
-	// ReadAt not supported for compressed files
-	if cf.decompressor != nil {
+	if s, ok := cf.decompressor.(*seekableReader); ok {
+		start := time.Now()
+		n, err = s.ReadAt(b, off)
+		cf.codecTime += time.Since(start)
+		return n, err
+	}
+
+	if cf.decompressor != nil && cf.cfs.config.AllowSequentialReadAt {
+		start := time.Now()
+		n, err = cf.sequentialReadAt(b, off)
+		cf.codecTime += time.Since(start)
+		return n, err
+	}
+
+	// ReadAt not supported for compressed files, including pending
+	// compressed writes whose data has not reached the base file yet
+	if cf.decompressor != nil || cf.compressor != nil || (cf.writeBuffer != nil && !cf.passthrough) {
 		return 0, ErrSeekNotSupported
 	}
 
@@ -509,7 +1288,8 @@ func (cf *compressedFile) WriteAt(b []byte, off int64) (n int, err error) {
 	return 0, os.ErrInvalid
 }
 
-// WriteString writes a string to the file
+// WriteString writes a string to the file. Like Write, it returns the number
+// of input bytes consumed, so multibyte text reports len(s)
 func (cf *compressedFile) WriteString(s string) (n int, err error) {
 	return cf.Write([]byte(s))
 }
@@ -523,8 +1303,23 @@ func (cf *compressedFile) Truncate(size int64) error {
 		return fs.ErrClosed
 	}
 
-	// Truncate not supported for compressed files
-	if cf.compressor != nil || cf.decompressor != nil {
+	// Compressed writes only allow truncation that leaves the logical
+	// content unchanged, or discarding data still held in the buffer
+	if cf.shouldCompress && cf.writeBuffer != nil {
+		switch {
+		case size == cf.bytesWritten:
+			return nil
+		case size == 0 && cf.compressor == nil && !cf.passthrough:
+			cf.writeBuffer.Reset()
+			cf.bytesWritten = 0
+			return nil
+		default:
+			return ErrSeekNotSupported
+		}
+	}
+
+	// Truncate not supported for compressed reads
+	if cf.decompressor != nil {
 		return ErrSeekNotSupported
 	}
 
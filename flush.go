@@ -0,0 +1,78 @@
+package compressfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// flushEncoder flushes a compressor, reporting ErrFlushNotSupported for
+// one without a Flush method
+func flushEncoder(w io.Writer) error {
+	f, ok := w.(interface{ Flush() error })
+	if !ok {
+		return ErrFlushNotSupported
+	}
+	return f.Flush()
+}
+
+// Flush writes everything written so far to the base file as data a
+// reader can decode, then syncs the base file, without closing. Gzip,
+// zlib, zstd and brotli emit a sync point that ends the data flushed so
+// far; snappy and lz4 end the current frame block, and seekable zstd,
+// parallel and content-defined chunked writes end the current frame or
+// chunk early. A reader opening the file between Flush and Close can
+// decode the flushed data, though it may then hit an unexpected end of
+// stream: trailers, indexes and footers are only written by Close.
+//
+// A write still buffered is switched to streaming first, compressed with
+// the codec its size selects even below MinSize. Xz, and files that must
+// be buffered whole until Close (TrySmallest, ZstdContentSize,
+// AsyncCompress, line ending normalization), fail with
+// ErrFlushNotSupported.
+func (cf *compressedFile) Flush() error {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	if cf.closed {
+		return fs.ErrClosed
+	}
+	if cf.flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) == 0 {
+		return nil
+	}
+
+	if cf.shouldCompress && cf.writeBuffer != nil && !cf.passthrough {
+		if cf.compressor == nil {
+			if cf.writeBuffer.Len() == 0 {
+				return nil
+			}
+			if _, ok := cf.streamThreshold(); !ok {
+				return ErrFlushNotSupported
+			}
+			skipped, err := cf.skipByContentType()
+			if err != nil {
+				return err
+			}
+			if !skipped {
+				algo, level := cf.selectWriteCodec(int64(cf.writeBuffer.Len()))
+				if err := cf.startStreaming(algo, level); err != nil {
+					return err
+				}
+			}
+		}
+		if cf.compressor != nil {
+			if err := flushEncoder(cf.compressor); err != nil {
+				return err
+			}
+			if err := cf.output.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := cf.base.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) && !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
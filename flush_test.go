@@ -0,0 +1,82 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// TestFlush tests that data written before Flush can be read from a second
+// handle while the file is still open, and that the closed file is whole
+func TestFlush(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZlib, AlgorithmZstd, AlgorithmBrotli, AlgorithmSnappy, AlgorithmLZ4} {
+		t.Run(string(algo), func(t *testing.T) {
+			cfs, err := New(NewMemFS(), &Config{Algorithm: algo, PreserveExtension: true, StripExtension: true})
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+			first := cdcTestData(100 << 10)
+			second := []byte("entries written after the flush\n")
+
+			f, err := cfs.Create("app.log")
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			if _, err := f.Write(first); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if err := f.(*compressedFile).Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+
+			partial, err := cfs.Open("app.log")
+			if err != nil {
+				t.Fatalf("Open of the flushed file failed: %v", err)
+			}
+			got := make([]byte, len(first))
+			if _, err := io.ReadFull(partial, got); err != nil {
+				t.Fatalf("Reading the flushed data failed: %v", err)
+			}
+			partial.Close()
+			if !bytes.Equal(got, first) {
+				t.Error("Second handle read the wrong flushed data")
+			}
+
+			if _, err := f.Write(second); err != nil {
+				t.Fatalf("Write after Flush failed: %v", err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+			if got := readTestFile(t, cfs, "app.log"); !bytes.Equal(got, append(first, second...)) {
+				t.Error("File closed after Flush did not round-trip")
+			}
+		})
+	}
+}
+
+// TestFlushNotSupported tests that Flush fails for xz and for files buffered
+// whole until Close
+func TestFlushNotSupported(t *testing.T) {
+	for name, config := range map[string]*Config{
+		"xz":          {Algorithm: AlgorithmXz},
+		"trysmallest": {Algorithm: AlgorithmGzip, TrySmallest: []Algorithm{AlgorithmGzip, AlgorithmZstd}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfs, err := New(NewMemFS(), config)
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+			f, err := cfs.Create("data.txt")
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			defer f.Close()
+			f.Write(cdcTestData(4 << 10))
+			if err := f.(*compressedFile).Flush(); !errors.Is(err, ErrFlushNotSupported) {
+				t.Errorf("Flush = %v, expected ErrFlushNotSupported", err)
+			}
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package compressfs
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
+	"strings"
 	"sync/atomic"
 
 	"github.com/absfs/absfs"
@@ -17,51 +22,339 @@ func (cfs *FS) Open(name string) (absfs.File, error) {
 
 // OpenFile opens a file with specified flags and permissions
 func (cfs *FS) OpenFile(name string, flag int, perm fs.FileMode) (absfs.File, error) {
+	return cfs.openFile(name, flag, func(actualName string, flag int) (absfs.File, error) {
+		return cfs.base.OpenFile(actualName, flag, perm)
+	}, flag&os.O_EXCL == 0, nil)
+}
+
+// OpenFileWithAlgorithm opens a file like OpenFile, but writes it with the
+// given algorithm and level instead of the configured ones, bypassing
+// AlgorithmRules, auto-tuning and TrySmallest. The configuration is not
+// changed, so concurrent opens are unaffected. Skip patterns and MinSize
+// still apply.
+func (cfs *FS) OpenFileWithAlgorithm(name string, flag int, perm fs.FileMode, algo Algorithm, level int) (absfs.File, error) {
+	if err := validateCodec(algo, level); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return cfs.openFile(name, flag, func(actualName string, flag int) (absfs.File, error) {
+		return cfs.base.OpenFile(actualName, flag, perm)
+	}, flag&os.O_EXCL == 0, &codec{algo: algo, level: level})
+}
+
+// OpenFileContext opens a file like OpenFile, bound to ctx: once ctx is
+// done, reads and writes fail with its error, and Close stops the
+// compression flush, returns the error and removes the partially written
+// stored file. Parallel compression starts no further chunks.
+func (cfs *FS) OpenFileContext(ctx context.Context, name string, flag int, perm fs.FileMode) (absfs.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := cfs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if cf, ok := f.(*compressedFile); ok {
+		cf.ctx = ctx
+	}
+	return f, nil
+}
+
+// codec is an algorithm and level pair forced on a single file
+type codec struct {
+	algo  Algorithm
+	level int
+}
+
+// openFile resolves the stored name for name and flag, opens it with open
+// and wraps the result with compression. open receives the flag the base
+// file is opened with, which differs from flag for appends that rewrite the
+// file. retry reports whether the open may be repeated under
+// Config.RetryPolicy. forced, if set, replaces the configured codec.
+func (cfs *FS) openFile(name string, flag int, open func(actualName string, flag int) (absfs.File, error), retry bool, forced *codec) (absfs.File, error) {
 	cfs.mu.RLock()
 	config := cfs.config
+	algo, level := config.Algorithm, config.Level
 	cfs.mu.RUnlock()
+	if forced != nil {
+		algo, level = forced.algo, forced.level
+	}
+
+	name = cleanPath(name)
+
+	// The base compresses itself: hand it the logical name unchanged
+	if cfs.layered {
+		return open(name, flag)
+	}
 
 	// Determine the actual filename to open
 	actualName := name
 	var detectedAlgo Algorithm
 	var isCreate = (flag & os.O_CREATE) != 0
 	var isWrite = (flag & (os.O_WRONLY | os.O_RDWR)) != 0
+	var plan *appendPlan
 
 	// For create/write operations, add compression extension if needed
 	if (isCreate || isWrite) && !cfs.shouldSkip(name) {
-		if !HasCompressionExtension(name) {
-			actualName = AddExtension(name, config.Algorithm, config.PreserveExtension)
-			detectedAlgo = config.Algorithm
-		}
-	} else if config.StripExtension {
-		// For read operations, try to find compressed version
-		for _, algo := range []Algorithm{config.Algorithm, AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy} {
-			ext := GetExtension(algo)
-			if ext == "" {
-				continue
+		if _, _, placed := cfs.stripPlacedExtension(name); !placed {
+			actualName = cfs.placeExtension(name, algo, config.PreserveExtension)
+			detectedAlgo = algo
+
+			if flag&os.O_APPEND != 0 {
+				var err error
+				if plan, err = cfs.prepareAppend(name, actualName, config); err != nil {
+					return nil, err
+				}
 			}
-			testName := name + ext
-			if _, err := cfs.base.Stat(testName); err == nil {
-				actualName = testName
-				detectedAlgo = algo
-				break
+		}
+	} else if config.StripExtension && !cfs.shouldSkip(name) {
+		// For read operations, try to find compressed version. Skipped
+		// names are always stored verbatim, so there is nothing to probe.
+		if stored, algo, ok := cfs.resolveStored(name, config); ok && algo != "" {
+			if migrated, ok := cfs.recompress(name, stored, algo, config); ok {
+				stored, algo = migrated, config.RecompressionTarget
 			}
+			actualName = stored
+			detectedAlgo = algo
 		}
 	}
 
+	// A skipped name is written verbatim, so a compressed copy left from
+	// before the skip pattern was added is stale
+	var stale []string
+	if (isCreate || isWrite) && config.StripExtension && cfs.shouldSkip(name) {
+		stale = cfs.compressedVariants(name, config)
+		if len(stale) > 0 && config.StrictSkip {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: ErrStaleCompressed}
+		}
+	}
+
+	// An append either adds a member to the stored file as it is, or
+	// rewrites it whole from its existing content under a temporary name,
+	// which replaces the stored file only once Close succeeds
+	baseFlag := flag
+	var rewriteTo string
+	if plan != nil && plan.member {
+		actualName, detectedAlgo = plan.stored, plan.algo
+	} else if plan != nil {
+		dir, file := path.Split(name)
+		rewriteTo = actualName
+		actualName = cfs.placeExtension(dir+appendPrefix+file, algo, config.PreserveExtension)
+		baseFlag = flag&^os.O_APPEND | os.O_CREATE | os.O_TRUNC
+	}
+
+	if err := cfs.acquireSlot(config); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
 	// Open the underlying file
-	baseFile, err := cfs.base.OpenFile(actualName, flag, perm)
+	baseFile, err := openBase(func() (absfs.File, error) { return open(actualName, baseFlag) }, retry, config.RetryPolicy)
 	if err != nil {
+		cfs.releaseSlot()
 		return nil, err
 	}
+	if config.ReadTimeout > 0 {
+		baseFile = newTimeoutFile(baseFile, config.ReadTimeout)
+	}
+	if rewriteTo != "" {
+		// Keep the mode of the file being rewritten, where the base allows
+		cfs.base.Chmod(actualName, plan.perm)
+	}
+	// Not every base positions O_APPEND handles at the end, and a member
+	// written anywhere else would overwrite the stored data
+	if plan != nil && plan.member {
+		if _, err := baseFile.Seek(0, io.SeekEnd); err != nil {
+			baseFile.Close()
+			cfs.releaseSlot()
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fmt.Errorf("%w: %v", ErrAppendNotSupported, err)}
+		}
+	}
+	footers := storedFooters{size: -1}
+	if !isCreate && !isWrite {
+		if config.TolerateTransientEOF {
+			baseFile = &eofRetryFile{File: baseFile}
+		}
+		baseFile, footers = cfs.withFooters(baseFile, actualName)
+	}
+
+	for _, variant := range stale {
+		if err := cfs.base.Remove(variant); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			baseFile.Close()
+			cfs.releaseSlot()
+			return nil, err
+		}
+	}
 
 	// Wrap with compression/decompression
-	return newCompressedFile(cfs, baseFile, name, actualName, flag, detectedAlgo)
+	cf, err := newCompressedFile(cfs, baseFile, name, actualName, flag, detectedAlgo, level)
+	if err != nil {
+		baseFile.Close()
+		cfs.releaseSlot()
+		return nil, err
+	}
+	cf.forced = forced != nil
+	if rewriteTo != "" {
+		cf.rewrite, cf.renameTo = plan.stored, rewriteTo
+	}
+	cf.meta = footers.meta
+	cf.normalize = (isCreate || isWrite) && cf.shouldCompress && cfs.normalizesLineEndings(name)
+	if footers.size >= 0 && cf.decompressor != nil {
+		cf.originalSize, cf.sizeResolved = footers.size, true
+	}
+	if footers.crlf && config.RestoreLineEndings && cf.decompressor != nil {
+		cf.decompressor = newCRLFReader(cf.decompressor)
+	}
+	if plan != nil {
+		if err := cf.startAppend(plan); err != nil {
+			cf.Close()
+			return nil, err
+		}
+	}
+	return cf, nil
+}
+
+// acquireSlot reserves one of Config.MaxOpenFiles, waiting for a file to
+// close when BlockOnMaxOpenFiles is set
+func (cfs *FS) acquireSlot(config *Config) error {
+	if cfs.openSlots == nil {
+		return nil
+	}
+	if config.BlockOnMaxOpenFiles {
+		cfs.openSlots <- struct{}{}
+		return nil
+	}
+	select {
+	case cfs.openSlots <- struct{}{}:
+		return nil
+	default:
+		return ErrTooManyOpenFiles
+	}
+}
+
+// releaseSlot frees a slot reserved by acquireSlot
+func (cfs *FS) releaseSlot() {
+	if cfs.openSlots != nil {
+		<-cfs.openSlots
+	}
+}
+
+// cleanPath normalizes a logical path before it is handed to the base
+// filesystem or used to build extension probe names. absfs paths always use
+// forward slashes, so "./data.txt", "dir/../data.txt" and "data.txt/" all
+// resolve to the same name, and absolute paths keep their leading slash.
+func cleanPath(name string) string {
+	if name == "" {
+		return name
+	}
+	return path.Clean(strings.ReplaceAll(name, "\\", "/"))
+}
+
+// resolveStored returns the base name a read of name resolves to and the
+// algorithm implied by its compression extension. Compressed variants are
+// probed first; the plain name is used when none exists. The returned bool
+// reports whether the resolved name exists on the base filesystem.
+func (cfs *FS) resolveStored(name string, config *Config) (string, Algorithm, bool) {
+	if config.StripExtension {
+		for _, ext := range cfs.probeExtensions(config) {
+			testName := cfs.variantName(name, ext)
+			if _, err := cfs.base.Stat(testName); err == nil {
+				return testName, reverseExtensionMap[ext], true
+			}
+		}
+	}
+
+	if _, err := cfs.base.Stat(name); err != nil {
+		return name, "", false
+	}
+	return name, "", true
+}
+
+// compressedVariants returns the stored compressed variants of name that
+// exist on the base filesystem
+func (cfs *FS) compressedVariants(name string, config *Config) []string {
+	var variants []string
+	for _, ext := range cfs.probeExtensions(config) {
+		testName := cfs.variantName(name, ext)
+		if _, err := cfs.base.Stat(testName); err == nil {
+			variants = append(variants, testName)
+		}
+	}
+	return variants
+}
+
+// ResolveStoredName returns the base path Open would use for the logical
+// name and whether that path exists
+func (cfs *FS) ResolveStoredName(name string) (string, bool) {
+	cfs.mu.RLock()
+	config := cfs.config
+	cfs.mu.RUnlock()
+
+	stored, _, ok := cfs.resolveStored(name, config)
+	return stored, ok
+}
+
+// probeOrder lists the algorithms whose extensions are probed when resolving
+// a logical name, in resolution order: the configured algorithm first, then
+// the remaining known algorithms
+var probeOrder = []Algorithm{AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy, AlgorithmXz, AlgorithmZlib, AlgorithmBzip2}
+
+// probeExtensions returns the compression extensions to try for a logical
+// name, in resolution order and capped by Config.MaxExtensionProbes
+func (cfs *FS) probeExtensions(config *Config) []string {
+	exts := make([]string, 0, len(probeOrder)+1)
+	seen := make(map[string]bool, len(probeOrder)+1)
+
+	configured, _ := cfs.Codec()
+	for _, algo := range append([]Algorithm{configured}, probeOrder...) {
+		ext := GetExtension(algo)
+		if ext == "" || seen[ext] {
+			continue
+		}
+		seen[ext] = true
+		exts = append(exts, ext)
+	}
+
+	if config.MaxExtensionProbes > 0 && len(exts) > config.MaxExtensionProbes {
+		exts = exts[:config.MaxExtensionProbes]
+	}
+	return exts
+}
+
+// variantName returns the stored name probed for a logical name under the
+// given codec extension
+func (cfs *FS) variantName(name, ext string) string {
+	if cfs.placement != nil {
+		return cfs.placement.place(name, ext)
+	}
+	return name + ext
+}
+
+// placeExtension returns the stored name a write of name with algo uses,
+// honoring Config.ExtensionPlacement when set
+func (cfs *FS) placeExtension(name string, algo Algorithm, preserveOriginal bool) string {
+	if cfs.placement != nil {
+		if ext := GetExtension(algo); ext != "" {
+			return cfs.placement.place(name, ext)
+		}
+		return name
+	}
+	return AddExtension(name, algo, preserveOriginal)
 }
 
-// Create creates a new file for writing
+// stripPlacedExtension is StripExtension for the configured placement
+func (cfs *FS) stripPlacedExtension(name string) (string, Algorithm, bool) {
+	if cfs.placement != nil {
+		return cfs.placement.strip(name)
+	}
+	return StripExtension(name)
+}
+
+// Create creates a new file for writing. The stored file is created with
+// the base filesystem's Create, so any base-specific creation semantics
+// such as default modes or exclusivity apply to it.
 func (cfs *FS) Create(name string) (absfs.File, error) {
-	return cfs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	return cfs.openFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, func(actualName string, _ int) (absfs.File, error) {
+		return cfs.base.Create(actualName)
+	}, true, nil)
 }
 
 // Mkdir creates a directory
@@ -75,22 +368,33 @@ func (cfs *FS) Remove(name string) error {
 	config := cfs.config
 	cfs.mu.RUnlock()
 
+	name = cleanPath(name)
+
 	// Try to remove with and without compression extension
 	err := cfs.base.Remove(name)
-	if err != nil && config.StripExtension {
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if config.StripExtension {
 		// Try with compression extension
-		for _, algo := range []Algorithm{config.Algorithm, AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy} {
-			ext := GetExtension(algo)
-			if ext == "" {
-				continue
-			}
-			testName := name + ext
-			if removeErr := cfs.base.Remove(testName); removeErr == nil {
+		for _, ext := range cfs.probeExtensions(config) {
+			testName := cfs.variantName(name, ext)
+			removeErr := cfs.base.Remove(testName)
+			if removeErr == nil {
 				return nil
 			}
+			if !errors.Is(removeErr, fs.ErrNotExist) {
+				return removeErr
+			}
 		}
 	}
-	return err
+
+	// No variant exists: report the logical name
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
 }
 
 // Stat returns file information
@@ -99,6 +403,8 @@ func (cfs *FS) Stat(name string) (fs.FileInfo, error) {
 	config := cfs.config
 	cfs.mu.RUnlock()
 
+	name = cleanPath(name)
+
 	// Try exact name first
 	info, err := cfs.base.Stat(name)
 	if err == nil {
@@ -107,13 +413,12 @@ func (cfs *FS) Stat(name string) (fs.FileInfo, error) {
 
 	// If StripExtension is enabled, try with compression extensions
 	if config.StripExtension {
-		for _, algo := range []Algorithm{config.Algorithm, AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy} {
-			ext := GetExtension(algo)
-			if ext == "" {
-				continue
-			}
-			testName := name + ext
+		for _, ext := range cfs.probeExtensions(config) {
+			testName := cfs.variantName(name, ext)
 			if info, err := cfs.base.Stat(testName); err == nil {
+				if config.ReportUncompressedSize {
+					return &originalSizeInfo{FileInfo: info, size: cfs.logicalSize(testName, info.Size())}, nil
+				}
 				return info, nil
 			}
 		}
@@ -141,7 +446,7 @@ func (cfs *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 
 		for _, entry := range entries {
 			entryName := entry.Name()
-			stripped, _, hasCompExt := StripExtension(entryName)
+			stripped, _, hasCompExt := cfs.stripPlacedExtension(entryName)
 
 			// If it has compression extension, use stripped name
 			if hasCompExt {
@@ -149,6 +454,8 @@ func (cfs *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 					result = append(result, &renamedDirEntry{
 						DirEntry: entry,
 						name:     stripped,
+						cfs:      cfs,
+						stored:   path.Join(name, entryName),
 					})
 					seen[stripped] = true
 				}
@@ -165,6 +472,47 @@ func (cfs *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 	return entries, nil
 }
 
+// ReadDirRecursive lists the subtree below root, each directory as ReadDir
+// lists it, so compression extensions are stripped and variants of one
+// logical file are reported once at every level. Entries are named by their
+// slash-separated path relative to root, and a directory comes before its
+// contents.
+func (cfs *FS) ReadDirRecursive(root string) ([]fs.DirEntry, error) {
+	var result []fs.DirEntry
+	var walk func(dir, rel string) error
+	walk = func(dir, rel string) error {
+		entries, err := cfs.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := path.Join(rel, entry.Name())
+			result = append(result, &nestedDirEntry{DirEntry: entry, name: name})
+			if entry.IsDir() {
+				if err := walk(path.Join(dir, entry.Name()), name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(cleanPath(root), ""); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// nestedDirEntry names an entry listed by ReadDirRecursive by its path
+// relative to the listed root
+type nestedDirEntry struct {
+	fs.DirEntry
+	name string
+}
+
+func (e *nestedDirEntry) Name() string {
+	return e.name
+}
+
 // ReadFile reads the named file and returns its contents.
 // This reads and decompresses the file if it's compressed.
 func (cfs *FS) ReadFile(name string) ([]byte, error) {
@@ -176,30 +524,80 @@ func (cfs *FS) ReadFile(name string) ([]byte, error) {
 
 	// Get size hint for efficient allocation
 	var size int64
-	if info, err := f.Stat(); err == nil {
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
 		size = info.Size()
 	}
 
-	// Pre-allocate buffer if we have size info
-	if size > 0 {
-		buf := make([]byte, size)
-		n, err := io.ReadFull(f, buf)
-		if err == io.ErrUnexpectedEOF {
-			// File was shorter than expected, return what we got
-			return buf[:n], nil
-		}
-		if err != nil {
-			return nil, err
-		}
-		return buf, nil
+	// The size is only a hint: it may be the stored compressed size, so
+	// keep reading past it until EOF
+	buf := bytes.NewBuffer(make([]byte, 0, size+bytes.MinRead))
+	if _, err := buf.ReadFrom(f); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
+
+// WriteCompressedBytes compresses data with the given algorithm and level and
+// writes it to the base filesystem under the algorithm's extension, without
+// going through the streaming file wrapper. It returns the stored name.
+func (cfs *FS) WriteCompressedBytes(name string, data []byte, algo Algorithm, level int) (storedName string, err error) {
+	cfs.mu.RLock()
+	config := cfs.config
+	cfs.mu.RUnlock()
 
-	// Unknown size, read all
-	return io.ReadAll(f)
+	name = cleanPath(name)
+
+	var buf bytes.Buffer
+	compressor, err := cfs.newSizedCompressor(algo, level, &buf, int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	if _, err := compressor.Write(data); err != nil {
+		return "", err
+	}
+	if err := compressor.Close(); err != nil {
+		return "", err
+	}
+
+	storedName = cfs.placeExtension(name, algo, config.PreserveExtension)
+	f, err := cfs.base.OpenFile(storedName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	cfs.incrementStat(&cfs.stats.FilesCompressed)
+	cfs.addBytes(&cfs.stats.BytesWritten, int64(len(data)))
+	cfs.addBytes(&cfs.stats.BytesCompressed, int64(len(data)))
+	cfs.stats.IncrementAlgorithmCount(algo)
+	cfs.stats.recordAlgorithmBytes(algo, int64(len(data)), int64(buf.Len()))
+
+	return storedName, nil
 }
 
 // Sub returns a fs.FS corresponding to the subtree rooted at dir.
+// It is the IOFS view of SubFS(dir).
 func (cfs *FS) Sub(dir string) (fs.FS, error) {
+	sub, err := cfs.SubFS(dir)
+	if err != nil {
+		return nil, err
+	}
+	return sub.IOFS(), nil
+}
+
+// SubFS returns a writable compressed filesystem for the subtree rooted at
+// dir. It starts from a copy of the current configuration, so later changes
+// such as SetLevel on either filesystem do not affect the other. Its
+// statistics are its own unless Config.ShareSubStats is set. It appends to
+// the same DecisionLog and counts its open files against the same
+// MaxOpenFiles as cfs.
+func (cfs *FS) SubFS(dir string) (*FS, error) {
 	// Verify the directory exists
 	info, err := cfs.Stat(dir)
 	if err != nil {
@@ -209,31 +607,60 @@ func (cfs *FS) Sub(dir string) (fs.FS, error) {
 		return nil, &os.PathError{Op: "sub", Path: dir, Err: errors.New("not a directory")}
 	}
 
-	// Get base sub filesystem
-	baseSub, err := cfs.base.Sub(dir)
-	if err != nil {
-		return nil, err
-	}
+	cfs.mu.RLock()
+	config := cloneConfig(cfs.config)
+	cfs.mu.RUnlock()
+
+	// Level already holds the resolved default; keep it as is
+	config.LevelSet = true
 
-	// Wrap it with compression
-	compressSubFS, err := New(baseSub, cfs.config)
+	sub, err := New(&subFiler{base: cfs.base, dir: cleanPath(dir)}, config)
 	if err != nil {
 		return nil, err
 	}
-
-	return absfs.FilerToFS(compressSubFS, dir)
+	if config.ShareSubStats {
+		sub.stats = cfs.stats
+	}
+	sub.decisionMu, sub.openSlots = cfs.decisionMu, cfs.openSlots
+	return sub, nil
 }
 
 // renamedDirEntry wraps a DirEntry with a different name
 type renamedDirEntry struct {
 	fs.DirEntry
-	name string
+	name   string
+	cfs    *FS
+	stored string // Path of the entry on the base filesystem
 }
 
 func (e *renamedDirEntry) Name() string {
 	return e.name
 }
 
+// Info reports the entry under its logical name and decompressed size. The
+// size is read from the stream's own size fields where the format records
+// one, and otherwise by decompressing the file, so it is only computed here.
+func (e *renamedDirEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &logicalFileInfo{FileInfo: info, name: e.name, size: info.Size()}, nil
+	}
+	return &logicalFileInfo{FileInfo: info, name: e.name, size: e.cfs.logicalSize(e.stored, info.Size())}, nil
+}
+
+// logicalFileInfo reports a stored file under its logical name and size
+type logicalFileInfo struct {
+	fs.FileInfo
+	name string
+	size int64
+}
+
+func (i *logicalFileInfo) Name() string { return i.name }
+func (i *logicalFileInfo) Size() int64  { return i.size }
+
 // incrementStat atomically increments a stat counter
 func (cfs *FS) incrementStat(counter *int64) {
 	atomic.AddInt64(counter, 1)
@@ -0,0 +1,682 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// countingFiler wraps a Filer and counts Stat calls
+type countingFiler struct {
+	absfs.Filer
+	stats int64
+}
+
+func (c *countingFiler) Stat(name string) (fs.FileInfo, error) {
+	atomic.AddInt64(&c.stats, 1)
+	return c.Filer.Stat(name)
+}
+
+// TestMaxExtensionProbes tests that extension probing is capped
+func TestMaxExtensionProbes(t *testing.T) {
+	base := &countingFiler{Filer: NewMemFS()}
+	cfs, err := New(base, &Config{
+		Algorithm:          AlgorithmGzip,
+		StripExtension:     true,
+		MaxExtensionProbes: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	if _, err := cfs.Stat("missing.txt"); err == nil {
+		t.Fatal("Stat of missing file should fail")
+	}
+
+	// One exact-name attempt plus two extension probes
+	if got := atomic.LoadInt64(&base.stats); got != 3 {
+		t.Errorf("Expected 3 Stat calls, got %d", got)
+	}
+
+	// Without a cap every known extension is probed once
+	uncapped := &countingFiler{Filer: NewMemFS()}
+	cfs, _ = New(uncapped, &Config{Algorithm: AlgorithmGzip, StripExtension: true})
+	cfs.Stat("missing.txt")
+	if got := atomic.LoadInt64(&uncapped.stats); got != 1+int64(len(probeOrder)) {
+		t.Errorf("Expected %d Stat calls, got %d", 1+len(probeOrder), got)
+	}
+}
+
+// TestResolveStoredName tests resolution of logical names to stored names
+func TestResolveStoredName(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		SkipPatterns:      []string{`\.jpg$`},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	writeTestFile(t, cfs, "data.txt", []byte("some data to compress"))
+	writeTestFile(t, cfs, "photo.jpg", []byte("not compressed"))
+
+	tests := []struct {
+		name       string
+		wantStored string
+		wantExists bool
+	}{
+		{"data.txt", "data.txt.gz", true},
+		{"photo.jpg", "photo.jpg", true},
+		{"missing.txt", "missing.txt", false},
+	}
+
+	for _, tt := range tests {
+		stored, exists := cfs.ResolveStoredName(tt.name)
+		if stored != tt.wantStored || exists != tt.wantExists {
+			t.Errorf("ResolveStoredName(%q) = (%q, %v), want (%q, %v)",
+				tt.name, stored, exists, tt.wantStored, tt.wantExists)
+		}
+	}
+}
+
+// TestPathNormalization tests that equivalent paths resolve to the same file
+func TestPathNormalization(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := []byte("normalized path content")
+	writeTestFile(t, cfs, "./data.txt", data)
+
+	if _, err := base.Stat("data.txt.gz"); err != nil {
+		t.Fatalf("Expected data.txt.gz on base: %v", err)
+	}
+
+	for _, name := range []string{"data.txt", "./data.txt", "/data.txt", "sub/../data.txt"} {
+		if got := readTestFile(t, cfs, name); !bytes.Equal(got, data) {
+			t.Errorf("Open(%q) returned %q", name, got)
+		}
+		if _, err := cfs.Stat(name); err != nil {
+			t.Errorf("Stat(%q) failed: %v", name, err)
+		}
+	}
+
+	if err := cfs.Remove("./data.txt"); err != nil {
+		t.Errorf("Remove failed: %v", err)
+	}
+	if _, err := cfs.Stat("/data.txt"); err == nil {
+		t.Error("File should be removed")
+	}
+}
+
+// TestWriteCompressedBytes tests writing a pre-buffered payload directly
+func TestWriteCompressedBytes(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmZstd,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("buffered payload "), 200)
+	stored, err := cfs.WriteCompressedBytes("payload.txt", data, AlgorithmGzip, 9)
+	if err != nil {
+		t.Fatalf("WriteCompressedBytes failed: %v", err)
+	}
+	if stored != "payload.txt.gz" {
+		t.Errorf("Expected stored name payload.txt.gz, got %s", stored)
+	}
+
+	if got := readTestFile(t, cfs, "payload.txt"); !bytes.Equal(got, data) {
+		t.Error("Payload did not read back through Open")
+	}
+
+	if _, err := cfs.WriteCompressedBytes("bad.txt", data, "unknown", 0); err == nil {
+		t.Error("Expected error for unsupported algorithm")
+	}
+}
+
+// TestExtensionPlacement tests stored-name placement of the codec extension
+func TestExtensionPlacement(t *testing.T) {
+	tests := []struct {
+		placement string
+		stored    string
+	}{
+		{"", "docs/data.txt.gz"},
+		{"{name}.{algo}{ext}", "docs/data.gz.txt"},
+	}
+
+	for _, tt := range tests {
+		base := NewMemFS()
+		base.Mkdir("docs", 0755)
+		cfs, err := New(base, &Config{
+			Algorithm:          AlgorithmGzip,
+			Level:              6,
+			PreserveExtension:  true,
+			StripExtension:     true,
+			ExtensionPlacement: tt.placement,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+
+		data := bytes.Repeat([]byte("placement round trip "), 100)
+		writeTestFile(t, cfs, "docs/data.txt", data)
+
+		if _, err := base.Stat(tt.stored); err != nil {
+			t.Errorf("placement %q: expected stored name %s: %v", tt.placement, tt.stored, err)
+		}
+		if got := readTestFile(t, cfs, "docs/data.txt"); !bytes.Equal(got, data) {
+			t.Errorf("placement %q: content did not round-trip", tt.placement)
+		}
+
+		entries, err := cfs.ReadDir("docs")
+		if err != nil {
+			t.Fatalf("ReadDir failed: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "data.txt" {
+			t.Errorf("placement %q: expected ReadDir to list data.txt, got %v", tt.placement, entries)
+		}
+
+		if err := cfs.Remove("docs/data.txt"); err != nil {
+			t.Errorf("placement %q: Remove failed: %v", tt.placement, err)
+		}
+	}
+
+	if _, err := New(NewMemFS(), &Config{ExtensionPlacement: "{name}{ext}"}); err == nil {
+		t.Error("Expected error for template without {algo}")
+	}
+}
+
+// TestSkipAuthoritative tests that skip-matched names are stored and read verbatim
+func TestSkipAuthoritative(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		AutoDetect:        true,
+		PreserveExtension: true,
+		StripExtension:    true,
+		SkipPatterns:      []string{`\.jpg`},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	// Content that looks like gzip must still come back byte for byte
+	payload, err := CompressBytes([]byte("opaque image payload"), AlgorithmGzip, 6)
+	if err != nil {
+		t.Fatalf("CompressBytes failed: %v", err)
+	}
+
+	// A stale compressed variant must not shadow the verbatim file
+	writeBaseFile(t, base, "photo.jpg.zst", []byte("stale"))
+
+	for _, name := range []string{"photo.jpg", "photo.jpg.gz"} {
+		writeTestFile(t, cfs, name, payload)
+
+		stored := readBaseFile(t, base, name)
+		if !bytes.Equal(stored, payload) {
+			t.Errorf("%s: expected verbatim storage under the logical name", name)
+		}
+		if got := readTestFile(t, cfs, name); !bytes.Equal(got, payload) {
+			t.Errorf("%s: expected verbatim read without decompression", name)
+		}
+	}
+}
+
+// TestRemoveMissingLogicalName tests the error for removing a name with no variant
+func TestRemoveMissingLogicalName(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	err = cfs.Remove("missing.txt")
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		t.Fatalf("Expected *fs.PathError, got %T: %v", err, err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got %v", err)
+	}
+	if pathErr.Path != "missing.txt" {
+		t.Errorf("Expected error to reference missing.txt, got %q", pathErr.Path)
+	}
+}
+
+// createModeFiler is a base whose Create applies its own file mode
+type createModeFiler struct {
+	absfs.Filer
+	created []string
+}
+
+func (c *createModeFiler) Create(name string) (absfs.File, error) {
+	c.created = append(c.created, name)
+	return c.Filer.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+// TestCreateUsesBaseCreate tests that Create goes through the base's Create
+func TestCreateUsesBaseCreate(t *testing.T) {
+	base := &createModeFiler{Filer: NewMemFS()}
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("created by base "), 100)
+	writeTestFile(t, cfs, "secret.txt", data)
+
+	if len(base.created) != 1 || base.created[0] != "secret.txt.gz" {
+		t.Fatalf("Expected base Create for secret.txt.gz, got %v", base.created)
+	}
+	info, err := base.Stat("secret.txt.gz")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600 from base Create, got %v", info.Mode().Perm())
+	}
+	if got := readTestFile(t, cfs, "secret.txt"); !bytes.Equal(got, data) {
+		t.Error("Content did not round-trip")
+	}
+}
+
+// TestStrictSkip tests writing a skipped name that has a stale compressed copy
+func TestStrictSkip(t *testing.T) {
+	for _, strict := range []bool{true, false} {
+		base := NewMemFS()
+		cfs, err := New(base, &Config{
+			Algorithm:         AlgorithmGzip,
+			PreserveExtension: true,
+			StripExtension:    true,
+			SkipPatterns:      []string{`\.jpg$`},
+			StrictSkip:        strict,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+
+		writeBaseFile(t, base, "photo.jpg.gz", []byte("compressed before jpg was skipped"))
+
+		f, err := cfs.Create("photo.jpg")
+		if strict {
+			if !errors.Is(err, ErrStaleCompressed) {
+				t.Errorf("StrictSkip: expected ErrStaleCompressed, got %v", err)
+			}
+			if _, err := base.Stat("photo.jpg"); err == nil {
+				t.Error("StrictSkip: the verbatim file should not be created")
+			}
+			if _, err := base.Stat("photo.jpg.gz"); err != nil {
+				t.Errorf("StrictSkip: the compressed copy should be kept: %v", err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		f.Write([]byte("new photo"))
+		f.Close()
+		if _, err := base.Stat("photo.jpg.gz"); err == nil {
+			t.Error("Expected the stale photo.jpg.gz to be removed")
+		}
+		if got := readBaseFile(t, base, "photo.jpg"); string(got) != "new photo" {
+			t.Errorf("Expected verbatim photo.jpg, got %q", got)
+		}
+	}
+}
+
+// TestMaxOpenFiles tests that opens beyond the limit fail until a file closes
+func TestMaxOpenFiles(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		MaxOpenFiles:      2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	first, err := cfs.Create("one.txt")
+	if err != nil {
+		t.Fatalf("Create one.txt failed: %v", err)
+	}
+	second, err := cfs.Create("two.txt")
+	if err != nil {
+		t.Fatalf("Create two.txt failed: %v", err)
+	}
+	defer second.Close()
+
+	if _, err := cfs.Create("three.txt"); !errors.Is(err, ErrTooManyOpenFiles) {
+		t.Fatalf("Expected ErrTooManyOpenFiles at the limit, got %v", err)
+	}
+
+	first.Write([]byte("closing frees a slot"))
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	first.Close() // A second Close must not free another slot
+
+	third, err := cfs.Create("three.txt")
+	if err != nil {
+		t.Fatalf("Create after Close failed: %v", err)
+	}
+	defer third.Close()
+
+	if _, err := cfs.Open("one.txt"); !errors.Is(err, ErrTooManyOpenFiles) {
+		t.Errorf("Expected ErrTooManyOpenFiles after double Close, got %v", err)
+	}
+}
+
+// TestSubFSMaxOpenFiles tests that a SubFS counts its open files against
+// its parent's MaxOpenFiles
+func TestSubFSMaxOpenFiles(t *testing.T) {
+	base := &subDirFiler{Filer: NewMemFS(), dirs: map[string]bool{"/sub": true}}
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		MaxOpenFiles:      1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	sub, err := cfs.SubFS("sub")
+	if err != nil {
+		t.Fatalf("SubFS failed: %v", err)
+	}
+
+	f, err := cfs.Create("one.txt")
+	if err != nil {
+		t.Fatalf("Create one.txt failed: %v", err)
+	}
+	if _, err := sub.Create("two.txt"); !errors.Is(err, ErrTooManyOpenFiles) {
+		t.Errorf("Expected ErrTooManyOpenFiles in the sub at the parent's limit, got %v", err)
+	}
+	f.Close()
+
+	g, err := sub.Create("two.txt")
+	if err != nil {
+		t.Fatalf("Create in the sub after Close failed: %v", err)
+	}
+	defer g.Close()
+	if _, err := cfs.Create("three.txt"); !errors.Is(err, ErrTooManyOpenFiles) {
+		t.Errorf("Expected ErrTooManyOpenFiles in the parent at the sub's open file, got %v", err)
+	}
+}
+
+// subDirFiler wraps a Filer and reports a directory for each name in dirs
+type subDirFiler struct {
+	absfs.Filer
+	dirs map[string]bool
+}
+
+func (d *subDirFiler) Stat(name string) (fs.FileInfo, error) {
+	if d.dirs[name] {
+		return dirInfo(name), nil
+	}
+	return d.Filer.Stat(name)
+}
+
+// ReadDir adds the directories in dirs to the listing of their parent
+func (d *subDirFiler) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := d.Filer.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	for dir := range d.dirs {
+		if path.Dir(dir) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(dirInfo(path.Base(dir))))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// dirInfo is the fs.FileInfo of a directory reported by subDirFiler
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() interface{}   { return nil }
+
+// TestSubFS tests that a sub filesystem writes below its directory, keeps its
+// own copy of the configuration and shares stats only with ShareSubStats
+func TestSubFS(t *testing.T) {
+	for _, share := range []bool{false, true} {
+		base := &subDirFiler{Filer: NewMemFS(), dirs: map[string]bool{"/sub": true}}
+		cfs, err := New(base, &Config{
+			Algorithm:         AlgorithmGzip,
+			Level:             5,
+			PreserveExtension: true,
+			StripExtension:    true,
+			ShareSubStats:     share,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+
+		sub, err := cfs.SubFS("sub")
+		if err != nil {
+			t.Fatalf("SubFS failed: %v", err)
+		}
+		cfs.SetLevel(9)
+		if _, level := sub.Codec(); level != 5 {
+			t.Errorf("Sub level = %d after parent SetLevel, expected 5", level)
+		}
+
+		data := bytes.Repeat([]byte("written through the sub filesystem "), 100)
+		writeTestFile(t, sub, "x.txt", data)
+		if _, err := base.Stat("/sub/x.txt.gz"); err != nil {
+			t.Errorf("Expected sub/x.txt.gz in the base: %v", err)
+		}
+		if got := readTestFile(t, cfs, "sub/x.txt"); !bytes.Equal(got, data) {
+			t.Error("Parent read different content than the sub wrote")
+		}
+
+		want := int64(0)
+		if share {
+			want = 1
+		}
+		if got := cfs.GetStats().FilesCompressed; got != want {
+			t.Errorf("ShareSubStats=%v: parent FilesCompressed = %d, expected %d", share, got, want)
+		}
+		if got := sub.GetStats().FilesCompressed; got != 1 {
+			t.Errorf("Sub FilesCompressed = %d, expected 1", got)
+		}
+	}
+
+	cfs, err := New(NewMemFS(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	if _, err := cfs.SubFS("missing"); err == nil {
+		t.Error("Expected SubFS of a missing directory to fail")
+	}
+}
+
+// TestOpenFileWithAlgorithm tests that concurrent opens with forced codecs
+// each store their file with the requested algorithm, leaving the
+// configuration alone
+func TestOpenFileWithAlgorithm(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		AlgorithmRules:    []AlgorithmRule{{Pattern: `\.txt$`, Algorithm: AlgorithmLZ4}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("forced codec for a single file "), 200)
+	files := map[string]Algorithm{"a.txt": AlgorithmZstd, "b.txt": AlgorithmBrotli}
+	errs := make(chan error, len(files))
+	for name, algo := range files {
+		go func(name string, algo Algorithm) {
+			f, err := cfs.OpenFileWithAlgorithm(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644, algo, 3)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := f.Write(data); err != nil {
+				f.Close()
+				errs <- err
+				return
+			}
+			errs <- f.Close()
+		}(name, algo)
+	}
+	for range files {
+		if err := <-errs; err != nil {
+			t.Fatalf("Forced write failed: %v", err)
+		}
+	}
+
+	for name, algo := range files {
+		stored, err := DecompressBytes(readBaseFile(t, base, name+GetExtension(algo)), algo)
+		if err != nil || !bytes.Equal(stored, data) {
+			t.Errorf("Expected %s stored as %s: %v", name, algo, err)
+		}
+		if got := readTestFile(t, cfs, name); !bytes.Equal(got, data) {
+			t.Errorf("%s did not round-trip", name)
+		}
+	}
+	if algo, level := cfs.Codec(); algo != AlgorithmGzip || level != 6 {
+		t.Errorf("Codec() = %s/%d after forced opens, expected gzip/6", algo, level)
+	}
+
+	if _, err := cfs.OpenFileWithAlgorithm("c.txt", os.O_WRONLY|os.O_CREATE, 0644, AlgorithmGzip, 42); !errors.Is(err, ErrInvalidLevel) {
+		t.Errorf("Invalid level error = %v, expected ErrInvalidLevel", err)
+	}
+}
+
+// TestReadDirRecursive tests that a nested listing strips compression
+// extensions and de-duplicates variants in every directory
+func TestReadDirRecursive(t *testing.T) {
+	base := &subDirFiler{Filer: NewMemFS(), dirs: map[string]bool{"/logs": true, "/logs/old": true}}
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := []byte(strings.Repeat("nested listing ", 100))
+	writeTestFile(t, cfs, "readme.txt", data)
+	writeTestFile(t, cfs, "logs/app.log", data)
+	writeTestFile(t, cfs, "logs/old/app.log", data)
+	zstdBytes, err := CompressBytes(data, AlgorithmZstd, 3)
+	if err != nil {
+		t.Fatalf("CompressBytes failed: %v", err)
+	}
+	writeBaseFile(t, base, "/logs/old/app.log.zst", zstdBytes)
+	writeBaseFile(t, base, "/logs/old/sys.log.sz", zstdBytes)
+
+	entries, err := cfs.ReadDirRecursive("/")
+	if err != nil {
+		t.Fatalf("ReadDirRecursive failed: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if _, _, ok := cfs.stripPlacedExtension(entry.Name()); ok {
+			t.Errorf("Entry %q kept its compression extension", entry.Name())
+		}
+		names = append(names, entry.Name())
+	}
+	want := []string{"logs", "logs/app.log", "logs/old", "logs/old/app.log", "logs/old/sys.log", "readme.txt"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadDirRecursive = %q, expected %q", names, want)
+	}
+
+	info, err := entries[1].Info()
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	if info.Name() != "app.log" || info.Size() != int64(len(data)) {
+		t.Errorf("Info = %q of %d bytes, expected app.log of %d", info.Name(), info.Size(), len(data))
+	}
+}
+
+// TestLayeredFS tests that a compressfs over another one passes files
+// through to the inner layer instead of compressing them twice
+func TestLayeredFS(t *testing.T) {
+	base := NewMemFS()
+	inner, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create inner compressfs: %v", err)
+	}
+	outer, err := New(inner, &Config{Algorithm: AlgorithmZstd, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create outer compressfs: %v", err)
+	}
+
+	data := []byte(strings.Repeat("layered compressfs ", 200))
+	writeTestFile(t, outer, "a.txt", data)
+
+	entries, err := base.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "a.txt.gz" {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Fatalf("Base holds %q, expected only a.txt.gz", names)
+	}
+	if algo, ok := IsCompressed(readBaseFile(t, base, "a.txt.gz")); !ok || algo != AlgorithmGzip {
+		t.Errorf("Stored file detected as %q, expected a single gzip layer", algo)
+	}
+
+	if got := readTestFile(t, outer, "a.txt"); !bytes.Equal(got, data) {
+		t.Error("Outer layer did not read back the data")
+	}
+	if got := readTestFile(t, inner, "a.txt"); !bytes.Equal(got, data) {
+		t.Error("Inner layer did not read back the data")
+	}
+	if err := outer.Rename("a.txt", "b.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if got := readTestFile(t, outer, "b.txt"); !bytes.Equal(got, data) {
+		t.Error("Renamed file did not read back through the outer layer")
+	}
+	if _, err := base.Stat("b.txt.gz"); err != nil {
+		t.Errorf("Expected b.txt.gz after rename: %v", err)
+	}
+}
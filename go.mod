@@ -5,7 +5,9 @@ go 1.23
 require (
 	github.com/absfs/absfs v0.9.1
 	github.com/andybalholm/brotli v1.2.0
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/golang/snappy v1.0.0
 	github.com/klauspost/compress v1.18.1
 	github.com/pierrec/lz4/v4 v4.1.22
+	github.com/ulikunitz/xz v0.5.17
 )
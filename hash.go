@@ -0,0 +1,134 @@
+package compressfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/absfs/absfs"
+	"github.com/cespare/xxhash/v2"
+)
+
+// HashAlgorithm selects the hash stored under Config.StoreContentHash
+type HashAlgorithm string
+
+const (
+	HashSHA256 HashAlgorithm = "sha256"
+	HashXXHash HashAlgorithm = "xxhash" // xxHash64, for speed over security
+)
+
+// hashIDs are the bytes recording each hash algorithm in a hash footer
+var hashIDs = map[HashAlgorithm]byte{
+	HashSHA256: 1,
+	HashXXHash: 2,
+}
+
+// Hash footer appended to compressed files under Config.StoreContentHash:
+// the hashIDs byte of its algorithm, then the hash of the uncompressed
+// bytes, sealed with hashFooterMagic
+const hashFooterMagic = "CFSH"
+
+// contentHash is the hash recorded in a hash footer
+type contentHash struct {
+	algo HashAlgorithm
+	sum  []byte
+}
+
+// newContentHasher returns a hasher for algo, sha256 when it is unset
+func newContentHasher(algo HashAlgorithm) hash.Hash {
+	if algo == HashXXHash {
+		return xxhash.New()
+	}
+	return sha256.New()
+}
+
+// encodeHashFooter returns the hash footer recording sum, computed with algo
+func encodeHashFooter(algo HashAlgorithm, sum []byte) []byte {
+	if algo == "" {
+		algo = HashSHA256
+	}
+	return sealFooter(append([]byte{hashIDs[algo]}, sum...), hashFooterMagic)
+}
+
+// withHashFooter hides the hash footer of a stored file opened for reading
+// and returns the recorded hash, or returns f unchanged when f has no footer
+func (cfs *FS) withHashFooter(f absfs.File) (absfs.File, *contentHash) {
+	trimmed, body, ok := trimFooter(f, hashFooterMagic)
+	if !ok || len(body) < 2 {
+		return f, nil
+	}
+
+	var algo HashAlgorithm
+	for candidate, id := range hashIDs {
+		if id == body[0] {
+			algo = candidate
+		}
+	}
+	if algo == "" {
+		return f, nil
+	}
+	return trimmed, &contentHash{algo: algo, sum: body[1:]}
+}
+
+// ContentHash returns the hash of the uncompressed content of the named
+// file recorded under Config.StoreContentHash, read from the footer of the
+// stored file without decompressing it. It fails with ErrNoContentHash for
+// files written without the option or stored uncompressed.
+func (cfs *FS) ContentHash(name string) ([]byte, error) {
+	stored, err := cfs.storedContentHash(name)
+	if err != nil {
+		return nil, err
+	}
+	return stored.sum, nil
+}
+
+// VerifyContentHash decompresses the named file, hashes its content and
+// reports whether it matches the hash recorded under Config.StoreContentHash.
+// A mismatch means the stored file was altered after it was written. The
+// hash covers the bytes as written, so a file whose line endings were
+// normalized only matches when read back with RestoreLineEndings.
+func (cfs *FS) VerifyContentHash(name string) (bool, error) {
+	stored, err := cfs.storedContentHash(name)
+	if err != nil {
+		return false, err
+	}
+
+	f, err := cfs.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	hasher := newContentHasher(stored.algo)
+	if _, err := io.Copy(hasher, f); err != nil {
+		return false, err
+	}
+	return bytes.Equal(hasher.Sum(nil), stored.sum), nil
+}
+
+// storedContentHash reads the hash footer of the stored file for name
+func (cfs *FS) storedContentHash(name string) (*contentHash, error) {
+	cfs.mu.RLock()
+	config := cfs.config
+	cfs.mu.RUnlock()
+
+	name = cleanPath(name)
+	stored, _, ok := cfs.resolveStored(name, config)
+	if !ok {
+		return nil, &os.PathError{Op: "hash", Path: name, Err: os.ErrNotExist}
+	}
+	f, err := cfs.base.OpenFile(stored, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	_, footers := cfs.withFooters(f, stored)
+	if footers.hash == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoContentHash, name)
+	}
+	return footers.hash, nil
+}
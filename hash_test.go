@@ -0,0 +1,90 @@
+package compressfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// TestContentHash tests that the stored hash matches the content for each
+// hash algorithm and is hidden from reads
+func TestContentHash(t *testing.T) {
+	data := bytes.Repeat([]byte("hashed before compression\n"), 500)
+	sha := sha256.Sum256(data)
+	xx := xxhash.New()
+	xx.Write(data)
+	want := map[HashAlgorithm][]byte{
+		HashSHA256: sha[:],
+		HashXXHash: xx.Sum(nil),
+	}
+
+	for algo, sum := range want {
+		t.Run(string(algo), func(t *testing.T) {
+			cfs, err := New(NewMemFS(), &Config{
+				Algorithm:            AlgorithmZstd,
+				PreserveExtension:    true,
+				StripExtension:       true,
+				StoreContentHash:     true,
+				ContentHashAlgorithm: algo,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+			writeTestFile(t, cfs, "data.txt", data)
+
+			got, err := cfs.ContentHash("data.txt")
+			if err != nil {
+				t.Fatalf("ContentHash failed: %v", err)
+			}
+			if !bytes.Equal(got, sum) {
+				t.Errorf("ContentHash = %x, expected %x", got, sum)
+			}
+			if ok, err := cfs.VerifyContentHash("data.txt"); err != nil || !ok {
+				t.Errorf("VerifyContentHash = %v, %v, expected a match", ok, err)
+			}
+			if got := readTestFile(t, cfs, "data.txt"); !bytes.Equal(got, data) {
+				t.Error("Hash footer was not hidden from reads")
+			}
+		})
+	}
+}
+
+// TestContentHashTampered tests that VerifyContentHash detects stored
+// content replaced after the hash was written, and a corrupted hash
+func TestContentHashTampered(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true, StoreContentHash: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	data := bytes.Repeat([]byte("original content\n"), 200)
+	writeTestFile(t, cfs, "data.txt", data)
+	stored := readBaseFile(t, base, "data.txt.gz")
+	footer := stored[len(stored)-(1+sha256.Size+footerSealSize):]
+
+	// Valid compressed data of other content under the original footer
+	forged, err := CompressBytes(bytes.Repeat([]byte("tampered content\n"), 200), AlgorithmGzip, 6)
+	if err != nil {
+		t.Fatalf("CompressBytes failed: %v", err)
+	}
+	writeBaseFile(t, base, "data.txt.gz", append(forged, footer...))
+	if ok, err := cfs.VerifyContentHash("data.txt"); err != nil || ok {
+		t.Errorf("VerifyContentHash of replaced content = %v, %v, expected a mismatch", ok, err)
+	}
+
+	// The original data with a byte of its hash flipped fails the seal
+	corrupted := append([]byte{}, stored...)
+	corrupted[len(corrupted)-footerSealSize-1] ^= 0xff
+	writeBaseFile(t, base, "data.txt.gz", corrupted)
+	if ok, err := cfs.VerifyContentHash("data.txt"); !errors.Is(err, ErrNoContentHash) || ok {
+		t.Errorf("VerifyContentHash with a corrupted hash = %v, %v, expected ErrNoContentHash", ok, err)
+	}
+
+	writeBaseFile(t, base, "plain.txt.gz", forged)
+	if _, err := cfs.ContentHash("plain.txt"); !errors.Is(err, ErrNoContentHash) {
+		t.Errorf("ContentHash without a footer = %v, expected ErrNoContentHash", err)
+	}
+}
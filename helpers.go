@@ -1,7 +1,12 @@
 package compressfs
 
 import (
+	"bufio"
+	"fmt"
 	"io"
+
+	"github.com/absfs/absfs"
+	"github.com/klauspost/compress/dict"
 )
 
 // Preset configurations for common use cases
@@ -144,11 +149,19 @@ func CompressBytes(data []byte, algo Algorithm, level int) ([]byte, error) {
 	return *result, nil
 }
 
-// DecompressBytes decompresses a byte slice using the specified algorithm
+// DecompressBytes decompresses a byte slice using the specified algorithm.
+// AlgorithmAuto detects it from the magic bytes as NewAutoDecompressReader
+// does.
 func DecompressBytes(data []byte, algo Algorithm) ([]byte, error) {
 	reader := &bytesReader{data: data}
 
-	decompressor, err := createDecompressor(algo, reader, 0)
+	var decompressor io.ReadCloser
+	var err error
+	if algo == AlgorithmAuto {
+		decompressor, err = NewAutoDecompressReader(reader)
+	} else {
+		decompressor, err = createDecompressor(algo, reader, 0)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -157,6 +170,98 @@ func DecompressBytes(data []byte, algo Algorithm) ([]byte, error) {
 	return io.ReadAll(decompressor)
 }
 
+// NewAutoDecompressReader returns a reader that decompresses r with the
+// algorithm its magic bytes identify. Detection peeks at the head of the
+// stream, so r need not be seekable. Data without recognized magic bytes,
+// which includes brotli, is returned as is.
+func NewAutoDecompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	algo, err := peekAlgorithm(br)
+	if err != nil {
+		return nil, err
+	}
+	if algo == "" {
+		return io.NopCloser(br), nil
+	}
+	return createDecompressor(algo, br, 0)
+}
+
+// WrapWriter attaches a compressor to an existing file handle, bypassing the
+// filesystem's naming and buffering. Close flushes the codec but leaves the
+// file open.
+func WrapWriter(f absfs.File, algo Algorithm, level int) (io.WriteCloser, error) {
+	return createCompressor(algo, f, level)
+}
+
+// WrapReader attaches a decompressor to an existing file handle, reading
+// from its current offset. Close releases the codec but leaves the file open.
+func WrapReader(f absfs.File, algo Algorithm) (io.ReadCloser, error) {
+	return createDecompressor(algo, f, 0)
+}
+
+// CompressReader returns a reader yielding r compressed with the specified
+// algorithm and level. Compression runs in a goroutine feeding an io.Pipe as
+// the result is read, so neither stream is held in memory; closing the
+// reader early stops it. Errors reading r or compressing surface from Read.
+func CompressReader(r io.Reader, algo Algorithm, level int) (io.ReadCloser, error) {
+	// Some encoders write their header as they are created, before anything
+	// reads the pipe, so that is held back until compression starts
+	out := &pendingWriter{}
+	compressor, err := createCompressor(algo, out, level)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := out.start(pw)
+		if err == nil {
+			_, err = io.Copy(compressor, r)
+		}
+		if closeErr := compressor.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// DecompressReader returns a reader yielding r decompressed with the
+// specified algorithm, or the one its magic bytes identify for
+// AlgorithmAuto. Decompressors already pull from r as they are read, so
+// no pipe is needed to keep the streams out of memory.
+func DecompressReader(r io.Reader, algo Algorithm) (io.ReadCloser, error) {
+	if algo == AlgorithmAuto {
+		return NewAutoDecompressReader(r)
+	}
+	return createDecompressor(algo, r, 0)
+}
+
+// pendingWriter buffers writes until start hands it the destination
+type pendingWriter struct {
+	buf []byte
+	w   io.Writer
+}
+
+func (p *pendingWriter) Write(b []byte) (int, error) {
+	if p.w == nil {
+		p.buf = append(p.buf, b...)
+		return len(b), nil
+	}
+	return p.w.Write(b)
+}
+
+// start writes the buffered bytes to w and sends later writes straight to it
+func (p *pendingWriter) start(w io.Writer) error {
+	p.w = w
+	if len(p.buf) == 0 {
+		return nil
+	}
+	_, err := w.Write(p.buf)
+	p.buf = nil
+	return err
+}
+
 // DetectCompressionAlgorithm detects the compression algorithm from data
 func DetectCompressionAlgorithm(data []byte) (Algorithm, bool) {
 	return IsCompressed(data)
@@ -209,15 +314,42 @@ func GetCompressionPercentage(originalSize, compressedSize int64) float64 {
 
 // ===== ADVANCED FEATURES (Phase 5) =====
 
+// Minimum dictionary size and sample count accepted by TrainZstdDictionary
+const (
+	minZstdDictSize    = 1024
+	minZstdDictSamples = 7
+)
+
 // TrainZstdDictionary trains a zstd dictionary from sample data
 // samples should contain representative data similar to what will be compressed
 // dictSize is the target dictionary size in bytes (recommended: 100KB - 1MB)
 // Returns the trained dictionary or an error
-func TrainZstdDictionary(samples [][]byte, dictSize int) ([]byte, error) {
-	// Note: This requires the zstd library's training functionality
-	// For now, we'll return a placeholder - full implementation would use:
-	// github.com/klauspost/compress/zstd BuildDict functionality
-	return nil, nil
+// Training needs a corpus: fewer than 7 samples, samples holding fewer than
+// dictSize bytes between them or a dictSize below 1KB is rejected with
+// ErrInvalidConfig, as is a corpus the builder cannot train on. The result
+// can be used as ZstdDictionary.
+func TrainZstdDictionary(samples [][]byte, dictSize int) (d []byte, err error) {
+	if dictSize < minZstdDictSize {
+		return nil, fmt.Errorf("%w: dictionary size %d is below %d bytes", ErrInvalidConfig, dictSize, minZstdDictSize)
+	}
+	if len(samples) < minZstdDictSamples {
+		return nil, fmt.Errorf("%w: %d samples given, training needs at least %d", ErrInvalidConfig, len(samples), minZstdDictSamples)
+	}
+	total := 0
+	for _, s := range samples {
+		total += len(s)
+	}
+	if total < dictSize {
+		return nil, fmt.Errorf("%w: samples hold %d bytes, training a %d byte dictionary needs at least as many", ErrInvalidConfig, total, dictSize)
+	}
+
+	// The builder indexes out of range on corpora without usable matches
+	defer func() {
+		if r := recover(); r != nil {
+			d, err = nil, fmt.Errorf("%w: cannot train a dictionary on these samples: %v", ErrInvalidConfig, r)
+		}
+	}()
+	return dict.BuildZstdDict(samples, dict.Options{MaxDictSize: dictSize, HashBytes: 6})
 }
 
 // SmartConfig returns a configuration with intelligent defaults based on use case
@@ -2,8 +2,13 @@ package compressfs
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io"
+	"os"
 	"testing"
+	"testing/iotest"
 )
 
 func TestPresetConfigs(t *testing.T) {
@@ -261,3 +266,200 @@ func TestLargeDataBytesCompression(t *testing.T) {
 	t.Logf("Large data compression: %.2f%% reduction",
 		GetCompressionPercentage(int64(len(largeData)), int64(len(compressed))))
 }
+
+// TestWrapWriterReader tests attaching codecs to an existing file handle
+func TestWrapWriterReader(t *testing.T) {
+	data := bytes.Repeat([]byte("wrapped temp file "), 500)
+
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy} {
+		f, err := os.CreateTemp(t.TempDir(), "wrapped-*")
+		if err != nil {
+			t.Fatalf("CreateTemp failed: %v", err)
+		}
+
+		w, err := WrapWriter(f, algo, 0)
+		if err != nil {
+			t.Fatalf("%s: WrapWriter failed: %v", algo, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("%s: Write failed: %v", algo, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: codec Close failed: %v", algo, err)
+		}
+
+		// The file stays open after the codec is closed
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("%s: Seek after codec Close failed: %v", algo, err)
+		}
+
+		r, err := WrapReader(f, algo)
+		if err != nil {
+			t.Fatalf("%s: WrapReader failed: %v", algo, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: Read failed: %v", algo, err)
+		}
+		r.Close()
+
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: content did not round-trip", algo)
+		}
+		if err := f.Close(); err != nil {
+			t.Errorf("%s: file Close failed: %v", algo, err)
+		}
+	}
+}
+
+// TestTrainZstdDictionary tests that a trained dictionary shrinks small
+// similar files and that training rejects a too-small corpus
+func TestTrainZstdDictionary(t *testing.T) {
+	record := func(i int) []byte {
+		return []byte(fmt.Sprintf(`{"id":%d,"type":"order","status":"shipped","customer":{"name":"customer-%d","tier":"gold"},"items":[{"sku":"SKU-%04d","quantity":%d}]}`, i, i%17, i%97, i%5+1))
+	}
+	var samples [][]byte
+	for i := 0; i < 200; i++ {
+		samples = append(samples, record(i))
+	}
+
+	if _, err := TrainZstdDictionary(samples[:3], 4096); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Training on 3 samples = %v, expected ErrInvalidConfig", err)
+	}
+	if _, err := TrainZstdDictionary(samples, 100); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Training a 100 byte dictionary = %v, expected ErrInvalidConfig", err)
+	}
+	if _, err := TrainZstdDictionary(make([][]byte, 8), 4096); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Training on empty samples = %v, expected ErrInvalidConfig", err)
+	}
+	tiny := make([][]byte, 7)
+	for i := range tiny {
+		tiny[i] = []byte{byte('a' + i)}
+	}
+	if _, err := TrainZstdDictionary(tiny, 4096); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Training on one-byte samples = %v, expected ErrInvalidConfig", err)
+	}
+	repeated := make([][]byte, 7)
+	for i := range repeated {
+		repeated[i] = bytes.Repeat([]byte{byte('a' + i)}, 1024)
+	}
+	if _, err := TrainZstdDictionary(repeated, 4096); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Training on samples the builder rejects = %v, expected ErrInvalidConfig", err)
+	}
+
+	dict, err := TrainZstdDictionary(samples, 4096)
+	if err != nil {
+		t.Fatalf("TrainZstdDictionary failed: %v", err)
+	}
+	if len(dict) == 0 {
+		t.Fatal("Expected a non-empty dictionary")
+	}
+
+	storedSize := func(dict []byte) int {
+		base := NewMemFS()
+		cfs, err := New(base, &Config{
+			Algorithm:         AlgorithmZstd,
+			PreserveExtension: true,
+			StripExtension:    true,
+			ZstdDictionary:    dict,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+		data := record(1234)
+		writeTestFile(t, cfs, "order.json", data)
+		if got := readTestFile(t, cfs, "order.json"); !bytes.Equal(got, data) {
+			t.Fatal("Dictionary-compressed file did not round-trip")
+		}
+		return len(readBaseFile(t, base, "order.json.zst"))
+	}
+	with, without := storedSize(dict), storedSize(nil)
+	if with >= without {
+		t.Errorf("Stored %d bytes with the dictionary, %d without", with, without)
+	}
+}
+
+// TestNewAutoDecompressReader tests detection by peeking on a reader that
+// cannot seek, and the shared AlgorithmAuto path of DecompressBytes
+func TestNewAutoDecompressReader(t *testing.T) {
+	data := bytes.Repeat([]byte("detected without seeking back "), 100)
+
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4} {
+		compressed, err := CompressBytes(data, algo, 0)
+		if err != nil {
+			t.Fatalf("CompressBytes(%s) failed: %v", algo, err)
+		}
+
+		// OneByteReader hides bytes.Reader's Seek and ReadAt
+		r, err := NewAutoDecompressReader(iotest.OneByteReader(bytes.NewReader(compressed)))
+		if err != nil {
+			t.Fatalf("%s: NewAutoDecompressReader failed: %v", algo, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: auto-detected read returned %d bytes, err %v", algo, len(got), err)
+		}
+
+		if got, err := DecompressBytes(compressed, AlgorithmAuto); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: DecompressBytes(auto) returned %d bytes, err %v", algo, len(got), err)
+		}
+	}
+
+	r, err := NewAutoDecompressReader(iotest.OneByteReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("NewAutoDecompressReader failed: %v", err)
+	}
+	if got, _ := io.ReadAll(r); !bytes.Equal(got, data) {
+		t.Error("Uncompressed data was not passed through")
+	}
+}
+
+// TestCompressReader tests that a 10MB stream piped through CompressReader
+// and DecompressReader comes back unchanged for every writable algorithm
+func TestCompressReader(t *testing.T) {
+	data := cdcTestData(10 << 20)
+	want := sha256.Sum256(data)
+
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy, AlgorithmXz, AlgorithmZlib} {
+		t.Run(string(algo), func(t *testing.T) {
+			compressed, err := CompressReader(bytes.NewReader(data), algo, 1)
+			if err != nil {
+				t.Fatalf("CompressReader failed: %v", err)
+			}
+			defer compressed.Close()
+			decompressed, err := DecompressReader(compressed, algo)
+			if err != nil {
+				t.Fatalf("DecompressReader failed: %v", err)
+			}
+			defer decompressed.Close()
+
+			h := sha256.New()
+			n, err := io.Copy(h, decompressed)
+			if err != nil {
+				t.Fatalf("io.Copy failed: %v", err)
+			}
+			if n != int64(len(data)) || !bytes.Equal(h.Sum(nil), want[:]) {
+				t.Errorf("Round trip returned %d bytes that differ from the original %d", n, len(data))
+			}
+		})
+	}
+
+	if _, err := CompressReader(bytes.NewReader(data), Algorithm("lzma"), 0); err == nil {
+		t.Error("Expected an error for an unknown algorithm")
+	}
+
+	// Closing early stops the compressing goroutine instead of leaving it
+	// blocked on the pipe
+	r, err := CompressReader(bytes.NewReader(data), AlgorithmGzip, 1)
+	if err != nil {
+		t.Fatalf("CompressReader failed: %v", err)
+	}
+	if _, err := io.ReadFull(r, make([]byte, 100)); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	r.Close()
+	if _, err := r.Read(make([]byte, 100)); err == nil {
+		t.Error("Read after Close succeeded")
+	}
+}
@@ -0,0 +1,305 @@
+package compressfs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/absfs/absfs"
+	"github.com/klauspost/compress/zstd"
+)
+
+// FileInfo describes how an opened file is stored on the base filesystem
+type FileInfo struct {
+	// Algorithm is the compression algorithm used, or "" if stored uncompressed
+	Algorithm Algorithm
+
+	// CompressedSize is the size of the stored file on the base filesystem
+	CompressedSize int64
+
+	// OriginalSize is the uncompressed size, or -1 if the format does not record it
+	OriginalSize int64
+}
+
+// OpenWithInfo opens a file for reading and returns storage information
+// gathered while opening it, avoiding a separate Stat round trip
+func (cfs *FS) OpenWithInfo(name string) (absfs.File, FileInfo, error) {
+	f, err := cfs.Open(name)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+
+	cf, ok := f.(*compressedFile)
+	if !ok {
+		return f, FileInfo{CompressedSize: -1, OriginalSize: -1}, nil
+	}
+
+	info, err := cf.base.Stat()
+	if err != nil {
+		f.Close()
+		return nil, FileInfo{}, err
+	}
+
+	fi := FileInfo{CompressedSize: info.Size(), OriginalSize: info.Size()}
+	if cf.decompressor != nil {
+		fi.Algorithm = cf.readAlgo
+		fi.OriginalSize = cf.recordedSize(info.Size())
+	}
+	return f, fi, nil
+}
+
+// originalSizer is implemented by stored files that know their
+// uncompressed size without reading the stream, such as archive members
+type originalSizer interface {
+	originalSize() int64
+}
+
+// recordedOriginalSize reads the uncompressed size a stored stream records
+// about itself, or returns -1 when the format does not carry one
+func recordedOriginalSize(r io.ReaderAt, algo Algorithm, compressedSize int64) int64 {
+	if s, ok := r.(originalSizer); ok {
+		return s.originalSize()
+	}
+	switch algo {
+	case AlgorithmGzip:
+		if gzipSingleMember(r, compressedSize) {
+			return gzipTrailerSize(r, compressedSize)
+		}
+		return gzipMembersSize(r, compressedSize)
+	case AlgorithmZstd:
+		// A seek table records every frame, not only the first
+		if frames, ok := readSeekTable(r, compressedSize); ok {
+			return seekableSize(frames)
+		}
+
+		// Frame_Content_Size from the first frame header, when present
+		head := make([]byte, zstd.HeaderMaxSize)
+		n, err := r.ReadAt(head, 0)
+		if err != nil && err != io.EOF {
+			return -1
+		}
+		var header zstd.Header
+		if header.Decode(head[:n]) != nil || !header.HasFCS {
+			return -1
+		}
+		return int64(header.FrameContentSize)
+	}
+	return -1
+}
+
+// gzipTrailerBound is the largest gzip stream whose ISIZE trailer is
+// certain not to have wrapped at 4GB: deflate expands its input by at most
+// 1032 times.
+const gzipTrailerBound = (1 << 32) / 1032
+
+// gzipSingleMember reports whether the final ISIZE trailer alone gives the
+// size of a gzip stream: it is small enough that ISIZE cannot have wrapped,
+// and no second member header follows the first. The stored bytes are
+// scanned for a member header rather than inflated; a match inside the
+// deflate data only costs a walk of the members.
+func gzipSingleMember(r io.ReaderAt, compressedSize int64) bool {
+	if compressedSize > gzipTrailerBound {
+		return false
+	}
+
+	// ID1, ID2, CM deflate and FLG with its reserved bits clear
+	const headerLen = 4
+	buf := make([]byte, 32*1024)
+	for off := int64(1); off < compressedSize; off += int64(len(buf) - headerLen + 1) {
+		n, err := r.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return false
+		}
+		for i := 0; i+headerLen <= n; i++ {
+			if buf[i] == 0x1f && buf[i+1] == 0x8b && buf[i+2] == 8 && buf[i+3]&0xe0 == 0 {
+				return false
+			}
+		}
+		if n < len(buf) {
+			break
+		}
+	}
+	return true
+}
+
+// gzipTrailerSize returns the ISIZE field ending a single-member gzip
+// stream, its input size modulo 2^32, without inflating anything; see
+// gzipSingleMember for when it can be trusted
+func gzipTrailerSize(r io.ReaderAt, compressedSize int64) int64 {
+	// A member is at least a 10-byte header and an 8-byte trailer
+	if compressedSize < 18 {
+		return -1
+	}
+	var trailer [4]byte
+	if _, err := r.ReadAt(trailer[:], compressedSize-4); err != nil {
+		return -1
+	}
+	return int64(binary.LittleEndian.Uint32(trailer[:]))
+}
+
+// gzipMembersSize returns the decompressed size of a gzip stream of
+// concatenated members. Each member is inflated to find where it ends and
+// its decoded bytes are counted, since ISIZE wraps at 4GB; nothing is
+// buffered.
+func gzipMembersSize(r io.ReaderAt, compressedSize int64) int64 {
+	if compressedSize < 18 {
+		return -1
+	}
+
+	br := bufio.NewReader(io.NewSectionReader(r, 0, compressedSize))
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return -1
+	}
+	defer zr.Close()
+
+	var total int64
+	for {
+		zr.Multistream(false)
+		n, err := io.Copy(io.Discard, zr)
+		if err != nil {
+			return -1
+		}
+		total += n
+
+		if err := zr.Reset(br); err == io.EOF {
+			return total
+		} else if err != nil {
+			return -1
+		}
+	}
+}
+
+// CompressedFileInfo describes a stored file reported by WalkStats
+type CompressedFileInfo struct {
+	FileInfo
+
+	// StoredPath is the file's path on the base filesystem
+	StoredPath string
+}
+
+// WalkStats walks the base filesystem under root and calls fn for each file
+// with its logical path and storage information. Only the file head and any
+// size fields are read, so OriginalSize is -1 for formats that do not record
+// it. Gzip files that hold several members, or are too large for their
+// trailer to be trusted, are inflated without buffering to count their size.
+func (cfs *FS) WalkStats(root string, fn func(path string, info CompressedFileInfo) error) error {
+	return cfs.walkBase(cleanPath(root), func(name string) error {
+		info, err := cfs.storedFileInfo(name)
+		if err != nil {
+			return err
+		}
+
+		logical := name
+		if stripped, _, ok := cfs.stripPlacedExtension(name); ok {
+			logical = stripped
+		}
+		return fn(logical, info)
+	})
+}
+
+// storedFileInfo inspects a stored file's head and trailer on the base
+// filesystem without decompressing it
+func (cfs *FS) storedFileInfo(name string) (CompressedFileInfo, error) {
+	base, err := cfs.base.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return CompressedFileInfo{}, err
+	}
+	defer base.Close()
+	f, footers := cfs.withFooters(base, name)
+
+	stat, err := f.Stat()
+	if err != nil {
+		return CompressedFileInfo{}, err
+	}
+	size := stat.Size()
+
+	head := make([]byte, 10)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return CompressedFileInfo{}, err
+	}
+
+	algo, compressed := cfs.headAlgorithm(name, head[:n])
+
+	info := CompressedFileInfo{
+		FileInfo:   FileInfo{CompressedSize: size, OriginalSize: size},
+		StoredPath: name,
+	}
+	if compressed {
+		info.Algorithm = algo
+		if info.OriginalSize = footers.size; info.OriginalSize < 0 {
+			info.OriginalSize = recordedOriginalSize(f, algo, size)
+		}
+	}
+	return info, nil
+}
+
+// FileRatio returns the stored size of the named file over its
+// decompressed size, as GetCompressionRatio computes it. The decompressed
+// size is taken from the stream when the format records it, such as the
+// gzip ISIZE trailer; the file is only decompressed when it is not.
+func (cfs *FS) FileRatio(name string) (float64, error) {
+	cfs.mu.RLock()
+	config := cfs.config
+	cfs.mu.RUnlock()
+
+	name = cleanPath(name)
+	stored, _, ok := cfs.resolveStored(name, config)
+	if !ok {
+		return 0, &fs.PathError{Op: "ratio", Path: name, Err: fs.ErrNotExist}
+	}
+	info, err := cfs.storedFileInfo(stored)
+	if err != nil {
+		return 0, err
+	}
+
+	original := info.OriginalSize
+	if original < 0 {
+		original = cfs.logicalSize(stored, info.CompressedSize)
+	}
+	return GetCompressionRatio(original, info.CompressedSize), nil
+}
+
+// logicalSize returns the decompressed size of a stored file, decompressing
+// it when the format does not record its size. storedSize is returned when
+// the file cannot be inspected.
+func (cfs *FS) logicalSize(stored string, storedSize int64) int64 {
+	info, err := cfs.storedFileInfo(stored)
+	if err != nil {
+		return storedSize
+	}
+	if info.OriginalSize >= 0 {
+		return info.OriginalSize
+	}
+
+	base, err := cfs.base.OpenFile(stored, os.O_RDONLY, 0)
+	if err != nil {
+		return storedSize
+	}
+	defer base.Close()
+	f, _ := cfs.withFooters(base, stored)
+
+	var dict []byte
+	if info.Algorithm == AlgorithmZstd {
+		head := make([]byte, zstd.HeaderMaxSize)
+		n, _ := f.ReadAt(head, 0)
+		if dict, err = cfs.selectZstdDictionary(head[:n]); err != nil {
+			return storedSize
+		}
+	}
+	decoder, err := cfs.newDecompressor(info.Algorithm, f, dict)
+	if err != nil {
+		return storedSize
+	}
+	defer decoder.Close()
+
+	n, err := io.Copy(io.Discard, decoder)
+	if err != nil {
+		return storedSize
+	}
+	return n
+}
@@ -0,0 +1,346 @@
+package compressfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+)
+
+// TestOpenWithInfo tests that storage info is returned alongside the stream
+func TestOpenWithInfo(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("known original size "), 500)
+	writeTestFile(t, cfs, "sized.txt", data)
+
+	f, info, err := cfs.OpenWithInfo("sized.txt")
+	if err != nil {
+		t.Fatalf("OpenWithInfo failed: %v", err)
+	}
+	defer f.Close()
+
+	stored, err := base.Stat("sized.txt.gz")
+	if err != nil {
+		t.Fatalf("Expected sized.txt.gz: %v", err)
+	}
+
+	if info.Algorithm != AlgorithmGzip {
+		t.Errorf("Expected algorithm gzip, got %q", info.Algorithm)
+	}
+	if info.CompressedSize != stored.Size() {
+		t.Errorf("Expected compressed size %d, got %d", stored.Size(), info.CompressedSize)
+	}
+	if info.OriginalSize != int64(len(data)) {
+		t.Errorf("Expected original size %d, got %d", len(data), info.OriginalSize)
+	}
+
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("Stream did not round-trip after OpenWithInfo")
+	}
+
+	// Formats without a recorded size report -1
+	cfs.SetAlgorithm(AlgorithmLZ4)
+	writeTestFile(t, cfs, "unsized.txt", data)
+	f, info, err = cfs.OpenWithInfo("unsized.txt")
+	if err != nil {
+		t.Fatalf("OpenWithInfo failed: %v", err)
+	}
+	f.Close()
+	if info.Algorithm != AlgorithmLZ4 || info.OriginalSize != -1 {
+		t.Errorf("Expected lz4 with unknown size, got %q and %d", info.Algorithm, info.OriginalSize)
+	}
+}
+
+// TestWalkStats tests per-file storage info reported while walking
+func TestWalkStats(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+		SkipPatterns:      []string{`\.jpg$`},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	text := bytes.Repeat([]byte("audit report line "), 300)
+	photo := []byte("raw image bytes")
+	writeTestFile(t, cfs, "report.txt", text)
+	writeTestFile(t, cfs, "photo.jpg", photo)
+	cfs.SetAlgorithm(AlgorithmLZ4)
+	writeTestFile(t, cfs, "log.txt", text)
+
+	size := func(name string) int64 {
+		info, err := base.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat %s failed: %v", name, err)
+		}
+		return info.Size()
+	}
+
+	want := map[string]CompressedFileInfo{
+		"/report.txt": {FileInfo{AlgorithmGzip, size("report.txt.gz"), int64(len(text))}, "/report.txt.gz"},
+		"/photo.jpg":  {FileInfo{"", int64(len(photo)), int64(len(photo))}, "/photo.jpg"},
+		"/log.txt":    {FileInfo{AlgorithmLZ4, size("log.txt.lz4"), -1}, "/log.txt.lz4"},
+	}
+
+	seen := 0
+	err = cfs.WalkStats("/", func(path string, info CompressedFileInfo) error {
+		seen++
+		if info != want[path] {
+			t.Errorf("%s: got %+v, want %+v", path, info, want[path])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkStats failed: %v", err)
+	}
+	if seen != len(want) {
+		t.Errorf("Expected %d files, saw %d", len(want), seen)
+	}
+}
+
+// TestGzipMultiMemberSize tests that Stat sums the sizes of every gzip member
+func TestGzipMultiMemberSize(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	// Members of differing sizes, as written by successive flushes
+	var stored, want []byte
+	for i, size := range []int{1, 4096, 70000, 13} {
+		part := bytes.Repeat([]byte{byte('a' + i)}, size)
+		member, err := CompressBytes(part, AlgorithmGzip, 6)
+		if err != nil {
+			t.Fatalf("CompressBytes failed: %v", err)
+		}
+		stored = append(stored, member...)
+		want = append(want, part...)
+	}
+	f, err := base.OpenFile("members.txt.gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("Create base file failed: %v", err)
+	}
+	f.Write(stored)
+	f.Close()
+
+	rf, err := cfs.Open("members.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer rf.Close()
+
+	info, err := rf.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() != int64(len(want)) {
+		t.Errorf("Expected Stat size %d, got %d", len(want), info.Size())
+	}
+
+	got, err := io.ReadAll(rf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("Members were not stitched into one stream")
+	}
+}
+
+// TestStatConcurrent tests that an open file can be statted from several
+// goroutines while it is read, as under go test -race
+func TestStatConcurrent(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	data := bytes.Repeat([]byte("statted while read "), 1000)
+	writeTestFile(t, cfs, "s.txt", data)
+
+	f, err := cfs.Open("s.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if info, err := f.Stat(); err != nil || info.Size() != int64(len(data)) {
+				t.Errorf("Stat = %v, %v, expected size %d", info, err, len(data))
+			}
+		}()
+	}
+	if _, err := io.ReadAll(f); err != nil {
+		t.Errorf("Read failed: %v", err)
+	}
+	wg.Wait()
+}
+
+// TestGzipTrailerSize tests that the size of a single-member gzip file is
+// read from its ISIZE trailer without inflating it, unless the file is too
+// large for the trailer to be trusted
+func TestGzipTrailerSize(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, cfs, "small.txt", cdcTestData(1<<20))
+
+	// A trailer that lies about the size shows that it was used
+	stored := readBaseFile(t, base, "small.txt.gz")
+	binary.LittleEndian.PutUint32(stored[len(stored)-4:], 7)
+	writeBaseFile(t, base, "small.txt.gz", stored)
+
+	f, err := cfs.Open("small.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() != 7 {
+		t.Errorf("Expected Stat size 7 from the trailer, got %d", info.Size())
+	}
+
+	// Random data is stored at about its own size, past the bound
+	data := make([]byte, gzipTrailerBound+1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	writeTestFile(t, cfs, "large.txt", data)
+	stored = readBaseFile(t, base, "large.txt.gz")
+	if gzipSingleMember(bytes.NewReader(stored), int64(len(stored))) {
+		t.Error("Trusted the trailer of a gzip file too large for ISIZE")
+	}
+	lf, err := cfs.Open("large.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer lf.Close()
+	if info, err := lf.Stat(); err != nil || info.Size() != int64(len(data)) {
+		t.Errorf("Stat = %v, %v, expected size %d", info, err, len(data))
+	}
+}
+
+// TestFileRatio tests that FileRatio matches the actual stored ratio, from
+// the gzip ISIZE trailer and by decompressing an lz4 file
+func TestFileRatio(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmLZ4} {
+		base := NewMemFS()
+		cfs, err := New(base, &Config{Algorithm: algo, PreserveExtension: true, StripExtension: true})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+		data := bytes.Repeat([]byte("ratio of a stored file "), 300)
+		writeTestFile(t, cfs, "r.txt", data)
+
+		stored := readBaseFile(t, base, "r.txt"+GetExtension(algo))
+		want := float64(len(stored)) / float64(len(data))
+		got, err := cfs.FileRatio("r.txt")
+		if err != nil {
+			t.Fatalf("FileRatio failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("%s: FileRatio = %v, expected %v", algo, got, want)
+		}
+	}
+
+	cfs, err := New(NewMemFS(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	if _, err := cfs.FileRatio("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FileRatio of a missing file = %v, expected fs.ErrNotExist", err)
+	}
+}
+
+// TestReportUncompressedSize tests that Stat reports the bytes written for
+// every algorithm, from a size footer where the format records no size,
+// and that the stored size is reported without the option
+func TestReportUncompressedSize(t *testing.T) {
+	data := bytes.Repeat([]byte("uncompressed size of a stored file "), 300)
+
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy, AlgorithmXz, AlgorithmZlib} {
+		base := NewMemFS()
+		cfs, err := New(base, &Config{Algorithm: algo, PreserveExtension: true, StripExtension: true, ReportUncompressedSize: true})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+		writeTestFile(t, cfs, "s.txt", data)
+
+		info, err := cfs.Stat("s.txt")
+		if err != nil {
+			t.Fatalf("%s: Stat failed: %v", algo, err)
+		}
+		if info.Size() != int64(len(data)) {
+			t.Errorf("%s: Stat size = %d, expected %d", algo, info.Size(), len(data))
+		}
+
+		f, err := cfs.Open("s.txt")
+		if err != nil {
+			t.Fatalf("%s: Open failed: %v", algo, err)
+		}
+		opened, err := f.Stat()
+		if err != nil {
+			t.Fatalf("%s: Stat of open file failed: %v", algo, err)
+		}
+		if opened.Size() != int64(len(data)) {
+			t.Errorf("%s: open file size = %d, expected %d", algo, opened.Size(), len(data))
+		}
+		got, err := io.ReadAll(f)
+		f.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: read back %d bytes, %v", algo, len(got), err)
+		}
+
+		footer := bytes.HasSuffix(readBaseFile(t, base, "s.txt"+GetExtension(algo)), []byte(sizeFooterMagic))
+		if footer != (algo != AlgorithmGzip) {
+			t.Errorf("%s: size footer written = %v", algo, footer)
+		}
+	}
+
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmLZ4, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, cfs, "s.txt", data)
+	info, err := cfs.Stat("s.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if stored := readBaseFile(t, base, "s.txt.lz4"); info.Size() != int64(len(stored)) {
+		t.Errorf("Stat size without ReportUncompressedSize = %d, expected the stored %d", info.Size(), len(stored))
+	}
+}
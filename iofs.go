@@ -0,0 +1,71 @@
+package compressfs
+
+import (
+	"io/fs"
+	"path"
+
+	"github.com/absfs/absfs"
+)
+
+// IOFS returns a read-only io/fs view of the filesystem. Files are read
+// decompressed, and Stat reports each file under its logical name and
+// decompressed size with the stored file's modification time and mode.
+func (cfs *FS) IOFS() fs.FS {
+	return &ioFS{cfs: cfs}
+}
+
+// ioFS adapts FS to fs.FS, fs.StatFS and fs.ReadDirFS
+type ioFS struct {
+	cfs *FS
+}
+
+func (f *ioFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	file, err := f.cfs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &ioFile{File: file, cfs: f.cfs, name: name}, nil
+}
+
+func (f *ioFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+func (f *ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return f.cfs.ReadDir(name)
+}
+
+// ioFile is a file opened through IOFS
+type ioFile struct {
+	absfs.File
+	cfs  *FS
+	name string
+}
+
+// Stat composes the stored file's information with the logical name and,
+// for compressed files, the decompressed size
+func (f *ioFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	if cf, ok := f.File.(*compressedFile); ok && cf.decompressor != nil {
+		if _, recorded := info.(*originalSizeInfo); !recorded {
+			size = f.cfs.logicalSize(cf.compressedName, size)
+		}
+	}
+	return &logicalFileInfo{FileInfo: info, name: path.Base(f.name), size: size}, nil
+}
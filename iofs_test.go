@@ -0,0 +1,45 @@
+package compressfs
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+)
+
+// TestIOFSStat tests that the io/fs adapter reports logical names, sizes and
+// the stored modification time
+func TestIOFSStat(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmLZ4,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("io/fs adapter "), 500)
+	writeTestFile(t, cfs, "data.txt", data)
+
+	info, err := fs.Stat(cfs.IOFS(), "data.txt")
+	if err != nil {
+		t.Fatalf("fs.Stat failed: %v", err)
+	}
+	if info.Name() != "data.txt" {
+		t.Errorf("Name = %q, expected data.txt", info.Name())
+	}
+	if info.Size() != int64(len(data)) {
+		t.Errorf("Size = %d, expected %d", info.Size(), len(data))
+	}
+	if info.ModTime().IsZero() {
+		t.Error("Expected a nonzero ModTime from the base file")
+	}
+
+	got, err := fs.ReadFile(cfs.IOFS(), "data.txt")
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("fs.ReadFile = %d bytes, %v", len(got), err)
+	}
+	if _, err := fs.Stat(cfs.IOFS(), "/data.txt"); err == nil {
+		t.Error("Expected an error for an invalid io/fs path")
+	}
+}
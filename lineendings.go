@@ -0,0 +1,84 @@
+package compressfs
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/absfs/absfs"
+)
+
+// defaultTextPattern matches the files normalized under
+// Config.NormalizeLineEndings when TextPatterns is empty
+const defaultTextPattern = `(?i)\.(txt|text|csv|tsv|log|md|json|xml|html?|ya?ml|ini|cfg|conf)$`
+
+// crlfMarker seals the empty footer written after the compressed data, and
+// before any metadata footer, of a file whose CRLF line endings were
+// normalized to LF
+const crlfMarker = "CFSL"
+
+// crlfFooter is the footer marking a file normalized to LF
+var crlfFooter = sealFooter(nil, crlfMarker)
+
+// normalizesLineEndings reports whether CRLF line endings in the named file
+// are normalized before compression. The first algorithm rule matching the
+// name decides when it sets NormalizeLineEndings; otherwise the Config
+// setting applies to names matching TextPatterns.
+func (cfs *FS) normalizesLineEndings(name string) bool {
+	for _, rule := range cfs.rules {
+		if rule.pattern.MatchString(name) {
+			if rule.normalize != nil {
+				return *rule.normalize
+			}
+			break
+		}
+	}
+	return cfs.config.NormalizeLineEndings && cfs.text.MatchString(name)
+}
+
+// normalizeCRLF converts the CRLF line endings of data to LF. It reports
+// false, leaving data alone, unless every line ending is CRLF: only then
+// does converting each LF back restore the original exactly.
+func normalizeCRLF(data []byte) ([]byte, bool) {
+	lines := bytes.Count(data, []byte("\n"))
+	if lines == 0 || bytes.Count(data, []byte("\r\n")) != lines {
+		return data, false
+	}
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), true
+}
+
+// withLineEndings hides the CRLF marker of a stored file opened for reading
+// and reports whether it was there
+func (cfs *FS) withLineEndings(f absfs.File) (absfs.File, bool) {
+	trimmed, body, ok := trimFooter(f, crlfMarker)
+	if !ok || len(body) != 0 {
+		return f, false
+	}
+	return trimmed, true
+}
+
+// crlfReader restores CRLF line endings to the LF text of a normalized file
+type crlfReader struct {
+	io.ReadCloser
+	buf     []byte
+	pending []byte // Converted bytes not yet returned
+}
+
+func newCRLFReader(r io.ReadCloser) *crlfReader {
+	return &crlfReader{ReadCloser: r, buf: make([]byte, copyBufferSize)}
+}
+
+func (r *crlfReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		n, err := r.ReadCloser.Read(r.buf)
+		if n > 0 {
+			r.pending = bytes.ReplaceAll(r.buf[:n], []byte("\n"), []byte("\r\n"))
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,96 @@
+package compressfs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestNormalizeLineEndings tests that CRLF text is compressed as LF, read
+// back as LF by default and restored exactly with RestoreLineEndings
+func TestNormalizeLineEndings(t *testing.T) {
+	crlf := []byte(strings.Repeat("name,value\r\nalpha,1\r\n", 200))
+	lf := bytes.ReplaceAll(crlf, []byte("\r\n"), []byte("\n"))
+	mixed := append(append([]byte{}, crlf...), "trailing lf line\n"...)
+
+	base := NewMemFS()
+	config := &Config{
+		Algorithm:            AlgorithmGzip,
+		PreserveExtension:    true,
+		StripExtension:       true,
+		NormalizeLineEndings: true,
+	}
+	cfs, err := New(base, config)
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	writeTestFile(t, cfs, "table.csv", crlf)
+	writeTestFile(t, cfs, "mixed.txt", mixed)
+	writeTestFile(t, cfs, "blob.bin", crlf)
+
+	if got := readTestFile(t, cfs, "table.csv"); !bytes.Equal(got, lf) {
+		t.Error("Normalized file did not read back with LF line endings")
+	}
+	if got := readTestFile(t, cfs, "mixed.txt"); !bytes.Equal(got, mixed) {
+		t.Error("File with mixed line endings was altered")
+	}
+	if got := readTestFile(t, cfs, "blob.bin"); !bytes.Equal(got, crlf) {
+		t.Error("File not matching TextPatterns was altered")
+	}
+
+	stored := readBaseFile(t, base, "table.csv.gz")
+	if !bytes.HasSuffix(stored, []byte(crlfMarker)) {
+		t.Error("Normalized file does not record its CRLF convention")
+	}
+	if bytes.HasSuffix(readBaseFile(t, base, "mixed.txt.gz"), []byte(crlfMarker)) {
+		t.Error("Unconverted file records a CRLF convention")
+	}
+
+	config.RestoreLineEndings = true
+	restoring, err := New(base, config)
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	if got := readTestFile(t, restoring, "table.csv"); !bytes.Equal(got, crlf) {
+		t.Error("RestoreLineEndings did not reproduce the original CRLF text")
+	}
+	if got := readTestFile(t, restoring, "mixed.txt"); !bytes.Equal(got, mixed) {
+		t.Error("RestoreLineEndings altered a file that was not normalized")
+	}
+}
+
+// TestNormalizeLineEndingsRules tests that an algorithm rule overrides the
+// Config setting for the files it matches
+func TestNormalizeLineEndingsRules(t *testing.T) {
+	crlf := []byte(strings.Repeat("@echo off\r\nrem script\r\n", 100))
+	lf := bytes.ReplaceAll(crlf, []byte("\r\n"), []byte("\n"))
+	on, off := true, false
+
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		AlgorithmRules: []AlgorithmRule{
+			{Pattern: `\.bat$`, Algorithm: AlgorithmGzip, Level: -1, NormalizeLineEndings: &on},
+			{Pattern: `keep\.txt$`, Algorithm: AlgorithmGzip, Level: -1, NormalizeLineEndings: &off},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	writeTestFile(t, cfs, "run.bat", crlf)
+	writeTestFile(t, cfs, "keep.txt", crlf)
+	writeTestFile(t, cfs, "other.txt", crlf)
+
+	if got := readTestFile(t, cfs, "run.bat"); !bytes.Equal(got, lf) {
+		t.Error("Rule enabling normalization was not applied")
+	}
+	if got := readTestFile(t, cfs, "keep.txt"); !bytes.Equal(got, crlf) {
+		t.Error("Rule disabling normalization was not applied")
+	}
+	if got := readTestFile(t, cfs, "other.txt"); !bytes.Equal(got, crlf) {
+		t.Error("Text file was normalized without NormalizeLineEndings")
+	}
+}
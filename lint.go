@@ -0,0 +1,98 @@
+package compressfs
+
+import (
+	"io"
+	"os"
+	"path"
+)
+
+// LintProblem describes how a stored file is mislabeled
+type LintProblem string
+
+const (
+	// LintAlgorithmMismatch means the magic bytes identify a different
+	// algorithm than the file's compression extension
+	LintAlgorithmMismatch LintProblem = "algorithm-mismatch"
+
+	// LintNotCompressed means the file carries a compression extension but
+	// its contents are not compressed
+	LintNotCompressed LintProblem = "not-compressed"
+)
+
+// LintIssue reports a stored file whose name and contents disagree
+type LintIssue struct {
+	Path      string      // Stored path on the base filesystem
+	Problem   LintProblem // What is wrong
+	Extension Algorithm   // Algorithm implied by the extension
+	Detected  Algorithm   // Algorithm found in the magic bytes, "" if none
+}
+
+// Lint walks the base filesystem under root and reports files whose
+// compression extension disagrees with their magic bytes. Brotli has no
+// reliable magic bytes, so .br files are only reported when the bytes
+// identify another algorithm.
+func (cfs *FS) Lint(root string) ([]LintIssue, error) {
+	var issues []LintIssue
+	err := cfs.walkBase(cleanPath(root), func(name string) error {
+		_, extAlgo, ok := cfs.stripPlacedExtension(name)
+		if !ok {
+			return nil
+		}
+
+		head, err := cfs.readHead(name, 10)
+		if err != nil {
+			return err
+		}
+		if len(head) == 0 {
+			return nil // Empty files are written without a compressor
+		}
+
+		detected, compressed := IsCompressed(head)
+		switch {
+		case compressed && detected != extAlgo:
+			issues = append(issues, LintIssue{Path: name, Problem: LintAlgorithmMismatch, Extension: extAlgo, Detected: detected})
+		case !compressed && extAlgo != AlgorithmBrotli:
+			issues = append(issues, LintIssue{Path: name, Problem: LintNotCompressed, Extension: extAlgo})
+		}
+		return nil
+	})
+	return issues, err
+}
+
+// walkBase calls fn with the stored path of every file under dir on the
+// base filesystem, recursing into subdirectories
+func (cfs *FS) walkBase(dir string, fn func(name string) error) error {
+	entries, err := cfs.base.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			err = cfs.walkBase(name, fn)
+		} else {
+			err = fn(name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readHead returns up to n leading bytes of a stored file
+func (cfs *FS) readHead(name string, n int) ([]byte, error) {
+	f, err := cfs.base.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, n)
+	read, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return buf[:read], nil
+}
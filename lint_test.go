@@ -0,0 +1,48 @@
+package compressfs
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestLint tests reporting of files whose extension disagrees with their contents
+func TestLint(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("lint me "), 200)
+	writeTestFile(t, cfs, "good.txt", data)
+
+	zstdBytes, err := CompressBytes(data, AlgorithmZstd, 3)
+	if err != nil {
+		t.Fatalf("CompressBytes failed: %v", err)
+	}
+	writeBaseFile(t, base, "wrong.txt.gz", zstdBytes)
+	writeBaseFile(t, base, "plain.txt.zst", data)
+
+	issues, err := cfs.Lint("/")
+	if err != nil {
+		t.Fatalf("Lint failed: %v", err)
+	}
+
+	want := map[string]LintIssue{
+		"/wrong.txt.gz":  {Path: "/wrong.txt.gz", Problem: LintAlgorithmMismatch, Extension: AlgorithmGzip, Detected: AlgorithmZstd},
+		"/plain.txt.zst": {Path: "/plain.txt.zst", Problem: LintNotCompressed, Extension: AlgorithmZstd},
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("Expected %d issues, got %+v", len(want), issues)
+	}
+	for _, issue := range issues {
+		if issue != want[issue.Path] {
+			t.Errorf("Unexpected issue %+v", issue)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package compressfs
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// contentSniffSize is how much of the head of a write is inspected under
+// Config.SkipByContentType, all that http.DetectContentType considers
+const contentSniffSize = 512
+
+// compressedMediaTypes are the media types http.DetectContentType reports
+// for formats that are already compressed and gain nothing from another
+// pass. Gzip is left out: stored raw, it would still be detected and
+// decompressed when read back.
+var compressedMediaTypes = map[string]bool{
+	"image/jpeg":                   true,
+	"image/png":                    true,
+	"image/gif":                    true,
+	"image/webp":                   true,
+	"audio/mpeg":                   true,
+	"application/ogg":              true,
+	"video/mp4":                    true,
+	"video/webm":                   true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+	"application/zip":              true,
+	"application/x-rar-compressed": true,
+}
+
+// skipByContentType switches a buffered write to raw passthrough when
+// Config.SkipByContentType finds an already-compressed media type in its
+// head, whatever the file is named. It reports whether it did. A new
+// member is never stored raw, as the file it extends is compressed.
+func (cf *compressedFile) skipByContentType() (bool, error) {
+	if !cf.cfs.config.SkipByContentType || cf.appendMember || cf.compressor != nil || cf.passthrough {
+		return false, nil
+	}
+	mediaType, ok := compressedMediaType(cf.writeBuffer.Bytes())
+	if !ok {
+		return false, nil
+	}
+
+	cf.skippedType = mediaType
+	cf.passthrough = true
+	_, err := io.Copy(cf.base, cf.writeBuffer)
+	return true, err
+}
+
+// compressedMediaType returns the media type sniffed from head and whether
+// it is one of compressedMediaTypes
+func compressedMediaType(head []byte) (string, bool) {
+	if len(head) == 0 {
+		return "", false
+	}
+	if len(head) > contentSniffSize {
+		head = head[:contentSniffSize]
+	}
+	mediaType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	return mediaType, compressedMediaTypes[mediaType]
+}
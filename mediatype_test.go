@@ -0,0 +1,68 @@
+package compressfs
+
+import (
+	"bytes"
+	"testing"
+)
+
+// jpegTestData returns data with a JPEG header followed by compressible
+// filler, so only content type detection keeps it from being compressed
+func jpegTestData() []byte {
+	header := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00}
+	return append(header, bytes.Repeat([]byte("scanline "), 4000)...)
+}
+
+// TestSkipByContentType tests that a JPEG named .dat is stored uncompressed
+// with SkipByContentType, whether the write is buffered, streamed or
+// sampled
+func TestSkipByContentType(t *testing.T) {
+	data := jpegTestData()
+
+	configs := map[string]*Config{
+		"buffered": {ZstdContentSize: true}, // Buffers the whole file until Close
+		"streamed": {},
+		"sampled":  {StreamingSample: true, BufferSize: 4096},
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			rec := &decisionRecorder{}
+			base := NewMemFS()
+			config.Algorithm = AlgorithmGzip
+			config.PreserveExtension = true
+			config.StripExtension = true
+			config.SkipByContentType = true
+			config.OnDecision = rec.record
+			cfs, err := New(base, config)
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+
+			writeTestFile(t, cfs, "photo.dat", data)
+			writeTestFile(t, cfs, "notes.dat", data[11:])
+
+			if stored := readBaseFile(t, base, "photo.dat"); !bytes.Equal(stored, data) {
+				t.Errorf("JPEG content stored as %d bytes, expected it uncompressed", len(stored))
+			}
+			if got := readTestFile(t, cfs, "photo.dat"); !bytes.Equal(got, data) {
+				t.Error("Uncompressed JPEG did not read back")
+			}
+			if ev, ok := rec.find("photo.dat", true); !ok || ev.Reason != ReasonContentType {
+				t.Errorf("Decision = %+v, expected %s", ev, ReasonContentType)
+			}
+			if stored := readBaseFile(t, base, "notes.dat.gz"); len(stored) >= len(data)/2 {
+				t.Errorf("Text content stored as %d bytes, expected it compressed", len(stored))
+			}
+		})
+	}
+
+	// Without the flag the JPEG header is not consulted
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, cfs, "photo.dat", data)
+	if stored := readBaseFile(t, base, "photo.dat.gz"); bytes.Equal(stored, data) {
+		t.Error("JPEG content stored uncompressed without SkipByContentType")
+	}
+}
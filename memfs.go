@@ -49,6 +49,7 @@ type memFile struct {
 	mode    fs.FileMode
 	modTime time.Time
 	pos     int64
+	append  bool
 	closed  bool
 	mu      sync.Mutex
 }
@@ -99,11 +100,7 @@ func (mfs *memFS) OpenFile(name string, flag int, perm fs.FileMode) (absfs.File,
 		mode:    mf.mode,
 		modTime: mf.modTime,
 		pos:     0,
-	}
-
-	// Set position to end if append mode
-	if flag&os.O_APPEND != 0 {
-		handle.pos = int64(mf.data.Len())
+		append:  flag&os.O_APPEND != 0,
 	}
 
 	return handle, nil
@@ -156,11 +153,24 @@ func (mfs *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
 	name = normalizePath(name)
 	var entries []fs.DirEntry
 
+	// Directories are implied by the paths of the files within them
+	subdirs := make(map[string]bool)
 	for path := range mfs.files {
 		dir := filepath.Dir(path)
 		if dir == name {
 			info, _ := mfs.Stat(path)
 			entries = append(entries, fs.FileInfoToDirEntry(info))
+			continue
+		}
+		for ; dir != "."; dir = filepath.Dir(dir) {
+			if parent := filepath.Dir(dir); parent == name && !subdirs[dir] {
+				subdirs[dir] = true
+				entries = append(entries, fs.FileInfoToDirEntry(&memFileInfo{
+					name:    filepath.Base(dir),
+					mode:    fs.ModeDir | 0755,
+					modTime: time.Now(),
+				}))
+			}
 		}
 	}
 
@@ -288,10 +298,25 @@ func (mf *memFile) Write(p []byte) (n int, err error) {
 		return 0, fs.ErrClosed
 	}
 
-	// For simplicity, append to buffer
-	n, err = mf.data.Write(p)
+	// Append mode writes at the end, wherever other handles left it
+	if mf.append {
+		mf.pos = int64(mf.data.Len())
+	}
+	n = mf.writeAt(p, mf.pos)
+	mf.pos += int64(n)
 	mf.modTime = time.Now()
-	return n, err
+	return n, nil
+}
+
+// writeAt writes p at off in the buffer shared by every handle, growing it
+// with zeros as needed. The caller holds mf.mu.
+func (mf *memFile) writeAt(p []byte, off int64) int {
+	if grow := off - int64(mf.data.Len()); grow > 0 {
+		mf.data.Write(make([]byte, grow))
+	}
+	n := copy(mf.data.Bytes()[off:], p)
+	mf.data.Write(p[n:])
+	return len(p)
 }
 
 func (mf *memFile) Close() error {
@@ -410,19 +435,7 @@ func (mf *memFile) WriteAt(b []byte, off int64) (n int, err error) {
 		return 0, errors.New("negative offset")
 	}
 
-	// Expand buffer if needed
-	data := mf.data.Bytes()
-	needed := int(off) + len(b)
-	if needed > len(data) {
-		// Create new buffer with expanded size
-		newData := make([]byte, needed)
-		copy(newData, data)
-		mf.data = bytes.NewBuffer(newData)
-	}
-
-	// Write at offset
-	data = mf.data.Bytes()
-	n = copy(data[off:], b)
+	n = mf.writeAt(b, off)
 	mf.modTime = time.Now()
 	return n, nil
 }
@@ -441,15 +454,15 @@ func (mf *memFile) Truncate(size int64) error {
 		return fs.ErrClosed
 	}
 
-	data := mf.data.Bytes()
-	if size < int64(len(data)) {
-		// Truncate to smaller size
-		mf.data = bytes.NewBuffer(data[:size])
-	} else if size > int64(len(data)) {
-		// Expand with zeros
-		newData := make([]byte, size)
-		copy(newData, data)
-		mf.data = bytes.NewBuffer(newData)
+	if size < 0 {
+		return errors.New("negative size")
+	}
+
+	// Resize the shared buffer so every handle sees the new size
+	if size < int64(mf.data.Len()) {
+		mf.data.Truncate(int(size))
+	} else if grow := size - int64(mf.data.Len()); grow > 0 {
+		mf.data.Write(make([]byte, grow))
 	}
 
 	mf.modTime = time.Now()
@@ -0,0 +1,123 @@
+package compressfs
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultZstdWindow is the window of zstd encoders without ZstdWindowSize
+const defaultZstdWindow = 8 << 20
+
+// zstdTableMemory estimates the match-finding tables of the zstd encoder
+// at each encoder level
+var zstdTableMemory = map[zstd.EncoderLevel]int64{
+	zstd.SpeedFastest:           256 << 10,
+	zstd.SpeedDefault:           1 << 20,
+	zstd.SpeedBetterCompression: 5 << 20,
+	zstd.SpeedBestCompression:   10 << 20,
+}
+
+// zstdEncoderLevel maps a compression level to the zstd encoder level
+func zstdEncoderLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level <= 0:
+		return zstd.SpeedFastest
+	case level <= 3:
+		return zstd.SpeedDefault
+	case level <= 6:
+		return zstd.SpeedBetterCompression
+	default:
+		return zstd.SpeedBestCompression
+	}
+}
+
+// encoderMemory estimates the memory an encoder for algo at level holds
+// while compressing: its history window, doubled where the window is
+// buffered ahead of the match finder, plus its tables. window is the zstd
+// window and is ignored for other algorithms.
+func encoderMemory(algo Algorithm, level, window int) int64 {
+	switch algo {
+	case AlgorithmZstd:
+		return 2*int64(window) + zstdTableMemory[zstdEncoderLevel(level)]
+	case AlgorithmGzip, AlgorithmZlib:
+		if level == 0 {
+			return 64 << 10 // Stored blocks need no match finder
+		}
+		return 1 << 20
+	case AlgorithmLZ4:
+		return 8<<20 + 64<<10 // Two 4MB blocks and the hash table
+	case AlgorithmBrotli:
+		// Window of 4MB from level 2 upwards, with hashers growing past 9
+		switch {
+		case level < 2:
+			return 1 << 20
+		case level < 10:
+			return 8 << 20
+		default:
+			return 24 << 20
+		}
+	case AlgorithmSnappy:
+		return 160 << 10
+	case AlgorithmXz:
+		if level < 0 || level >= len(xzDictCaps) {
+			level = 6
+		}
+		return 2*int64(xzDictCaps[level]) + 1<<20
+	}
+	return 0
+}
+
+// zstdWindow returns the window zstd encoders are created with
+func (cfs *FS) zstdWindow() int {
+	if cfs.config.ZstdWindowSize > 0 {
+		return cfs.config.ZstdWindowSize
+	}
+	return defaultZstdWindow
+}
+
+// fitCompressMemory returns the level, and for zstd the window, to create
+// an encoder for algo with under Config.MaxCompressMemory. A zstd window is
+// halved first, then the level is lowered, until the estimate fits; each
+// downgraded encoder is counted in Stats.CompressMemoryDowngrades. It fails
+// with ErrMemoryBudget when even the smallest setting does not fit.
+func (cfs *FS) fitCompressMemory(algo Algorithm, level int) (int, int, error) {
+	window := cfs.zstdWindow()
+	budget := cfs.config.MaxCompressMemory
+	if budget <= 0 || encoderMemory(algo, level, window) <= budget {
+		return level, window, nil
+	}
+
+	fitted := level
+	if algo == AlgorithmZstd {
+		for window > zstd.MinWindowSize && encoderMemory(algo, fitted, window) > budget {
+			window /= 2
+		}
+	}
+	lo, _, _ := levelRange(algo)
+	for fitted > lo && encoderMemory(algo, fitted, window) > budget {
+		fitted--
+	}
+	if need := encoderMemory(algo, fitted, window); need > budget {
+		return 0, 0, fmt.Errorf("%w: %s needs about %d bytes even at level %d, over the %d byte budget",
+			ErrMemoryBudget, algo, need, fitted, budget)
+	}
+
+	cfs.incrementStat(&cfs.stats.CompressMemoryDowngrades)
+	return fitted, window, nil
+}
+
+// zstdEncoderOptions returns Config.ZstdEncoderOptions with the window. A
+// configured window is prepended so explicit options still take precedence;
+// under Config.MaxCompressMemory the window the budget was fitted with is
+// appended instead, so a larger window in the options cannot exceed it.
+func (cfs *FS) zstdEncoderOptions(window int) []zstd.EOption {
+	opts := cfs.config.ZstdEncoderOptions
+	if cfs.config.MaxCompressMemory > 0 {
+		return append(opts[:len(opts):len(opts)], zstd.WithWindowSize(window))
+	}
+	if cfs.config.ZstdWindowSize == 0 && window == defaultZstdWindow {
+		return opts
+	}
+	return append([]zstd.EOption{zstd.WithWindowSize(window)}, opts...)
+}
@@ -0,0 +1,95 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// TestMaxCompressMemory tests that a zstd window too large for the memory
+// budget is downgraded to fit and counted in Stats
+func TestMaxCompressMemory(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmZstd,
+		Level:             19,
+		PreserveExtension: true,
+		StripExtension:    true,
+		ZstdWindowSize:    256 << 20,
+		MaxCompressMemory: 64 << 20,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := cdcTestData(256 << 10)
+	writeTestFile(t, cfs, "data.txt", data)
+	if got := readTestFile(t, cfs, "data.txt"); !bytes.Equal(got, data) {
+		t.Fatal("Downgraded file did not round-trip")
+	}
+
+	var header zstd.Header
+	if err := header.Decode(readBaseFile(t, base, "data.txt.zst")); err != nil {
+		t.Fatalf("Failed to decode zstd header: %v", err)
+	}
+	if header.WindowSize == 0 || header.WindowSize > 32<<20 {
+		t.Errorf("Window size %d was not downgraded to fit the 64MB budget", header.WindowSize)
+	}
+	if stats := cfs.GetStats(); stats.CompressMemoryDowngrades == 0 {
+		t.Error("Downgrade not recorded in Stats")
+	}
+
+	// A window set in the encoder options is held to the budget too
+	optioned, err := New(base, &Config{
+		Algorithm:          AlgorithmZstd,
+		Level:              19,
+		PreserveExtension:  true,
+		StripExtension:     true,
+		ZstdEncoderOptions: []zstd.EOption{zstd.WithWindowSize(256 << 20)},
+		MaxCompressMemory:  64 << 20,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, optioned, "optioned.txt", data)
+	if err := header.Decode(readBaseFile(t, base, "optioned.txt.zst")); err != nil {
+		t.Fatalf("Failed to decode zstd header: %v", err)
+	}
+	if header.WindowSize == 0 || header.WindowSize > 32<<20 {
+		t.Errorf("Window size %d from the encoder options was not held to the 64MB budget", header.WindowSize)
+	}
+
+	unlimited, err := New(NewMemFS(), &Config{Algorithm: AlgorithmZstd, Level: 19, ZstdWindowSize: 256 << 20})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	if _, window, _ := unlimited.fitCompressMemory(AlgorithmZstd, 19); window != 256<<20 {
+		t.Errorf("Window changed to %d without a budget", window)
+	}
+}
+
+// TestMaxCompressMemoryExceeded tests that a budget no setting fits fails
+// the write with ErrMemoryBudget
+func TestMaxCompressMemoryExceeded(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{Algorithm: AlgorithmGzip, MaxCompressMemory: 1 << 10})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	f, err := cfs.OpenFile("data.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	_, err = f.Write(cdcTestData(4096))
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if !errors.Is(err, ErrMemoryBudget) {
+		t.Errorf("Expected ErrMemoryBudget, got %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+package compressfs
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// Metadata footer appended to compressed files under
+// Config.EmbedFileMetadata: mode (uint32) and modification time in Unix
+// nanoseconds (int64), both little endian, sealed with metadataMagic
+const (
+	metadataMagic    = "CFSM"
+	metadataBodySize = 4 + 8
+)
+
+// fileMetadata holds the attributes recorded in a metadata footer
+type fileMetadata struct {
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// encode returns the footer recording m
+func (m fileMetadata) encode() []byte {
+	body := make([]byte, 0, metadataBodySize)
+	body = binary.LittleEndian.AppendUint32(body, uint32(m.mode))
+	body = binary.LittleEndian.AppendUint64(body, uint64(m.modTime.UnixNano()))
+	return sealFooter(body, metadataMagic)
+}
+
+// decodeMetadata parses the body of a metadata footer, reporting false if
+// it is not one
+func decodeMetadata(body []byte) (fileMetadata, bool) {
+	if len(body) != metadataBodySize {
+		return fileMetadata{}, false
+	}
+	return fileMetadata{
+		mode:    fs.FileMode(binary.LittleEndian.Uint32(body)),
+		modTime: time.Unix(0, int64(binary.LittleEndian.Uint64(body[4:]))),
+	}, true
+}
+
+// writeMetadata appends the metadata footer to a file just written
+// compressed, recording the mode of the stored file and the time of writing
+func (cf *compressedFile) writeMetadata() error {
+	info, err := cf.base.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = cf.base.Write(fileMetadata{mode: info.Mode(), modTime: time.Now()}.encode())
+	return err
+}
+
+// withMetadata hides the metadata footer of a stored file opened for reading
+// and returns its metadata, or returns f unchanged when f has no footer
+func (cfs *FS) withMetadata(f absfs.File) (absfs.File, *fileMetadata) {
+	trimmed, body, ok := trimFooter(f, metadataMagic)
+	if !ok {
+		return f, nil
+	}
+	meta, ok := decodeMetadata(body)
+	if !ok {
+		return f, nil
+	}
+	return trimmed, &meta
+}
+
+// Size footer appended to compressed files under
+// Config.ReportUncompressedSize when the format records no size of its own:
+// the uncompressed size (uint64, little endian), sealed with sizeFooterMagic
+const sizeFooterMagic = "CFSZ"
+
+// encodeSizeFooter returns the size footer recording size
+func encodeSizeFooter(size int64) []byte {
+	return sealFooter(binary.LittleEndian.AppendUint64(nil, uint64(size)), sizeFooterMagic)
+}
+
+// withSizeFooter hides the size footer of a stored file opened for reading
+// and returns the recorded size, or returns f unchanged and -1 when f has no
+// footer
+func (cfs *FS) withSizeFooter(f absfs.File) (absfs.File, int64) {
+	trimmed, body, ok := trimFooter(f, sizeFooterMagic)
+	if !ok || len(body) != 8 {
+		return f, -1
+	}
+	return trimmed, int64(binary.LittleEndian.Uint64(body))
+}
+
+// recordsSize reports whether files written with algo record their
+// uncompressed size in the stream itself, as gzip's ISIZE trailers and the
+// seek table of seekable zstd do, so no size footer is needed
+func (cfs *FS) recordsSize(algo Algorithm) bool {
+	return algo == AlgorithmGzip || (algo == AlgorithmZstd && cfs.config.Seekable)
+}
+
+// storedFooters holds what the footers of a stored file record
+type storedFooters struct {
+	meta *fileMetadata // EmbedFileMetadata footer, nil if absent
+	crlf bool          // NormalizeLineEndings converted the file from CRLF
+	size int64         // ReportUncompressedSize footer or chunk index total, -1 if absent
+	hash *contentHash  // StoreContentHash footer, nil if absent
+}
+
+// withFooters hides the footers written under Config.EmbedFileMetadata,
+// Config.StoreContentHash, Config.ReportUncompressedSize,
+// Config.NormalizeLineEndings and Config.ContentDefinedChunking from the
+// file stored as name, opened for reading, in the reverse of the order they
+// are written, and returns what they record. Footers only follow compressed
+// data, so a file stored raw is returned as it is whatever it ends in.
+// Otherwise footers are recognised by their seal whatever the reading FS is
+// configured with, so a file written with an option set still decodes
+// through an FS without it.
+func (cfs *FS) withFooters(f absfs.File, name string) (absfs.File, storedFooters) {
+	footers := storedFooters{size: -1}
+	head := make([]byte, 16) // Enough for every magic IsCompressed knows
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return f, footers
+	}
+	if _, compressed := cfs.headAlgorithm(name, head[:n]); !compressed {
+		return f, footers
+	}
+
+	f, footers.meta = cfs.withMetadata(f)
+	f, footers.hash = cfs.withHashFooter(f)
+	f, footers.size = cfs.withSizeFooter(f)
+	f, footers.crlf = cfs.withLineEndings(f)
+	f, chunks := cfs.withChunkIndex(f)
+	if footers.size < 0 && chunks != nil {
+		footers.size = chunkedSize(chunks)
+	}
+	return f, footers
+}
+
+// Every footer ends in a seal: the length of the footer body (uint32), the
+// CRC-32 of the body and magic (uint32), both little endian, then the
+// footer's magic. Stored data that merely ends in a magic fails the check.
+const footerSealSize = 4 + 4 + 4
+
+// sealFooter returns the footer holding body, sealed with magic
+func sealFooter(body []byte, magic string) []byte {
+	footer := append(make([]byte, 0, len(body)+footerSealSize), body...)
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(body)))
+	footer = binary.LittleEndian.AppendUint32(footer, footerCRC(body, magic))
+	return append(footer, magic...)
+}
+
+// footerCRC returns the checksum sealing body with magic
+func footerCRC(body []byte, magic string) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(body), crc32.IEEETable, []byte(magic))
+}
+
+// readFooter reads the footer sealed with magic at the end of r, of the
+// given size, and returns its body and the size of the data before it. It
+// reports false when r does not end in a valid seal.
+func readFooter(r io.ReaderAt, size int64, magic string) ([]byte, int64, bool) {
+	if size < int64(footerSealSize) {
+		return nil, 0, false
+	}
+	seal := make([]byte, footerSealSize)
+	if _, err := r.ReadAt(seal, size-int64(footerSealSize)); err != nil || string(seal[8:]) != magic {
+		return nil, 0, false
+	}
+	start := size - int64(footerSealSize) - int64(binary.LittleEndian.Uint32(seal))
+	if start < 0 {
+		return nil, 0, false
+	}
+	body := make([]byte, size-int64(footerSealSize)-start)
+	if _, err := r.ReadAt(body, start); err != nil && (err != io.EOF || len(body) > 0) {
+		return nil, 0, false
+	}
+	if footerCRC(body, magic) != binary.LittleEndian.Uint32(seal[4:]) {
+		return nil, 0, false
+	}
+	return body, start, true
+}
+
+// trimFooter reads the footer sealed with magic at the end of f and
+// returns f without it and the footer's body, or reports false
+func trimFooter(f absfs.File, magic string) (absfs.File, []byte, bool) {
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return f, nil, false
+	}
+	body, size, ok := readFooter(f, info.Size(), magic)
+	if !ok {
+		return f, nil, false
+	}
+	return &trimmedFile{File: f, size: size}, body, true
+}
+
+// trimmedFile presents a stored file without a footer
+type trimmedFile struct {
+	absfs.File
+	size int64 // Size of the data before the footer
+	pos  int64
+}
+
+func (f *trimmedFile) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if remaining := f.size - f.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := f.File.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *trimmedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	if remaining := f.size - off; int64(len(p)) > remaining {
+		n, err := f.File.ReadAt(p[:remaining], off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func (f *trimmedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	case io.SeekStart:
+	default:
+		return 0, errors.New("compressfs: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("compressfs: negative position")
+	}
+	if _, err := f.File.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *trimmedFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &originalSizeInfo{FileInfo: info, size: f.size}, nil
+}
+
+// Restore writes the decompressed content of the named file to the same
+// name on target, with the mode and modification time recorded under
+// Config.EmbedFileMetadata. Files without recorded metadata take the stored
+// file's attributes.
+func (cfs *FS) Restore(name string, target absfs.Filer) error {
+	f, err := cfs.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var meta fileMetadata
+	if cf, ok := f.(*compressedFile); ok && cf.meta != nil {
+		meta = *cf.meta
+	} else {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		meta = fileMetadata{mode: info.Mode(), modTime: info.ModTime()}
+	}
+
+	dst, err := target.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, meta.mode.Perm())
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, copyBufferSize)
+	if _, err := io.CopyBuffer(dst, onlyReader{f}, buf); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	// The mode given to OpenFile may be masked; set it explicitly
+	if err := target.Chmod(name, meta.mode.Perm()); err != nil {
+		return err
+	}
+	return target.Chtimes(name, meta.modTime, meta.modTime)
+}
@@ -0,0 +1,137 @@
+package compressfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRestoreMetadata tests that a compressed blob moved to a base that lost
+// its attributes restores with the mode and time it was written with
+func TestRestoreMetadata(t *testing.T) {
+	config := &Config{
+		Algorithm:         AlgorithmZstd,
+		PreserveExtension: true,
+		StripExtension:    true,
+		EmbedFileMetadata: true,
+	}
+	src := NewMemFS()
+	cfs, err := New(src, config)
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("archived with its attributes "), 200)
+	before := time.Now()
+	f, err := cfs.OpenFile("secret.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	f.Write(data)
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	after := time.Now()
+
+	// Copy the blob to a fresh base, which creates it with a default mode
+	moved := NewMemFS()
+	writeBaseFile(t, moved, "secret.txt.zst", readBaseFile(t, src, "secret.txt.zst"))
+	archive, err := New(moved, config)
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	if got := readTestFile(t, archive, "secret.txt"); !bytes.Equal(got, data) {
+		t.Fatal("Read with a metadata footer did not round-trip")
+	}
+
+	target := NewMemFS()
+	if err := archive.Restore("secret.txt", target); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	info, err := target.Stat("secret.txt")
+	if err != nil {
+		t.Fatalf("Stat of restored file failed: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Restored mode = %v, expected 0600", info.Mode().Perm())
+	}
+	if info.ModTime().Before(before) || info.ModTime().After(after) {
+		t.Errorf("Restored mtime %v, expected the write time between %v and %v", info.ModTime(), before, after)
+	}
+	if got := readBaseFile(t, target, "secret.txt"); !bytes.Equal(got, data) {
+		t.Error("Restored content differs from the original")
+	}
+}
+
+// TestRawFilesEndingInMagic tests that files stored raw keep their last
+// bytes when they happen to end in a footer magic, or in a whole footer
+func TestRawFilesEndingInMagic(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		MinSize:           1024,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	contents := map[string][]byte{
+		"size.txt":   []byte("hello world, this ends in CFSZ"),
+		"meta.txt":   []byte("abcdefgh12345678CFSM"),
+		"hash.txt":   []byte("\x01\x20ends in CFSH"),
+		"crlf.txt":   []byte("line\nCFSL"),
+		"chunk.txt":  []byte("\x00\x00\x00\x00CFSC"),
+		"sealed.txt": append([]byte("a sealed size footer: "), encodeSizeFooter(3)...),
+	}
+	for name, data := range contents {
+		writeTestFile(t, cfs, name, data)
+		if got := readTestFile(t, cfs, name); !bytes.Equal(got, data) {
+			t.Errorf("%s read back as %q, expected %q", name, got, data)
+		}
+		info, err := cfs.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%s) failed: %v", name, err)
+		}
+		if info.Size() != int64(len(data)) {
+			t.Errorf("Stat(%s) size = %d, expected %d", name, info.Size(), len(data))
+		}
+	}
+}
+
+// TestFootersWithoutOption tests that files written with each footer option
+// read back through an FS that sets none of them
+func TestFootersWithoutOption(t *testing.T) {
+	data := bytes.Repeat([]byte("footer test line\r\n"), 500)
+	for name, config := range map[string]*Config{
+		"metadata": {EmbedFileMetadata: true},
+		"hash":     {StoreContentHash: true},
+		"size":     {ReportUncompressedSize: true},
+		"crlf":     {NormalizeLineEndings: true},
+		"chunks":   {ContentDefinedChunking: true},
+		"all":      {EmbedFileMetadata: true, StoreContentHash: true, ReportUncompressedSize: true, NormalizeLineEndings: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			base := NewMemFS()
+			config.Algorithm, config.PreserveExtension, config.StripExtension = AlgorithmZstd, true, true
+			writer, err := New(base, config)
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+			writeTestFile(t, writer, "data.txt", data)
+
+			reader, err := New(base, &Config{Algorithm: AlgorithmZstd, PreserveExtension: true, StripExtension: true})
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+			want := data
+			if config.NormalizeLineEndings {
+				want = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+			}
+			if got := readTestFile(t, reader, "data.txt"); !bytes.Equal(got, want) {
+				t.Error("Plain FS did not read the file back")
+			}
+		})
+	}
+}
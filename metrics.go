@@ -0,0 +1,25 @@
+package compressfs
+
+import "time"
+
+// Metrics receives per-file codec observations, for wiring compressfs into
+// external metrics systems without polling GetStats. Implementations must be
+// safe for concurrent use.
+type Metrics interface {
+	// ObserveCompress is called when a compressed write is closed, with the
+	// input size, the stored size and the time spent compressing
+	ObserveCompress(algo Algorithm, orig, compressed int64, dur time.Duration)
+
+	// ObserveDecompress is called when a compressed read is closed, with the
+	// bytes decompressed, the stored size and the time spent decompressing
+	ObserveDecompress(algo Algorithm, orig, compressed int64, dur time.Duration)
+}
+
+// storedSize returns the current size of the base file, or 0 if unknown
+func (cf *compressedFile) storedSize() int64 {
+	info, err := cf.base.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
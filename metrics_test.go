@@ -0,0 +1,80 @@
+package compressfs
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+// metricsObservation is one call recorded by fakeMetrics
+type metricsObservation struct {
+	algo             Algorithm
+	orig, compressed int64
+	dur              time.Duration
+}
+
+// fakeMetrics records every observation it receives
+type fakeMetrics struct {
+	mu         sync.Mutex
+	compress   []metricsObservation
+	decompress []metricsObservation
+}
+
+func (m *fakeMetrics) ObserveCompress(algo Algorithm, orig, compressed int64, dur time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.compress = append(m.compress, metricsObservation{algo, orig, compressed, dur})
+}
+
+func (m *fakeMetrics) ObserveDecompress(algo Algorithm, orig, compressed int64, dur time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decompress = append(m.decompress, metricsObservation{algo, orig, compressed, dur})
+}
+
+// TestMetrics tests that compress and decompress observations carry the
+// logical and stored sizes
+func TestMetrics(t *testing.T) {
+	for _, sample := range []bool{false, true} {
+		metrics := &fakeMetrics{}
+		base := NewMemFS()
+		cfs, err := New(base, &Config{
+			Algorithm:         AlgorithmZstd,
+			PreserveExtension: true,
+			StripExtension:    true,
+			BufferSize:        1024,
+			StreamingSample:   sample,
+			Metrics:           metrics,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+
+		data := bytes.Repeat([]byte("observed metrics payload "), 500)
+		writeTestFile(t, cfs, "observed.txt", data)
+		info, err := base.Stat("observed.txt.zst")
+		if err != nil {
+			t.Fatalf("Expected observed.txt.zst: %v", err)
+		}
+
+		if got := readTestFile(t, cfs, "observed.txt"); !bytes.Equal(got, data) {
+			t.Fatalf("StreamingSample=%v: content did not round-trip", sample)
+		}
+
+		want := metricsObservation{AlgorithmZstd, int64(len(data)), info.Size(), 0}
+		for op, obs := range map[string][]metricsObservation{"compress": metrics.compress, "decompress": metrics.decompress} {
+			if len(obs) != 1 {
+				t.Fatalf("StreamingSample=%v: expected 1 %s observation, got %d", sample, op, len(obs))
+			}
+			got := obs[0]
+			if got.algo != want.algo || got.orig != want.orig || got.compressed != want.compressed {
+				t.Errorf("StreamingSample=%v: %s observed (%s, %d, %d), want (%s, %d, %d)",
+					sample, op, got.algo, got.orig, got.compressed, want.algo, want.orig, want.compressed)
+			}
+			if got.dur <= 0 {
+				t.Errorf("StreamingSample=%v: %s duration not recorded", sample, op)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package compressfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"runtime"
+)
+
+// concatenates reports whether independently compressed streams of algo
+// decode as one stream when written back to back: gzip members, zstd
+// frames and snappy framed streams do; lz4 and brotli do not
+func concatenates(algo Algorithm) bool {
+	switch algo {
+	case AlgorithmGzip, AlgorithmZstd, AlgorithmSnappy:
+		return true
+	}
+	return false
+}
+
+// useParallel reports whether a file of at least size bytes is compressed
+// in parallel chunks. Seekable zstd files are already written as
+// independent frames, with a seek table the chunks would not share.
+func (cfs *FS) useParallel(algo Algorithm, size int64) bool {
+	config := cfs.config
+	if !config.EnableParallelCompression || size < config.ParallelThreshold || !concatenates(algo) {
+		return false
+	}
+	return !(algo == AlgorithmZstd && config.Seekable)
+}
+
+// parallelResult is the compressed form of one chunk
+type parallelResult struct {
+	data []byte
+	err  error
+}
+
+// parallelWriter splits its input into chunks of chunkSize bytes, compresses
+// them concurrently as independent streams and writes them to w in input
+// order. At most one chunk per CPU is in flight, which bounds memory use.
+type parallelWriter struct {
+	ctx       context.Context
+	cfs       *FS
+	algo      Algorithm
+	level     int
+	w         io.Writer
+	chunkSize int
+	buf       []byte
+	pending   []chan parallelResult
+	limit     int
+	err       error
+	closed    bool
+}
+
+// newParallelCompressor creates a compressor writing algo to w from chunks
+// of Config.ParallelChunkSize compressed across runtime.NumCPU goroutines.
+// No chunk is started once ctx is done.
+func (cfs *FS) newParallelCompressor(ctx context.Context, algo Algorithm, level int, w io.Writer) io.WriteCloser {
+	chunkSize := cfs.config.ParallelChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultConfig().ParallelChunkSize
+	}
+	return &parallelWriter{
+		ctx:       ctx,
+		cfs:       cfs,
+		algo:      algo,
+		level:     level,
+		w:         w,
+		chunkSize: chunkSize,
+		buf:       make([]byte, 0, chunkSize),
+		limit:     runtime.NumCPU(),
+	}
+}
+
+func (p *parallelWriter) Write(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+
+	written := 0
+	for len(b) > 0 {
+		take := min(p.chunkSize-len(p.buf), len(b))
+		p.buf = append(p.buf, b[:take]...)
+		b = b[take:]
+		written += take
+
+		if len(p.buf) == p.chunkSize {
+			if err := p.dispatch(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// dispatch starts compressing the buffered chunk, first writing out the
+// oldest result when the in-flight limit is reached
+func (p *parallelWriter) dispatch() error {
+	if err := p.ctx.Err(); err != nil {
+		p.err = err
+		return err
+	}
+	if len(p.pending) >= p.limit {
+		if err := p.writeOldest(); err != nil {
+			return err
+		}
+	}
+
+	chunk := p.buf
+	p.buf = make([]byte, 0, p.chunkSize)
+	result := make(chan parallelResult, 1)
+	p.pending = append(p.pending, result)
+
+	go func() {
+		data, err := p.compressChunk(chunk)
+		result <- parallelResult{data: data, err: err}
+	}()
+	return nil
+}
+
+// compressChunk compresses one chunk as a complete stream
+func (p *parallelWriter) compressChunk(chunk []byte) ([]byte, error) {
+	var out bytes.Buffer
+	compressor, err := p.cfs.newCompressor(p.algo, p.level, &out)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := compressor.Write(chunk); err != nil {
+		compressor.Close()
+		return nil, err
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// writeOldest waits for the oldest chunk in flight and writes it to w
+func (p *parallelWriter) writeOldest() error {
+	result := <-p.pending[0]
+	p.pending = p.pending[1:]
+	if result.err == nil {
+		_, result.err = p.w.Write(result.data)
+	}
+	if result.err != nil && p.err == nil {
+		p.err = result.err
+	}
+	return p.err
+}
+
+// Flush compresses the partial chunk and writes every chunk in flight, so
+// everything written so far is in complete streams
+func (p *parallelWriter) Flush() error {
+	if p.err == nil && len(p.buf) > 0 {
+		p.err = p.dispatch()
+	}
+	for len(p.pending) > 0 {
+		p.writeOldest()
+	}
+	return p.err
+}
+
+// Close compresses the final partial chunk and writes every chunk still in
+// flight. An empty input still produces one empty stream.
+func (p *parallelWriter) Close() error {
+	if p.closed {
+		return p.err
+	}
+	p.closed = true
+
+	if p.err == nil && (len(p.buf) > 0 || len(p.pending) == 0) {
+		p.err = p.dispatch()
+	}
+	for len(p.pending) > 0 {
+		p.writeOldest()
+	}
+	return p.err
+}
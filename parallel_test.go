@@ -0,0 +1,110 @@
+package compressfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// parallelTestData returns compressible data of the given size
+func parallelTestData(size int) []byte {
+	var data []byte
+	for i := 0; len(data) < size; i++ {
+		data = append(data, fmt.Sprintf("record %08d: parallel compression payload\n", i)...)
+	}
+	return data[:size]
+}
+
+// TestParallelCompression tests that large files are written as chunks that
+// decode back to the original content
+func TestParallelCompression(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZstd, AlgorithmSnappy} {
+		t.Run(string(algo), func(t *testing.T) {
+			cfs, err := New(NewMemFS(), &Config{
+				Algorithm:                 algo,
+				PreserveExtension:         true,
+				StripExtension:            true,
+				EnableParallelCompression: true,
+				ParallelThreshold:         1 << 20,
+				ParallelChunkSize:         256 << 10,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+
+			data := parallelTestData(3<<20 + 1234)
+			f, err := cfs.Create("large.txt")
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			for chunk := data; len(chunk) > 0; {
+				n := min(len(chunk), 100000)
+				if _, err := f.Write(chunk[:n]); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+				chunk = chunk[n:]
+			}
+			if _, ok := f.(*compressedFile).compressor.(*parallelWriter); !ok {
+				t.Fatal("Expected a parallel compressor past ParallelThreshold")
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			if got := readTestFile(t, cfs, "large.txt"); !bytes.Equal(got, data) {
+				t.Error("Parallel compressed file did not round-trip")
+			}
+		})
+	}
+}
+
+// TestParallelCompressionMembers tests that gzip chunks are separate members
+// and that files below ParallelThreshold stay a single member
+func TestParallelCompressionMembers(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:                 AlgorithmGzip,
+		PreserveExtension:         true,
+		StripExtension:            true,
+		EnableParallelCompression: true,
+		ParallelThreshold:         1 << 20,
+		ParallelChunkSize:         256 << 10,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	firstMember := func(name string) int {
+		t.Helper()
+		zr, err := gzip.NewReader(bytes.NewReader(readBaseFile(t, base, name)))
+		if err != nil {
+			t.Fatalf("gzip.NewReader failed: %v", err)
+		}
+		zr.Multistream(false)
+		n, err := io.Copy(io.Discard, zr)
+		if err != nil {
+			t.Fatalf("Reading first member failed: %v", err)
+		}
+		return int(n)
+	}
+
+	writeTestFile(t, cfs, "large.txt", parallelTestData(2<<20))
+	if n := firstMember("large.txt.gz"); n != 256<<10 {
+		t.Errorf("First member holds %d bytes, expected one %d byte chunk", n, 256<<10)
+	}
+	f, info, err := cfs.OpenWithInfo("large.txt")
+	if err != nil {
+		t.Fatalf("OpenWithInfo failed: %v", err)
+	}
+	f.Close()
+	if info.OriginalSize != 2<<20 {
+		t.Errorf("OriginalSize = %d, expected the summed member sizes", info.OriginalSize)
+	}
+
+	writeTestFile(t, cfs, "small.txt", parallelTestData(512<<10))
+	if n := firstMember("small.txt.gz"); n != 512<<10 {
+		t.Errorf("Small file first member holds %d bytes, expected the whole file", n)
+	}
+}
@@ -0,0 +1,182 @@
+package compressfs
+
+import (
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// codecKey identifies interchangeable pooled encoders
+type codecKey struct {
+	algo  Algorithm
+	level int
+}
+
+// codecPool keeps closed encoders, zstd decoders without a dictionary and
+// snappy decoders for reuse by later files. Those are the decoders whose
+// construction is costly, for zstd, or allocates large buffers, for the
+// snappy framed reader, and that can be reset onto any input.
+type codecPool struct {
+	mu       sync.Mutex
+	encoders map[codecKey][]io.WriteCloser
+	decoders []*zstd.Decoder
+	snappy   []*snappy.Reader
+}
+
+// resettableEncoder is implemented by every encoder createCompressorWithDict
+// returns
+type resettableEncoder interface {
+	Reset(w io.Writer)
+}
+
+// getEncoder returns a pooled encoder for key reset to write to w, or nil
+func (p *codecPool) getEncoder(key codecKey, w io.Writer) io.WriteCloser {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	free := p.encoders[key]
+	if len(free) == 0 {
+		return nil
+	}
+	enc := free[len(free)-1]
+	p.encoders[key] = free[:len(free)-1]
+	enc.(resettableEncoder).Reset(w)
+	return enc
+}
+
+func (p *codecPool) putEncoder(key codecKey, enc io.WriteCloser) {
+	if _, ok := enc.(resettableEncoder); !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.encoders == nil {
+		p.encoders = make(map[codecKey][]io.WriteCloser)
+	}
+	p.encoders[key] = append(p.encoders[key], enc)
+}
+
+// getDecoder returns a pooled zstd decoder reset to read from r, or nil
+func (p *codecPool) getDecoder(r io.Reader) *zstd.Decoder {
+	p.mu.Lock()
+	if len(p.decoders) == 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	dec := p.decoders[len(p.decoders)-1]
+	p.decoders = p.decoders[:len(p.decoders)-1]
+	p.mu.Unlock()
+
+	if err := dec.Reset(r); err != nil {
+		dec.Close()
+		return nil
+	}
+	return dec
+}
+
+func (p *codecPool) putDecoder(dec *zstd.Decoder) {
+	dec.Reset(nil)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.decoders = append(p.decoders, dec)
+}
+
+// getSnappyDecoder returns a pooled snappy decoder reset to read from r, or nil
+func (p *codecPool) getSnappyDecoder(r io.Reader) *snappy.Reader {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.snappy) == 0 {
+		return nil
+	}
+	dec := p.snappy[len(p.snappy)-1]
+	p.snappy = p.snappy[:len(p.snappy)-1]
+	dec.Reset(r)
+	return dec
+}
+
+func (p *codecPool) putSnappyDecoder(dec *snappy.Reader) {
+	dec.Reset(nil)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.snappy = append(p.snappy, dec)
+}
+
+// pooledEncoder returns its encoder to the pool once closed successfully
+type pooledEncoder struct {
+	io.WriteCloser
+	pool   *codecPool
+	key    codecKey
+	closed bool
+}
+
+func (e *pooledEncoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	if err := e.WriteCloser.Close(); err != nil {
+		return err
+	}
+	e.pool.putEncoder(e.key, e.WriteCloser)
+	return nil
+}
+
+// Flush flushes the codec's writer, which every algorithm but xz supports
+func (e *pooledEncoder) Flush() error {
+	return flushEncoder(e.WriteCloser)
+}
+
+// unwrapEncoder returns the codec's own writer behind a pooled encoder
+func unwrapEncoder(w io.WriteCloser) io.WriteCloser {
+	if p, ok := w.(*pooledEncoder); ok {
+		return p.WriteCloser
+	}
+	return w
+}
+
+// Warmup creates and pools n encoders for the configured algorithm and
+// level, and n decoders when that algorithm is snappy or zstd without a
+// dictionary, so the first files opened do not pay for codec construction
+func (cfs *FS) Warmup(n int) error {
+	cfs.mu.RLock()
+	algo, level := cfs.config.Algorithm, cfs.config.Level
+	cfs.mu.RUnlock()
+
+	// Hold all n before returning any, so each is a distinct codec
+	encoders := make([]io.WriteCloser, 0, n)
+	defer func() {
+		for _, enc := range encoders {
+			enc.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		enc, err := cfs.newCompressor(algo, level, io.Discard)
+		if err != nil {
+			return err
+		}
+		encoders = append(encoders, enc)
+	}
+
+	if algo != AlgorithmSnappy && (algo != AlgorithmZstd || len(cfs.config.ZstdDictionary) > 0) {
+		return nil
+	}
+	decoders := make([]io.ReadCloser, 0, n)
+	defer func() {
+		for _, dec := range decoders {
+			dec.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		dec, err := cfs.newDecompressor(algo, nil, nil)
+		if err != nil {
+			return err
+		}
+		decoders = append(decoders, dec)
+	}
+	return nil
+}
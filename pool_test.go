@@ -0,0 +1,91 @@
+package compressfs
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestWarmup tests that files opened after Warmup reuse pooled codecs
+func TestWarmup(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmZstd,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	if err := cfs.Warmup(2); err != nil {
+		t.Fatalf("Warmup failed: %v", err)
+	}
+	warm := cfs.GetStats()
+	if warm.EncodersCreated != 2 || warm.DecodersCreated != 2 {
+		t.Fatalf("Expected 2 encoders and decoders after warmup, got %d and %d", warm.EncodersCreated, warm.DecodersCreated)
+	}
+
+	data := bytes.Repeat([]byte("warm codec "), 1000)
+	for i := 0; i < 3; i++ {
+		writeTestFile(t, cfs, "warm.txt", data)
+		if got := readTestFile(t, cfs, "warm.txt"); !bytes.Equal(got, data) {
+			t.Fatal("Content did not round-trip through pooled codecs")
+		}
+	}
+
+	stats := cfs.GetStats()
+	if stats.EncodersCreated != warm.EncodersCreated {
+		t.Errorf("Expected no new encoders after warmup, got %d", stats.EncodersCreated-warm.EncodersCreated)
+	}
+	if stats.DecodersCreated != warm.DecodersCreated {
+		t.Errorf("Expected no new decoders after warmup, got %d", stats.DecodersCreated-warm.DecodersCreated)
+	}
+}
+
+// TestPooledEncoderRoundTrip tests every algorithm through reused encoders
+func TestPooledEncoderRoundTrip(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy} {
+		cfs, err := New(NewMemFS(), &Config{
+			Algorithm:         algo,
+			PreserveExtension: true,
+			StripExtension:    true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+
+		for i := 0; i < 3; i++ {
+			data := bytes.Repeat([]byte{byte('a' + i), ' ', 'x'}, 2000*(i+1))
+			writeTestFile(t, cfs, "reuse.txt", data)
+			if got := readTestFile(t, cfs, "reuse.txt"); !bytes.Equal(got, data) {
+				t.Errorf("%s: write %d did not round-trip", algo, i)
+			}
+		}
+		if n := cfs.GetStats().EncodersCreated; n != 1 {
+			t.Errorf("%s: expected one encoder reused across writes, got %d", algo, n)
+		}
+	}
+}
+
+// TestPooledSnappyDecoder tests that snappy reads reuse one pooled decoder
+func TestPooledSnappyDecoder(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmSnappy,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i), ' ', 'y'}, 1000*(i+1))
+		writeTestFile(t, cfs, "small.txt", data)
+		if got := readTestFile(t, cfs, "small.txt"); !bytes.Equal(got, data) {
+			t.Fatalf("Read %d did not round-trip through a reset decoder", i)
+		}
+	}
+	if created := cfs.GetStats().DecodersCreated; created != 1 {
+		t.Errorf("Expected 1 snappy decoder for sequential reads, got %d", created)
+	}
+}
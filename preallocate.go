@@ -0,0 +1,39 @@
+package compressfs
+
+import (
+	"io"
+	"os"
+)
+
+// preallocate extends the base file to the estimated stored size of the
+// buffered data as compression starts, under Config.Preallocate. A base
+// whose Truncate fails is written without preallocation, and appends are
+// never preallocated since their writes land after the extended size.
+func (cf *compressedFile) preallocate(algo Algorithm, level int) {
+	if !cf.cfs.config.Preallocate || cf.flag&os.O_APPEND != 0 {
+		return
+	}
+	data := cf.writeBuffer.Bytes()
+	estimate, err := cf.cfs.estimateStoredSize(algo, level, data[:min(len(data), estimateSampleSize)], int64(len(data)))
+	if err != nil || estimate <= 0 {
+		return
+	}
+	start, err := cf.base.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	cf.preallocated = cf.base.Truncate(start+estimate) == nil
+}
+
+// trimPreallocated truncates a preallocated base file to the end of the
+// stored stream, dropping whatever of the estimate was not written over
+func (cf *compressedFile) trimPreallocated() error {
+	if !cf.preallocated {
+		return nil
+	}
+	end, err := cf.base.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return cf.base.Truncate(end)
+}
@@ -0,0 +1,82 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/absfs/absfs"
+)
+
+// truncateFiler records the sizes write handles are truncated to, and can
+// fail every Truncate like a base without one
+type truncateFiler struct {
+	absfs.Filer
+	sizes []int64
+	fail  bool
+}
+
+func (tf *truncateFiler) OpenFile(name string, flag int, perm fs.FileMode) (absfs.File, error) {
+	f, err := tf.Filer.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &truncateFile{File: f, filer: tf}, nil
+}
+
+type truncateFile struct {
+	absfs.File
+	filer *truncateFiler
+}
+
+func (f *truncateFile) Truncate(size int64) error {
+	if f.filer.fail {
+		return errors.ErrUnsupported
+	}
+	f.filer.sizes = append(f.filer.sizes, size)
+	return f.File.Truncate(size)
+}
+
+// TestPreallocate tests that preallocated writes store exactly the bytes an
+// ordinary write does, and that a base without Truncate is written as usual
+func TestPreallocate(t *testing.T) {
+	data := cdcTestData(512 << 10)
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmSnappy} {
+		t.Run(string(algo), func(t *testing.T) {
+			config := &Config{Algorithm: algo, PreserveExtension: true, StripExtension: true, Deterministic: true}
+			plain := NewMemFS()
+			cfs, err := New(plain, config)
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+			writeTestFile(t, cfs, "data.txt", data)
+			want := readBaseFile(t, plain, "data.txt"+GetExtension(algo))
+
+			for _, fail := range []bool{false, true} {
+				base := &truncateFiler{Filer: NewMemFS(), fail: fail}
+				preallocConfig := *config
+				preallocConfig.Preallocate = true
+				pfs, err := New(base, &preallocConfig)
+				if err != nil {
+					t.Fatalf("Failed to create compressfs: %v", err)
+				}
+				writeTestFile(t, pfs, "data.txt", data)
+
+				if !fail && (len(base.sizes) != 2 || base.sizes[0] <= 0) {
+					t.Errorf("Truncated to %v, expected the estimate then the exact size", base.sizes)
+				}
+				got := readBaseFile(t, base, "data.txt"+GetExtension(algo))
+				if !bytes.Equal(got, want) {
+					t.Errorf("Stored %d bytes (Truncate failing: %v), expected the %d of an ordinary write", len(got), fail, len(want))
+				}
+				if len(base.sizes) == 2 && base.sizes[1] != int64(len(want)) {
+					t.Errorf("Truncated to %d at close, expected %d", base.sizes[1], len(want))
+				}
+				if got := readTestFile(t, pfs, "data.txt"); !bytes.Equal(got, data) {
+					t.Error("Preallocated file does not read back")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// sequentialReadAt serves ReadAt on a compressed stream that cannot seek,
+// under Config.AllowSequentialReadAt. It decodes with its own decoder, so
+// the Read position is unaffected, and skips forward from where the last
+// call stopped; reading behind it starts the decoder over from the start.
+func (cf *compressedFile) sequentialReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("compressfs: negative offset")
+	}
+
+	if cf.readAtDecoder == nil || off < cf.readAtPos {
+		if cf.readAtDecoder != nil {
+			cf.readAtDecoder.Close()
+			cf.readAtDecoder = nil
+		}
+		decoder, err := cf.openStreamDecoder()
+		if err != nil {
+			return 0, err
+		}
+		cf.readAtDecoder, cf.readAtPos = decoder, 0
+	}
+
+	if skip := off - cf.readAtPos; skip > 0 {
+		skipped, err := io.CopyN(io.Discard, cf.readAtDecoder, skip)
+		cf.readAtPos += skipped
+		if err != nil {
+			return 0, cf.readAtError(err)
+		}
+	}
+
+	n, err := io.ReadFull(cf.readAtDecoder, b)
+	cf.readAtPos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, cf.readAtError(err)
+}
+
+// readAtError drops the ReadAt decoder after a decoding error, so the next
+// call starts over instead of reading from a broken stream
+func (cf *compressedFile) readAtError(err error) error {
+	if err != nil && err != io.EOF {
+		cf.readAtDecoder.Close()
+		cf.readAtDecoder = nil
+	}
+	return err
+}
+
+// openStreamDecoder opens a decoder of the whole stored stream from its
+// start, read through ReadAt so the base file position is left alone
+func (cf *compressedFile) openStreamDecoder() (io.ReadCloser, error) {
+	info, err := cf.base.Stat()
+	if err != nil {
+		return nil, err
+	}
+	section := io.NewSectionReader(cf.base, 0, info.Size())
+
+	head := make([]byte, decodeProbeSize)
+	n, err := section.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	var decoder io.ReadCloser
+	switch cf.readAlgo {
+	case AlgorithmSnappy:
+		if _, framed := IsCompressed(head); !framed {
+			data, err := io.ReadAll(section)
+			if err != nil {
+				return nil, err
+			}
+			decoded, ok := decodeSnappyBlock(data)
+			if !ok {
+				return nil, ErrCorruptedData
+			}
+			decoder = io.NopCloser(bytes.NewReader(decoded))
+			break
+		}
+		decoder, err = cf.cfs.newDecompressor(cf.readAlgo, cf.bufferedInput(section), nil)
+	case AlgorithmZstd:
+		var dict []byte
+		if dict, err = cf.cfs.selectZstdDictionary(head); err == nil {
+			decoder, err = cf.cfs.newDecompressor(cf.readAlgo, cf.bufferedInput(section), dict)
+		}
+	default:
+		decoder, err = cf.cfs.newDecompressor(cf.readAlgo, cf.bufferedInput(section), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Restored line endings are part of what Read returns
+	if _, ok := cf.decompressor.(*crlfReader); ok {
+		decoder = newCRLFReader(decoder)
+	}
+	return decoder, nil
+}
@@ -0,0 +1,101 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// TestSequentialReadAt tests forward and backward ReadAt on compressed
+// files under AllowSequentialReadAt, and that the Read position is left
+// alone
+func TestSequentialReadAt(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4} {
+		t.Run(string(algo), func(t *testing.T) {
+			cfs, err := New(NewMemFS(), &Config{
+				Algorithm:             algo,
+				PreserveExtension:     true,
+				StripExtension:        true,
+				AllowSequentialReadAt: true,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create compressfs: %v", err)
+			}
+			data := cdcTestData(1 << 20)
+			writeTestFile(t, cfs, "data.txt", data)
+
+			f, err := cfs.Open("data.txt")
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer f.Close()
+			cf := f.(*compressedFile)
+
+			readAt := func(off int64, size int) {
+				t.Helper()
+				buf := make([]byte, size)
+				n, err := f.ReadAt(buf, off)
+				if err != nil {
+					t.Fatalf("ReadAt(%d) failed: %v", off, err)
+				}
+				if !bytes.Equal(buf[:n], data[off:off+int64(size)]) {
+					t.Fatalf("ReadAt(%d) returned the wrong bytes", off)
+				}
+			}
+
+			// Forward calls continue with the same decoder
+			readAt(1000, 100)
+			decoder := cf.readAtDecoder
+			readAt(5000, 4096)
+			readAt(9096, 10)
+			readAt(700000, 32<<10)
+			if cf.readAtDecoder != decoder {
+				t.Error("Forward ReadAt restarted the decoder")
+			}
+
+			// Reading behind the last call starts over
+			readAt(0, 512)
+			if cf.readAtDecoder == decoder {
+				t.Error("Backward ReadAt did not restart the decoder")
+			}
+
+			// Past the end returns what is there with io.EOF
+			tail := make([]byte, 100)
+			n, err := f.ReadAt(tail, int64(len(data)-40))
+			if n != 40 || err != io.EOF {
+				t.Errorf("ReadAt at the end = %d, %v, expected 40, EOF", n, err)
+			}
+			if !bytes.Equal(tail[:n], data[len(data)-40:]) {
+				t.Error("ReadAt at the end returned the wrong bytes")
+			}
+
+			head := make([]byte, 256)
+			if _, err := io.ReadFull(f, head); err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if !bytes.Equal(head, data[:256]) {
+				t.Error("ReadAt moved the Read position")
+			}
+		})
+	}
+}
+
+// TestSequentialReadAtDisabled tests that ReadAt on a compressed file still
+// fails by default
+func TestSequentialReadAtDisabled(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, cfs, "data.txt", cdcTestData(64<<10))
+
+	f, err := cfs.Open("data.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.ReadAt(make([]byte, 10), 100); !errors.Is(err, ErrSeekNotSupported) {
+		t.Errorf("ReadAt = %v, expected ErrSeekNotSupported", err)
+	}
+}
@@ -0,0 +1,178 @@
+package compressfs
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"os"
+)
+
+// recompressSuffix marks the temporary copy written while a file is
+// migrated to Config.RecompressionTarget
+const recompressSuffix = ".recompress"
+
+// recompress migrates the stored file for the logical name to
+// Config.RecompressionTarget when AllowRecompression is set and it was
+// written with another algorithm. It returns the new stored name, or false
+// when the file was left as it is. Failures are counted in
+// Stats.RecompressionsFailed and leave the original in place.
+func (cfs *FS) recompress(name, stored string, algo Algorithm, config *Config) (string, bool) {
+	target := config.RecompressionTarget
+	if !config.AllowRecompression || algo == target || GetExtension(target) == "" {
+		return "", false
+	}
+	migrated := cfs.placeExtension(name, target, config.PreserveExtension)
+	if migrated == stored {
+		return "", false
+	}
+
+	// Migrations run one at a time, so a concurrent reader of the same
+	// file finds it already moved instead of racing to move it
+	cfs.recompressMu.Lock()
+	defer cfs.recompressMu.Unlock()
+	if _, err := cfs.base.Stat(stored); err != nil {
+		if _, err := cfs.base.Stat(migrated); err == nil {
+			return migrated, true
+		}
+		return "", false
+	}
+
+	ok, err := cfs.migrate(stored, migrated, target)
+	if err != nil {
+		cfs.incrementStat(&cfs.stats.RecompressionsFailed)
+		return "", false
+	}
+	if !ok {
+		return "", false
+	}
+	cfs.incrementStat(&cfs.stats.FilesRecompressed)
+	return migrated, true
+}
+
+// migrate rewrites stored as migrated compressed with target. The new copy
+// is complete under a temporary name before it is renamed into place and
+// the original removed. It reports false without error when stored is not
+// actually compressed, or already compressed with target.
+func (cfs *FS) migrate(stored, migrated string, target Algorithm) (bool, error) {
+	base, err := cfs.base.OpenFile(stored, os.O_RDONLY, 0)
+	if err != nil {
+		return false, err
+	}
+	defer base.Close()
+	src, footers := cfs.withFooters(base, stored)
+
+	info, err := src.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	head := make([]byte, decodeProbeSize)
+	n, err := src.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	head = head[:n]
+
+	algo, compressed := cfs.headAlgorithm(stored, head)
+	if !compressed || algo == target {
+		return false, nil
+	}
+
+	var dict []byte
+	if algo == AlgorithmZstd {
+		if dict, err = cfs.selectZstdDictionary(head); err != nil {
+			return false, err
+		}
+	}
+	decoder, err := cfs.newDecompressor(algo, src, dict)
+	if err != nil {
+		return false, err
+	}
+	defer decoder.Close()
+
+	tmp := migrated + recompressSuffix
+	if err := cfs.writeRecompressed(tmp, decoder, target, info, footers); err != nil {
+		cfs.base.Remove(tmp)
+		return false, err
+	}
+	if err := cfs.base.Rename(tmp, migrated); err != nil {
+		cfs.base.Remove(tmp)
+		return false, err
+	}
+	if err := cfs.base.Remove(stored); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeRecompressed compresses everything read from r with target into a
+// new file at name, synced before it is closed, with the footers the FS is
+// configured to write. Footers of the original that describe its content
+// rather than its encoding, such as the CRLF marker, a content hash or
+// metadata, are carried over; info describes the original.
+func (cfs *FS) writeRecompressed(name string, r io.Reader, target Algorithm, info os.FileInfo, footers storedFooters) error {
+	dst, err := cfs.base.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	algo, level := cfs.Codec()
+	if algo != target {
+		level = cfs.getDefaultLevel(target)
+	}
+	var encoder io.WriteCloser
+	if cfs.useContentChunking(target) {
+		encoder = cfs.newChunkingCompressor(target, level, dst)
+	} else if encoder, err = cfs.newCompressor(target, level, dst); err != nil {
+		dst.Close()
+		return err
+	}
+
+	// The stored bytes were converted to LF, so a hash of what was written
+	// can only be recomputed for files that were not
+	config := cfs.config
+	var hasher hash.Hash
+	if config.StoreContentHash && footers.hash == nil && !footers.crlf {
+		hasher = newContentHasher(config.ContentHashAlgorithm)
+		r = io.TeeReader(r, hasher)
+	}
+
+	buf := make([]byte, copyBufferSize)
+	size, err := io.CopyBuffer(encoder, onlyReader{r}, buf)
+	if err != nil {
+		encoder.Close()
+		dst.Close()
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+
+	var trailer []byte
+	if footers.crlf {
+		trailer = append(trailer, crlfFooter...)
+	}
+	if config.ReportUncompressedSize && !cfs.recordsSize(target) {
+		trailer = append(trailer, encodeSizeFooter(size)...)
+	}
+	if footers.hash != nil {
+		trailer = append(trailer, encodeHashFooter(footers.hash.algo, footers.hash.sum)...)
+	} else if hasher != nil {
+		trailer = append(trailer, encodeHashFooter(config.ContentHashAlgorithm, hasher.Sum(nil))...)
+	}
+	if footers.meta != nil {
+		trailer = append(trailer, footers.meta.encode()...)
+	} else if config.EmbedFileMetadata {
+		trailer = append(trailer, fileMetadata{mode: info.Mode(), modTime: info.ModTime()}.encode()...)
+	}
+	if _, err := dst.Write(trailer); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) && !errors.Is(err, errors.ErrUnsupported) {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
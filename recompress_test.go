@@ -0,0 +1,164 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/absfs/absfs"
+)
+
+// failingRenameFiler fails every Rename, as a crash between writing the new
+// copy and moving it into place would
+type failingRenameFiler struct {
+	absfs.Filer
+}
+
+func (f *failingRenameFiler) Rename(oldpath, newpath string) error {
+	return errors.New("rename failed")
+}
+
+// TestRecompression tests that reading a gzip file with AllowRecompression
+// migrates it to the target algorithm
+func TestRecompression(t *testing.T) {
+	base := NewMemFS()
+	gz, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	data := bytes.Repeat([]byte("migrate me to zstd "), 500)
+	writeTestFile(t, gz, "data.txt", data)
+
+	cfs, err := New(base, &Config{
+		Algorithm:           AlgorithmZstd,
+		PreserveExtension:   true,
+		StripExtension:      true,
+		AllowRecompression:  true,
+		RecompressionTarget: AlgorithmZstd,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	f, err := cfs.Open("data.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if algo := f.(*compressedFile).Algorithm(); algo != AlgorithmZstd {
+		t.Errorf("Read served as %s, expected the migrated zstd copy", algo)
+	}
+	f.Close()
+
+	if got := readTestFile(t, cfs, "data.txt"); !bytes.Equal(got, data) {
+		t.Error("Migrated file did not round-trip")
+	}
+	if _, err := base.Stat("data.txt.gz"); err == nil {
+		t.Error("Expected the gzip original to be removed")
+	}
+	if _, err := base.Stat("data.txt.zst"); err != nil {
+		t.Errorf("Expected data.txt.zst: %v", err)
+	}
+	if stats := cfs.GetStats(); stats.FilesRecompressed != 1 || stats.RecompressionsFailed != 0 {
+		t.Errorf("FilesRecompressed = %d, RecompressionsFailed = %d, expected 1 and 0",
+			stats.FilesRecompressed, stats.RecompressionsFailed)
+	}
+}
+
+// TestRecompressionFailure tests that an interrupted migration leaves the
+// original intact and readable
+func TestRecompressionFailure(t *testing.T) {
+	mem := NewMemFS()
+	gz, err := New(mem, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	data := bytes.Repeat([]byte("keep the original "), 500)
+	writeTestFile(t, gz, "data.txt", data)
+
+	cfs, err := New(&failingRenameFiler{Filer: mem}, &Config{
+		Algorithm:           AlgorithmZstd,
+		PreserveExtension:   true,
+		StripExtension:      true,
+		AllowRecompression:  true,
+		RecompressionTarget: AlgorithmZstd,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	if got := readTestFile(t, cfs, "data.txt"); !bytes.Equal(got, data) {
+		t.Error("Original was not served after a failed migration")
+	}
+	if _, err := mem.Stat("data.txt.gz"); err != nil {
+		t.Errorf("Expected the gzip original to remain: %v", err)
+	}
+	for _, name := range []string{"data.txt.zst", "data.txt.zst" + recompressSuffix} {
+		if _, err := mem.Stat(name); err == nil {
+			t.Errorf("Expected no %s after a failed migration", name)
+		}
+	}
+	if failed := cfs.GetStats().RecompressionsFailed; failed != 1 {
+		t.Errorf("RecompressionsFailed = %d, expected 1", failed)
+	}
+}
+
+// TestRecompressionFooters tests that a file with footers migrates, and that
+// the migrated copy carries its hash and metadata and the footers the FS is
+// configured to write
+func TestRecompressionFooters(t *testing.T) {
+	base := NewMemFS()
+	gz, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		StoreContentHash:  true,
+		EmbedFileMetadata: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	data := bytes.Repeat([]byte("migrate me with my footers "), 500)
+	writeTestFile(t, gz, "data.txt", data)
+	want, err := gz.ContentHash("data.txt")
+	if err != nil {
+		t.Fatalf("ContentHash failed: %v", err)
+	}
+
+	cfs, err := New(base, &Config{
+		Algorithm:              AlgorithmZstd,
+		PreserveExtension:      true,
+		StripExtension:         true,
+		AllowRecompression:     true,
+		RecompressionTarget:    AlgorithmZstd,
+		StoreContentHash:       true,
+		EmbedFileMetadata:      true,
+		ReportUncompressedSize: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	if got := readTestFile(t, cfs, "data.txt"); !bytes.Equal(got, data) {
+		t.Error("Migrated file did not round-trip")
+	}
+	if stats := cfs.GetStats(); stats.FilesRecompressed != 1 {
+		t.Fatalf("FilesRecompressed = %d, expected 1", stats.FilesRecompressed)
+	}
+
+	stored := readBaseFile(t, base, "data.txt.zst")
+	if !bytes.HasSuffix(stored, []byte(metadataMagic)) {
+		t.Error("Migrated copy has no metadata footer")
+	}
+	if got, err := cfs.ContentHash("data.txt"); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("ContentHash of the migrated copy = %x, %v, expected %x", got, err, want)
+	}
+	if ok, err := cfs.VerifyContentHash("data.txt"); err != nil || !ok {
+		t.Errorf("VerifyContentHash = %v, %v, expected true", ok, err)
+	}
+	info, err := cfs.Stat("data.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() != int64(len(data)) {
+		t.Errorf("Stat size = %d, expected %d from the size footer", info.Size(), len(data))
+	}
+}
@@ -0,0 +1,125 @@
+package compressfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/absfs/absfs"
+)
+
+// replacePrefix marks the temporary file a Replace writes before moving it
+// into place. It prefixes the base name, so the name keeps its extension
+// for AlgorithmRules, SkipPatterns and TextPatterns.
+const replacePrefix = ".replace-"
+
+// Replace atomically replaces the contents of the named file with data.
+// The data is written through the filesystem to a temporary name, with the
+// codec, skip and size decisions a write of name would make, synced, and
+// renamed over the stored file, so a concurrent reader sees either the old
+// or the new contents in full. When the new stored name differs from the
+// old one, as after an algorithm change, the stale variant is removed once
+// the new one is in place.
+func (cfs *FS) Replace(name string, data []byte) error {
+	cfs.mu.RLock()
+	config := cfs.config
+	cfs.mu.RUnlock()
+
+	name = cleanPath(name)
+	dir, file := path.Split(name)
+	tmp := dir + replacePrefix + file
+
+	cfs.replaceMu.Lock()
+	defer cfs.replaceMu.Unlock()
+
+	perm := os.FileMode(0666)
+	if info, err := cfs.Stat(name); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	// Clear what an interrupted Replace may have left behind
+	for _, leftover := range append(cfs.compressedVariants(tmp, config), tmp) {
+		cfs.base.Remove(leftover)
+	}
+
+	stored, err := cfs.writeDurable(tmp, data, perm)
+	if err != nil {
+		for _, partial := range append(cfs.compressedVariants(tmp, config), tmp) {
+			cfs.base.Remove(partial)
+		}
+		return err
+	}
+	return cfs.moveIntoPlace(name, tmp, stored, config)
+}
+
+// moveIntoPlace renames stored, where the temporary logical name tmp was
+// written, over the stored file of name under the same extension, and then
+// removes any other variant a read of name could still resolve to
+func (cfs *FS) moveIntoPlace(name, tmp, stored string, config *Config) error {
+	target := name
+	if stored != tmp {
+		if _, algo, ok := cfs.stripPlacedExtension(stored); ok {
+			target = cfs.placeExtension(name, algo, config.PreserveExtension)
+		}
+	}
+	if err := cfs.base.Rename(stored, target); err != nil {
+		cfs.base.Remove(stored)
+		return err
+	}
+
+	// The new contents are in place: drop variants a read could still
+	// resolve to instead
+	stale := cfs.compressedVariants(name, config)
+	if config.StripExtension {
+		if _, err := cfs.base.Stat(name); err == nil {
+			stale = append(stale, name)
+		}
+	}
+	for _, variant := range stale {
+		if variant != target {
+			if err := cfs.base.Remove(variant); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeDurable writes data to the logical name through the compressed file
+// wrapper, synced and finished before it returns, and returns the name it
+// was stored under
+func (cfs *FS) writeDurable(name string, data []byte, perm os.FileMode) (string, error) {
+	f, err := cfs.openFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, func(actualName string, flag int) (absfs.File, error) {
+		return cfs.base.OpenFile(actualName, flag, perm)
+	}, true, nil)
+	if err != nil {
+		return "", err
+	}
+	cf, ok := f.(*compressedFile)
+	if ok {
+		cf.durable = true
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if !ok {
+		return name, nil
+	}
+
+	// A raw write moves to the logical name on Close and a TrySmallest
+	// winner to its own extension
+	for _, candidate := range []string{cf.renameTo, cf.compressedName, cf.originalName} {
+		if candidate == "" {
+			continue
+		}
+		if _, err := cfs.base.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", &os.PathError{Op: "replace", Path: name, Err: os.ErrNotExist}
+}
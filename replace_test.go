@@ -0,0 +1,107 @@
+package compressfs
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestReplace tests that concurrent readers of a file being replaced see
+// only complete versions of it
+func TestReplace(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	versions := make([][]byte, 20)
+	known := make(map[string]bool, len(versions))
+	for i := range versions {
+		versions[i] = append([]byte(fmt.Sprintf("version = %d\n", i)), cdcTestData(256<<10)...)
+		known[string(versions[i])] = true
+	}
+	writeTestFile(t, cfs, "app.conf", versions[0])
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				got, err := cfs.ReadFile("app.conf")
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !known[string(got)] {
+					errs <- fmt.Errorf("read a partial state of %d bytes", len(got))
+					return
+				}
+			}
+		}()
+	}
+
+	for _, v := range versions[1:] {
+		if err := cfs.Replace("app.conf", v); err != nil {
+			t.Fatalf("Replace failed: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := readTestFile(t, cfs, "app.conf"); !bytes.Equal(got, versions[len(versions)-1]) {
+		t.Error("File does not hold the last replacement")
+	}
+	entries, err := base.ReadDir(".")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "app.conf.gz" {
+		for _, e := range entries {
+			t.Logf("Base holds %s", e.Name())
+		}
+		t.Error("Expected only app.conf.gz in the base filesystem")
+	}
+}
+
+// TestReplaceAlgorithmChange tests that replacing a file under a new
+// algorithm removes the variant stored with the old one
+func TestReplaceAlgorithmChange(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, cfs, "app.conf", []byte("old contents\n"))
+
+	if err := cfs.SetAlgorithm(AlgorithmZstd); err != nil {
+		t.Fatalf("SetAlgorithm failed: %v", err)
+	}
+	data := []byte("new contents\n")
+	if err := cfs.Replace("app.conf", data); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+
+	if got := readTestFile(t, cfs, "app.conf"); !bytes.Equal(got, data) {
+		t.Errorf("Read %q after Replace, expected %q", got, data)
+	}
+	if _, err := base.Stat("app.conf.zst"); err != nil {
+		t.Errorf("Expected app.conf.zst: %v", err)
+	}
+	if _, err := base.Stat("app.conf.gz"); err == nil {
+		t.Error("Stale app.conf.gz left behind")
+	}
+}
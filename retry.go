@@ -0,0 +1,155 @@
+package compressfs
+
+import (
+	"io"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// RetryPolicy controls retries of transient base filesystem errors, such as
+// timeouts on network-backed filesystems.
+//
+// Reads and writes are only retried when the failed call transferred no
+// bytes, so a retry never repeats or skips data mid-stream. Opens with
+// O_EXCL are not retried, since a failed attempt may have created the file.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts int // default: 1 (no retries)
+
+	// Backoff is the delay before the first retry; it doubles on each attempt
+	Backoff time.Duration
+
+	// Retryable reports whether err is transient. Errors are not retried
+	// when it is nil.
+	Retryable func(err error) bool
+}
+
+// do runs op until it succeeds, returns a non-retryable error, or attempts
+// are exhausted
+func (p *RetryPolicy) do(op func() error) error {
+	delay := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !p.shouldRetry(err, attempt) {
+			return err
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+// shouldRetry reports whether a failed attempt may be retried
+func (p *RetryPolicy) shouldRetry(err error, attempt int) bool {
+	return p != nil && p.Retryable != nil && attempt < p.MaxAttempts && p.Retryable(err)
+}
+
+// openBase opens a file on the base filesystem with open, retrying per the
+// policy when retry is set. Read and Write on the result are retried too.
+func openBase(open func() (absfs.File, error), retry bool, policy *RetryPolicy) (absfs.File, error) {
+	if policy == nil {
+		return open()
+	}
+
+	var f absfs.File
+	op := func() error {
+		var err error
+		f, err = open()
+		return err
+	}
+	if !retry {
+		if err := op(); err != nil {
+			return nil, err
+		}
+	} else if err := policy.do(op); err != nil {
+		return nil, err
+	}
+	return &retryFile{File: f, policy: policy}, nil
+}
+
+// retryFile retries Read and Write calls on a base file that fail without
+// transferring any bytes
+type retryFile struct {
+	absfs.File
+	policy *RetryPolicy
+}
+
+func (f *retryFile) Read(p []byte) (n int, err error) {
+	f.policy.do(func() error {
+		n, err = f.File.Read(p)
+		if n > 0 || err == io.EOF {
+			return nil
+		}
+		return err
+	})
+	return n, err
+}
+
+func (f *retryFile) Write(p []byte) (n int, err error) {
+	f.policy.do(func() error {
+		n, err = f.File.Write(p)
+		if n > 0 {
+			return nil
+		}
+		return err
+	})
+	return n, err
+}
+
+// eofRetryFile re-reads up to transientEOFAttempts times after an EOF that
+// falls short of the stored size, waiting transientEOFBackoff before the
+// first re-read and doubling it on each attempt
+const (
+	transientEOFAttempts = 3
+	transientEOFBackoff  = 10 * time.Millisecond
+)
+
+// eofRetryFile re-reads a base file that reports EOF before the end of its
+// stored size, so a decoder does not fail on a premature end of stream when
+// the rest of the data arrives on a later read
+type eofRetryFile struct {
+	absfs.File
+	pos int64
+}
+
+// short reports whether pos falls before the end of the stored size, so an
+// EOF there is transient
+func (f *eofRetryFile) short(pos int64) bool {
+	info, err := f.File.Stat()
+	return err == nil && pos < info.Size()
+}
+
+func (f *eofRetryFile) Read(p []byte) (n int, err error) {
+	n, err = f.File.Read(p)
+	delay := transientEOFBackoff
+	for attempt := 0; n == 0 && err == io.EOF && attempt < transientEOFAttempts && f.short(f.pos); attempt++ {
+		time.Sleep(delay)
+		delay *= 2
+		n, err = f.File.Read(p)
+	}
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *eofRetryFile) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = f.File.ReadAt(p, off)
+	delay := transientEOFBackoff
+	for attempt := 0; n < len(p) && err == io.EOF && attempt < transientEOFAttempts && f.short(off+int64(n)); attempt++ {
+		time.Sleep(delay)
+		delay *= 2
+		var m int
+		m, err = f.File.ReadAt(p[n:], off+int64(n))
+		n += m
+	}
+	return n, err
+}
+
+func (f *eofRetryFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.pos = pos
+	}
+	return pos, err
+}
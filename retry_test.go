@@ -0,0 +1,180 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+var errTransient = errors.New("transient timeout")
+
+// flakyFiler fails the first few OpenFile, Read and Write calls with a
+// transient error
+type flakyFiler struct {
+	absfs.Filer
+	failures   int
+	openFails  int
+	readFails  int
+	writeFails int
+}
+
+type flakyFile struct {
+	absfs.File
+	filer *flakyFiler
+}
+
+func (f *flakyFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	if f.openFails < f.failures {
+		f.openFails++
+		return nil, errTransient
+	}
+	file, err := f.Filer.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyFile{File: file, filer: f}, nil
+}
+
+func (f *flakyFile) Read(p []byte) (int, error) {
+	if f.filer.readFails < f.filer.failures {
+		f.filer.readFails++
+		return 0, errTransient
+	}
+	return f.File.Read(p)
+}
+
+func (f *flakyFile) Write(p []byte) (int, error) {
+	if f.filer.writeFails < f.filer.failures {
+		f.filer.writeFails++
+		return 0, errTransient
+	}
+	return f.File.Write(p)
+}
+
+// TestRetryPolicy tests that transient base errors are retried
+func TestRetryPolicy(t *testing.T) {
+	base := &flakyFiler{Filer: NewMemFS(), failures: 2}
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmZstd,
+		PreserveExtension: true,
+		StripExtension:    true,
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     time.Millisecond,
+			Retryable:   func(err error) bool { return errors.Is(err, errTransient) },
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("retried through flaky storage "), 200)
+	writeTestFile(t, cfs, "flaky.txt", data)
+
+	// Reset so the read path sees fresh failures
+	base.openFails, base.readFails = 0, 0
+	if got := readTestFile(t, cfs, "flaky.txt"); !bytes.Equal(got, data) {
+		t.Error("Content did not round-trip through retries")
+	}
+
+	// Exhausted attempts surface the error
+	base.failures, base.openFails = 5, 0
+	if _, err := cfs.Open("flaky.txt"); !errors.Is(err, errTransient) {
+		t.Errorf("Expected transient error after exhausting attempts, got %v", err)
+	}
+}
+
+// eofOnceFiler returns files that report EOF once after their first read,
+// before the rest of the data
+type eofOnceFiler struct {
+	absfs.Filer
+}
+
+type eofOnceFile struct {
+	absfs.File
+	reads int
+	cut   bool
+}
+
+func (e *eofOnceFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	f, err := e.Filer.OpenFile(name, flag, perm)
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return f, err
+	}
+	return &eofOnceFile{File: f}, nil
+}
+
+// ReadAt reports EOF once, halfway through the first read past 1KB
+func (f *eofOnceFile) ReadAt(p []byte, off int64) (int, error) {
+	if !f.cut && off+int64(len(p)) > 1024 {
+		f.cut = true
+		half := len(p) / 2
+		n, _ := f.File.ReadAt(p[:half], off)
+		return n, io.EOF
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func (f *eofOnceFile) Read(p []byte) (int, error) {
+	f.reads++
+	if f.reads == 3 {
+		return 0, io.EOF
+	}
+	if len(p) > 512 {
+		p = p[:512]
+	}
+	return f.File.Read(p)
+}
+
+// TestTolerateTransientEOF tests that an EOF before the end of the stored
+// file is re-read only with TolerateTransientEOF
+func TestTolerateTransientEOF(t *testing.T) {
+	mem := NewMemFS()
+	writer, err := New(mem, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	data := make([]byte, 64<<10)
+	for i := range data {
+		data[i] = byte(i * i >> 3)
+	}
+	writeTestFile(t, writer, "flaky.bin", data)
+
+	for _, tolerate := range []bool{false, true} {
+		cfs, err := New(&eofOnceFiler{Filer: mem}, &Config{
+			Algorithm:            AlgorithmGzip,
+			PreserveExtension:    true,
+			StripExtension:       true,
+			TolerateTransientEOF: tolerate,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+		got, err := cfs.ReadFile("flaky.bin")
+		if tolerate {
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("With TolerateTransientEOF: read %d bytes, err %v; expected the full content", len(got), err)
+			}
+		} else if err == nil && bytes.Equal(got, data) {
+			t.Error("Expected the transient EOF to truncate the read without TolerateTransientEOF")
+		}
+	}
+
+	// ReadAt is re-read from where the transient EOF cut it off
+	base, err := (&eofOnceFiler{Filer: mem}).OpenFile("flaky.bin.gz", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer base.Close()
+	stored := readBaseFile(t, mem, "flaky.bin.gz")
+	buf := make([]byte, len(stored)-100)
+	n, err := (&eofRetryFile{File: base}).ReadAt(buf, 100)
+	if err != nil || n != len(buf) || !bytes.Equal(buf, stored[100:100+len(buf)]) {
+		t.Errorf("ReadAt = %d, %v; expected %d bytes re-read past the transient EOF", n, err, len(buf))
+	}
+}
@@ -0,0 +1,320 @@
+package compressfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Seek table layout of the zstd seekable format: a skippable frame holding
+// one entry per frame, closed by a footer with the frame count and magic
+const (
+	seekTableFrameMagic = 0x184D2A5E
+	seekTableMagic      = 0x8F92EAB1
+	seekTableFooterSize = 9
+	seekTableHeaderSize = 8
+	seekEntrySize       = 8
+	seekChecksumFlag    = 0x80
+	seekReservedBits    = 0x7c
+)
+
+// seekFrame locates one independently decodable frame of a seekable file
+type seekFrame struct {
+	compressedOffset int64
+	compressedSize   int64
+	offset           int64 // Uncompressed offset of the frame's first byte
+	size             int64
+}
+
+// seekableWriter compresses input as independent zstd frames of chunkSize
+// bytes and appends a seek table on Close
+type seekableWriter struct {
+	w         io.Writer
+	enc       *zstd.Encoder
+	chunkSize int
+	buf       []byte
+	out       []byte
+	frames    []seekFrame
+	closed    bool
+}
+
+// newSeekableCompressor creates a zstd compressor writing the seekable
+// format to w. Frames are encoded whole, so the encoder is not pooled.
+func (cfs *FS) newSeekableCompressor(level, window int, w io.Writer) (io.WriteCloser, error) {
+	enc, err := createZstdCompressorWithOptions(nil, level, cfs.config.ZstdDictionary, cfs.zstdEncoderOptions(window))
+	if err != nil {
+		return nil, err
+	}
+	cfs.incrementStat(&cfs.stats.EncodersCreated)
+
+	chunkSize := cfs.config.ParallelChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultConfig().ParallelChunkSize
+	}
+	return &seekableWriter{w: w, enc: enc.(*zstd.Encoder), chunkSize: chunkSize}, nil
+}
+
+func (w *seekableWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		take := min(w.chunkSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:take]...)
+		p = p[take:]
+		written += take
+
+		if len(w.buf) == w.chunkSize {
+			if err := w.writeFrame(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeFrame compresses the buffered chunk as one frame
+func (w *seekableWriter) writeFrame() error {
+	w.out = w.enc.EncodeAll(w.buf, w.out[:0])
+	if _, err := w.w.Write(w.out); err != nil {
+		return err
+	}
+	w.frames = append(w.frames, seekFrame{compressedSize: int64(len(w.out)), size: int64(len(w.buf))})
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Flush ends the current frame early, so everything written so far is in
+// complete frames. The seek table is only written on Close.
+func (w *seekableWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	return w.writeFrame()
+}
+
+// Close writes the final partial frame and the seek table. An empty file
+// still gets one empty frame so it starts with the zstd magic.
+func (w *seekableWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if len(w.buf) > 0 || len(w.frames) == 0 {
+		if err := w.writeFrame(); err != nil {
+			return err
+		}
+	}
+
+	entries := len(w.frames) * seekEntrySize
+	table := make([]byte, seekTableHeaderSize+entries+seekTableFooterSize)
+	binary.LittleEndian.PutUint32(table[0:], seekTableFrameMagic)
+	binary.LittleEndian.PutUint32(table[4:], uint32(entries+seekTableFooterSize))
+	for i, f := range w.frames {
+		entry := table[seekTableHeaderSize+i*seekEntrySize:]
+		binary.LittleEndian.PutUint32(entry[0:], uint32(f.compressedSize))
+		binary.LittleEndian.PutUint32(entry[4:], uint32(f.size))
+	}
+	footer := table[seekTableHeaderSize+entries:]
+	binary.LittleEndian.PutUint32(footer[0:], uint32(len(w.frames)))
+	footer[4] = 0 // No per-frame checksums
+	binary.LittleEndian.PutUint32(footer[5:], seekTableMagic)
+
+	if _, err := w.w.Write(table); err != nil {
+		return err
+	}
+	return w.enc.Close()
+}
+
+// readSeekTable reads the seek table at the end of a stored zstd file. It
+// reports false for files that are not in the seekable format.
+func readSeekTable(r io.ReaderAt, size int64) ([]seekFrame, bool) {
+	if size < seekTableHeaderSize+seekTableFooterSize {
+		return nil, false
+	}
+
+	var footer [seekTableFooterSize]byte
+	if _, err := r.ReadAt(footer[:], size-seekTableFooterSize); err != nil {
+		return nil, false
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekTableMagic || footer[4]&seekReservedBits != 0 {
+		return nil, false
+	}
+
+	entrySize := int64(seekEntrySize)
+	if footer[4]&seekChecksumFlag != 0 {
+		entrySize += 4
+	}
+	count := int64(binary.LittleEndian.Uint32(footer[0:]))
+	tableSize := seekTableHeaderSize + count*entrySize + seekTableFooterSize
+	if tableSize > size {
+		return nil, false
+	}
+
+	table := make([]byte, tableSize-seekTableFooterSize)
+	if _, err := r.ReadAt(table, size-tableSize); err != nil {
+		return nil, false
+	}
+	if binary.LittleEndian.Uint32(table[0:]) != seekTableFrameMagic ||
+		int64(binary.LittleEndian.Uint32(table[4:])) != tableSize-seekTableHeaderSize {
+		return nil, false
+	}
+
+	frames := make([]seekFrame, count)
+	var compressedOffset, offset int64
+	for i := range frames {
+		entry := table[seekTableHeaderSize+int64(i)*entrySize:]
+		frames[i] = seekFrame{
+			compressedOffset: compressedOffset,
+			compressedSize:   int64(binary.LittleEndian.Uint32(entry[0:])),
+			offset:           offset,
+			size:             int64(binary.LittleEndian.Uint32(entry[4:])),
+		}
+		compressedOffset += frames[i].compressedSize
+		offset += frames[i].size
+	}
+	if compressedOffset != size-tableSize {
+		return nil, false
+	}
+	return frames, true
+}
+
+// seekableSize returns the decompressed size recorded by a seek table
+func seekableSize(frames []seekFrame) int64 {
+	if len(frames) == 0 {
+		return 0
+	}
+	last := frames[len(frames)-1]
+	return last.offset + last.size
+}
+
+// seekableReader serves reads of a seekable zstd file by decoding only the
+// frame holding the requested range, keeping the last one decoded
+type seekableReader struct {
+	r       io.ReaderAt
+	frames  []seekFrame
+	size    int64
+	decoder *zstdReadCloser
+	pos     int64
+
+	current    int // Index of the frame held in block, or -1
+	block      []byte
+	compressed []byte
+}
+
+// setupSeekable switches a zstd read to random access when the stored file
+// carries a seek table; other files keep the streaming decompressor
+func (cf *compressedFile) setupSeekable() {
+	z, ok := cf.decompressor.(*zstdReadCloser)
+	if !ok {
+		return
+	}
+	info, err := cf.base.Stat()
+	if err != nil {
+		return
+	}
+	frames, ok := readSeekTable(cf.base, info.Size())
+	if !ok {
+		return
+	}
+
+	// Frames are decoded whole from here on; stop the stream reading the base
+	z.Decoder.Reset(nil)
+	cf.decompressor = &seekableReader{
+		r:       cf.base,
+		frames:  frames,
+		size:    seekableSize(frames),
+		decoder: z,
+		current: -1,
+	}
+}
+
+func (s *seekableReader) Read(p []byte) (int, error) {
+	n, err := s.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (s *seekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("compressfs: negative offset")
+	}
+
+	n := 0
+	for len(p) > 0 && off < s.size {
+		i := sort.Search(len(s.frames), func(i int) bool {
+			return s.frames[i].offset+s.frames[i].size > off
+		})
+		if err := s.load(i); err != nil {
+			return n, err
+		}
+		copied := copy(p, s.block[off-s.frames[i].offset:])
+		n += copied
+		p = p[copied:]
+		off += int64(copied)
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// load decodes frame i into block unless it is already held there
+func (s *seekableReader) load(i int) error {
+	if s.current == i {
+		return nil
+	}
+	f := s.frames[i]
+
+	if int64(cap(s.compressed)) < f.compressedSize {
+		s.compressed = make([]byte, f.compressedSize)
+	}
+	s.compressed = s.compressed[:f.compressedSize]
+	if _, err := s.r.ReadAt(s.compressed, f.compressedOffset); err != nil {
+		return err
+	}
+
+	block, err := s.decoder.DecodeAll(s.compressed, s.block[:0])
+	if err != nil {
+		s.current = -1
+		return fmt.Errorf("%w: %v", ErrCorruptedData, err)
+	}
+	if int64(len(block)) != f.size {
+		s.current = -1
+		return fmt.Errorf("%w: frame %d decoded to %d bytes, seek table records %d", ErrCorruptedData, i, len(block), f.size)
+	}
+	s.block = block
+	s.current = i
+	return nil
+}
+
+// Seek sets the position of the next Read. Positions past the end are
+// allowed and read as EOF, as with os.File.
+func (s *seekableReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = s.pos + offset
+	case io.SeekEnd:
+		pos = s.size + offset
+	default:
+		return 0, errors.New("compressfs: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("compressfs: negative position")
+	}
+	s.pos = pos
+	return pos, nil
+}
+
+func (s *seekableReader) Close() error {
+	return s.decoder.Close()
+}
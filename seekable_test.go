@@ -0,0 +1,123 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// TestSeekable tests random access into zstd files written with Seekable
+func TestSeekable(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmZstd,
+		Level:             3,
+		PreserveExtension: true,
+		StripExtension:    true,
+		Seekable:          true,
+		ParallelChunkSize: 4096,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	var data []byte
+	for i := 0; len(data) < 50000; i++ {
+		data = append(data, fmt.Sprintf("line %06d of the seekable file\n", i)...)
+	}
+	writeTestFile(t, cfs, "seek.txt", data)
+
+	// Sequential reads and plain zstd decoders see the whole content
+	if got := readTestFile(t, cfs, "seek.txt"); !bytes.Equal(got, data) {
+		t.Fatal("Sequential read did not round-trip")
+	}
+	stored := readBaseFile(t, base, "seek.txt.zst")
+	if got, err := DecompressBytes(stored, AlgorithmZstd); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Plain zstd decode failed: %v", err)
+	}
+
+	f, err := cfs.Open("seek.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	readAt := func(whence int, offset, want int64) {
+		t.Helper()
+		pos, err := f.Seek(offset, whence)
+		if err != nil {
+			t.Fatalf("Seek(%d, %d) failed: %v", offset, whence, err)
+		}
+		if pos != want {
+			t.Fatalf("Seek(%d, %d) = %d, expected %d", offset, whence, pos, want)
+		}
+		buf := make([]byte, 100)
+		n, err := io.ReadFull(f, buf)
+		if err != nil {
+			t.Fatalf("Read at %d failed: %v", pos, err)
+		}
+		if !bytes.Equal(buf[:n], data[pos:pos+100]) {
+			t.Errorf("Read at %d returned wrong data", pos)
+		}
+	}
+	readAt(io.SeekStart, 30000, 30000)    // forward, several frames in
+	readAt(io.SeekCurrent, -20100, 10000) // backward across frames
+	readAt(io.SeekStart, 4050, 4050)      // spanning a frame boundary
+	readAt(io.SeekEnd, -100, int64(len(data)-100))
+
+	// Past EOF: the seek succeeds and reads report EOF
+	if _, err := f.Seek(int64(len(data))+10, io.SeekStart); err != nil {
+		t.Fatalf("Seek past EOF failed: %v", err)
+	}
+	if n, err := f.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("Read past EOF = %d, %v, expected 0, EOF", n, err)
+	}
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Expected error seeking to a negative position")
+	}
+
+	buf := make([]byte, 200)
+	if n, err := f.ReadAt(buf, 8000); err != nil || !bytes.Equal(buf[:n], data[8000:8200]) {
+		t.Errorf("ReadAt(8000) = %d, %v", n, err)
+	}
+	if n, err := f.ReadAt(buf, int64(len(data)-50)); n != 50 || err != io.EOF {
+		t.Errorf("ReadAt near EOF = %d, %v, expected 50, EOF", n, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() != int64(len(data)) {
+		t.Errorf("Stat size = %d, expected %d", info.Size(), len(data))
+	}
+}
+
+// TestSeekableNotWritten tests that zstd files without a seek table still
+// refuse random access
+func TestSeekableNotWritten(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmZstd,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, cfs, "plain.txt", bytes.Repeat([]byte("not seekable "), 1000))
+
+	f, err := cfs.Open("plain.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(10, io.SeekStart); !errors.Is(err, ErrSeekNotSupported) {
+		t.Errorf("Seek error = %v, expected ErrSeekNotSupported", err)
+	}
+	if _, err := f.ReadAt(make([]byte, 10), 10); !errors.Is(err, ErrSeekNotSupported) {
+		t.Errorf("ReadAt error = %v, expected ErrSeekNotSupported", err)
+	}
+}
@@ -0,0 +1,61 @@
+package compressfs
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// writeSmallest compresses the buffered file with each Config.TrySmallest
+// algorithm, writes the smallest result to the base file and arranges for
+// it to be stored under the winner's extension. It returns the winner.
+func (cf *compressedFile) writeSmallest() (Algorithm, int, error) {
+	config := cf.cfs.config
+	data := cf.writeBuffer.Bytes()
+
+	var best []byte
+	var bestAlgo Algorithm
+	var bestLevel int
+	baseline := -1
+
+	for _, algo := range config.TrySmallest {
+		level := cf.cfs.getDefaultLevel(algo)
+		if algo == cf.writeAlgo {
+			level = normalizeLevel(algo, cf.writeLevel)
+		}
+
+		var candidate bytes.Buffer
+		compressor, err := cf.cfs.newSizedCompressor(algo, level, &candidate, int64(len(data)))
+		if err != nil {
+			return "", 0, err
+		}
+		if _, err := compressor.Write(data); err != nil {
+			compressor.Close()
+			return "", 0, err
+		}
+		if err := compressor.Close(); err != nil {
+			return "", 0, err
+		}
+
+		if baseline < 0 {
+			baseline = candidate.Len()
+		}
+		if bestAlgo == "" || candidate.Len() < len(best) {
+			best = candidate.Bytes()
+			bestAlgo, bestLevel = algo, level
+		}
+	}
+
+	if _, err := (baseWriter{cf}).Write(best); err != nil {
+		return "", 0, err
+	}
+
+	addAlgorithmBytes(&cf.cfs.stats.TrySmallestWins, bestAlgo, 1)
+	atomic.AddInt64(&cf.cfs.stats.TrySmallestBytesSaved, int64(baseline-len(best)))
+
+	if cf.compressedName != cf.originalName {
+		if stored := cf.cfs.placeExtension(cf.originalName, bestAlgo, config.PreserveExtension); stored != cf.compressedName {
+			cf.renameTo = stored
+		}
+	}
+	return bestAlgo, bestLevel, nil
+}
@@ -0,0 +1,129 @@
+package compressfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// joinPrefix marks the temporary file a Join writes before moving it into
+// place. Like replacePrefix, it prefixes the base name so the name keeps its
+// extension.
+const joinPrefix = ".join-"
+
+// partName returns the logical name of part i of a split file
+func partName(name string, i int) string {
+	return fmt.Sprintf("%s.part%03d", name, i)
+}
+
+// Split decompresses the named file and writes its content to parts of
+// partSize decompressed bytes each, the last holding the rest. Each part is
+// an ordinary file compressed under the current config, named name.part000,
+// name.part001 and so on, stored as name.part000.gz with gzip. It returns
+// the logical names of the parts in order; an empty file has none. The
+// original is left in place, and parts are removed again if any fails.
+func (cfs *FS) Split(name string, partSize int64) ([]string, error) {
+	if partSize <= 0 {
+		return nil, &fs.PathError{Op: "split", Path: name, Err: fs.ErrInvalid}
+	}
+
+	src, err := cfs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	r := bufio.NewReaderSize(src, copyBufferSize)
+	var parts []string
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			return parts, nil
+		} else if err != nil {
+			cfs.removeFiles(parts)
+			return nil, err
+		}
+
+		part := partName(name, len(parts))
+		parts = append(parts, part)
+		if err := cfs.writePart(part, io.LimitReader(r, partSize)); err != nil {
+			cfs.removeFiles(parts)
+			return nil, err
+		}
+	}
+}
+
+// writePart writes everything read from r to a new file at name
+func (cfs *FS) writePart(name string, r io.Reader) error {
+	dst, err := cfs.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// removeFiles removes the parts written by a failed Split, or the
+// temporary file written by a failed Join
+func (cfs *FS) removeFiles(names []string) {
+	for _, name := range names {
+		cfs.Remove(name)
+	}
+}
+
+// Join writes the decompressed content of parts, in order, to a file at
+// name compressed under the current config, reversing Split. The parts are
+// left in place. The content is written to a temporary name and moved over
+// any existing file at name only once every part is copied, as Replace
+// does, so on failure an existing file is left as it was.
+func (cfs *FS) Join(name string, parts []string) error {
+	cfs.mu.RLock()
+	config := cfs.config
+	cfs.mu.RUnlock()
+
+	name = cleanPath(name)
+	dir, file := path.Split(name)
+	tmp := dir + joinPrefix + file
+
+	// Clear what an interrupted Join may have left behind
+	for _, leftover := range append(cfs.compressedVariants(tmp, config), tmp) {
+		cfs.base.Remove(leftover)
+	}
+
+	dst, err := cfs.Create(tmp)
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if err := cfs.appendPart(dst, part); err != nil {
+			dst.Close()
+			cfs.removeFiles([]string{tmp})
+			return err
+		}
+	}
+	if err := dst.Close(); err != nil {
+		cfs.removeFiles([]string{tmp})
+		return err
+	}
+
+	stored, ok := cfs.ResolveStoredName(tmp)
+	if !ok {
+		return &fs.PathError{Op: "join", Path: name, Err: fs.ErrNotExist}
+	}
+	return cfs.moveIntoPlace(name, tmp, stored, config)
+}
+
+// appendPart copies the decompressed content of part to dst
+func (cfs *FS) appendPart(dst io.Writer, part string) error {
+	src, err := cfs.Open(part)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
@@ -0,0 +1,91 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+// TestSplitJoin tests that Split writes compressed parts of the requested
+// size and that Join puts them back together
+func TestSplitJoin(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	data := cdcTestData(250 << 10)
+	writeTestFile(t, cfs, "big.log", data)
+
+	parts, err := cfs.Split("big.log", 100<<10)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	want := []string{"big.log.part000", "big.log.part001", "big.log.part002"}
+	if len(parts) != len(want) {
+		t.Fatalf("Split returned %v, expected %v", parts, want)
+	}
+	for i, part := range parts {
+		if part != want[i] {
+			t.Errorf("Part %d named %q, expected %q", i, part, want[i])
+		}
+		stored := readBaseFile(t, base, part+".gz")
+		if algo, ok := IsCompressed(stored); !ok || algo != AlgorithmGzip {
+			t.Errorf("Part %s is not stored gzip-compressed", part)
+		}
+		end := min((i+1)*(100<<10), len(data))
+		if got := readTestFile(t, cfs, part); !bytes.Equal(got, data[i*(100<<10):end]) {
+			t.Errorf("Part %s holds the wrong content", part)
+		}
+	}
+
+	if err := cfs.Join("joined.log", parts); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if got := readTestFile(t, cfs, "joined.log"); !bytes.Equal(got, data) {
+		t.Error("Joined file does not match the original")
+	}
+
+	if _, err := cfs.Split("big.log", 0); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Split with a zero part size = %v, expected fs.ErrInvalid", err)
+	}
+}
+
+// TestJoinExistingDestination tests that a failed Join leaves an existing
+// file at its destination intact and a successful one replaces it
+func TestJoinExistingDestination(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	original := cdcTestData(20 << 10)
+	writeTestFile(t, cfs, "dest.log", original)
+	writeTestFile(t, cfs, "a.log", []byte("first part "))
+	writeTestFile(t, cfs, "b.log", []byte("second part"))
+
+	if err := cfs.Join("dest.log", []string{"a.log", "missing.log"}); err == nil {
+		t.Fatal("Expected Join to fail on a missing part")
+	}
+	if got := readTestFile(t, cfs, "dest.log"); !bytes.Equal(got, original) {
+		t.Error("Failed Join changed the existing destination")
+	}
+
+	if err := cfs.Join("dest.log", []string{"a.log", "b.log"}); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if got := readTestFile(t, cfs, "dest.log"); string(got) != "first part second part" {
+		t.Errorf("Joined destination holds %q", got)
+	}
+	entries, err := base.ReadDir(".")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 3 {
+		for _, e := range entries {
+			t.Logf("Base holds %s", e.Name())
+		}
+		t.Error("Expected only dest.log and the two parts in the base filesystem")
+	}
+}
@@ -0,0 +1,66 @@
+package compressfs
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// subFiler exposes the subtree of base rooted at dir, resolving every name
+// below dir so that paths cannot escape it
+type subFiler struct {
+	base absfs.FileSystem
+	dir  string
+}
+
+// fullPath returns the base path for name within the subtree
+func (s *subFiler) fullPath(name string) string {
+	return path.Join(s.dir, path.Clean("/"+cleanPath(name)))
+}
+
+func (s *subFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	return s.base.OpenFile(s.fullPath(name), flag, perm)
+}
+
+func (s *subFiler) Mkdir(name string, perm os.FileMode) error {
+	return s.base.Mkdir(s.fullPath(name), perm)
+}
+
+func (s *subFiler) Remove(name string) error {
+	return s.base.Remove(s.fullPath(name))
+}
+
+func (s *subFiler) Rename(oldpath, newpath string) error {
+	return s.base.Rename(s.fullPath(oldpath), s.fullPath(newpath))
+}
+
+func (s *subFiler) Stat(name string) (os.FileInfo, error) {
+	return s.base.Stat(s.fullPath(name))
+}
+
+func (s *subFiler) Chmod(name string, mode os.FileMode) error {
+	return s.base.Chmod(s.fullPath(name), mode)
+}
+
+func (s *subFiler) Chtimes(name string, atime, mtime time.Time) error {
+	return s.base.Chtimes(s.fullPath(name), atime, mtime)
+}
+
+func (s *subFiler) Chown(name string, uid, gid int) error {
+	return s.base.Chown(s.fullPath(name), uid, gid)
+}
+
+func (s *subFiler) ReadDir(name string) ([]fs.DirEntry, error) {
+	return s.base.ReadDir(s.fullPath(name))
+}
+
+func (s *subFiler) ReadFile(name string) ([]byte, error) {
+	return s.base.ReadFile(s.fullPath(name))
+}
+
+func (s *subFiler) Sub(dir string) (fs.FS, error) {
+	return s.base.Sub(s.fullPath(dir))
+}
@@ -0,0 +1,179 @@
+package compressfs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// tailPollInterval is how long a tail reader waits at the end of the stored
+// file before checking for appended data
+const tailPollInterval = 20 * time.Millisecond
+
+// Tail opens the named file for reading and follows appends to it, like
+// tail -f: at the end of the stored data, reads block until more is written
+// or the reader is closed. Compressed files must grow by whole members or
+// frames, such as concatenated gzip members; decompressed data is returned
+// as each one arrives. The base must let an open file read data appended
+// after it reached EOF.
+func (cfs *FS) Tail(name string) (io.ReadCloser, error) {
+	cfs.mu.RLock()
+	config := cfs.config
+	cfs.mu.RUnlock()
+
+	name = cleanPath(name)
+	stored, algo, ok := cfs.resolveStored(name, config)
+	if !ok {
+		return nil, &fs.PathError{Op: "tail", Path: name, Err: fs.ErrNotExist}
+	}
+	if algo == "" && !cfs.shouldSkip(name) {
+		_, algo, _ = cfs.stripPlacedExtension(stored)
+	}
+
+	f, err := cfs.base.OpenFile(stored, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	follow := &followReader{file: f, done: make(chan struct{})}
+	if algo == "" {
+		return follow, nil
+	}
+
+	var dict []byte
+	if algo == AlgorithmZstd {
+		dict = config.ZstdDictionary
+	}
+	return &tailDecoder{follow: follow, open: func() (io.ReadCloser, error) {
+		if algo == AlgorithmGzip {
+			return &gzipMembers{r: bufio.NewReader(follow)}, nil
+		}
+		return cfs.newDecompressor(algo, follow, dict)
+	}}, nil
+}
+
+// gzipMembers decompresses concatenated gzip members one at a time. Unlike
+// a multistream gzip.Reader, it returns a member's data before looking for
+// the next header, which on a followed file may not have been written yet.
+type gzipMembers struct {
+	r    *bufio.Reader
+	z    *gzip.Reader
+	next bool // The current member is done; start the next on Read
+}
+
+func (g *gzipMembers) Read(p []byte) (int, error) {
+	for {
+		var err error
+		switch {
+		case g.z == nil:
+			g.z, err = gzip.NewReader(g.r)
+		case g.next:
+			err = g.z.Reset(g.r)
+		}
+		if err != nil {
+			return 0, err
+		}
+		g.z.Multistream(false)
+		g.next = false
+
+		n, err := g.z.Read(p)
+		if err == io.EOF {
+			g.next = true
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (g *gzipMembers) Close() error {
+	if g.z == nil {
+		return nil
+	}
+	return g.z.Close()
+}
+
+// followReader reads a base file and, at EOF, waits for it to grow instead
+// of returning io.EOF
+type followReader struct {
+	file   io.ReadCloser
+	done   chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+	closed bool
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return 0, fs.ErrClosed
+		}
+		n, err := r.file.Read(p)
+		r.mu.Unlock()
+
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		select {
+		case <-r.done:
+			return 0, fs.ErrClosed
+		case <-time.After(tailPollInterval):
+		}
+	}
+}
+
+// Close stops waiting for data and closes the base file. It may be called
+// while a Read is blocked.
+func (r *followReader) Close() error {
+	var err error
+	r.once.Do(func() {
+		close(r.done)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.closed = true
+		err = r.file.Close()
+	})
+	return err
+}
+
+// tailDecoder decompresses a followed file. The decoder is created on the
+// first Read, since most decoders read a header as soon as they are created.
+type tailDecoder struct {
+	follow  *followReader
+	open    func() (io.ReadCloser, error)
+	mu      sync.Mutex
+	decoder io.ReadCloser
+}
+
+func (t *tailDecoder) Read(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.decoder == nil {
+		decoder, err := t.open()
+		if err != nil {
+			return 0, err
+		}
+		t.decoder = decoder
+	}
+	return t.decoder.Read(p)
+}
+
+func (t *tailDecoder) Close() error {
+	// Unblock a pending Read so the decoder can be closed under the lock
+	err := t.follow.Close()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.decoder != nil {
+		t.decoder.Close()
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package compressfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// lockedFiler serializes reads and writes across all handles, since memFS
+// handles share file data without a common lock
+type lockedFiler struct {
+	absfs.Filer
+	mu sync.Mutex
+}
+
+type lockedFile struct {
+	absfs.File
+	filer *lockedFiler
+}
+
+func (l *lockedFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := l.Filer.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &lockedFile{File: f, filer: l}, nil
+}
+
+func (f *lockedFile) Read(p []byte) (int, error) {
+	f.filer.mu.Lock()
+	defer f.filer.mu.Unlock()
+	return f.File.Read(p)
+}
+
+func (f *lockedFile) Write(p []byte) (int, error) {
+	f.filer.mu.Lock()
+	defer f.filer.mu.Unlock()
+	return f.File.Write(p)
+}
+
+// TestTail tests that a tail reader yields each member as it is appended
+func TestTail(t *testing.T) {
+	base := &lockedFiler{Filer: NewMemFS()}
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	chunks := make([][]byte, 4)
+	for i := range chunks {
+		chunks[i] = []byte(fmt.Sprintf("log line %d: %s\n", i, bytes.Repeat([]byte{'a' + byte(i)}, 100*(i+1))))
+	}
+	appendMember := func(w io.Writer, data []byte) {
+		member, err := CompressBytes(data, AlgorithmGzip, 6)
+		if err != nil {
+			t.Errorf("CompressBytes failed: %v", err)
+			return
+		}
+		w.Write(member)
+	}
+
+	w, err := base.OpenFile("app.log.gz", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Create base file failed: %v", err)
+	}
+	defer w.Close()
+	appendMember(w, chunks[0])
+
+	tail, err := cfs.Tail("app.log")
+	if err != nil {
+		t.Fatalf("Tail failed: %v", err)
+	}
+
+	// Read each chunk on its own goroutine, since the reader blocks between members
+	got := make(chan []byte)
+	go func() {
+		defer close(got)
+		for _, chunk := range chunks {
+			buf := make([]byte, len(chunk))
+			if _, err := io.ReadFull(tail, buf); err != nil {
+				return
+			}
+			got <- buf
+		}
+	}()
+
+	for i, chunk := range chunks {
+		if i > 0 {
+			appendMember(w, chunk)
+		}
+		select {
+		case data, ok := <-got:
+			if !ok {
+				t.Fatalf("Tail reader stopped before chunk %d", i)
+			}
+			if !bytes.Equal(data, chunk) {
+				t.Errorf("Chunk %d: expected %q, got %q", i, chunk, data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for chunk %d", i)
+		}
+	}
+
+	// Close unblocks a reader waiting for the next member
+	done := make(chan error)
+	go func() {
+		_, err := tail.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(2 * tailPollInterval)
+	if err := tail.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error from a read interrupted by Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not unblock a pending read")
+	}
+}
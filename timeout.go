@@ -0,0 +1,87 @@
+package compressfs
+
+import (
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// readDeadliner is implemented by base files that can bound a blocking read
+// themselves, such as pipes and network connections behind an os.File
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// timeoutFile bounds each Read and ReadAt on a base file by a timeout. A base
+// with a working SetReadDeadline enforces the timeout itself; otherwise the
+// read runs on a watchdog goroutine into a private buffer. A read abandoned
+// by the watchdog still holds the base, so after a timeout every further
+// read fails with the same error.
+type timeoutFile struct {
+	absfs.File
+	timeout time.Duration
+
+	mu       sync.Mutex
+	deadline bool  // SetReadDeadline is supported
+	err      error // Sticky timeout from an abandoned read
+}
+
+// newTimeoutFile wraps f so that reads fail with os.ErrDeadlineExceeded
+// instead of blocking for longer than timeout
+func newTimeoutFile(f absfs.File, timeout time.Duration) *timeoutFile {
+	tf := &timeoutFile{File: f, timeout: timeout}
+	if d, ok := f.(readDeadliner); ok {
+		tf.deadline = d.SetReadDeadline(time.Time{}) == nil
+	}
+	return tf
+}
+
+func (f *timeoutFile) Read(p []byte) (int, error) {
+	return f.do("read", p, func(b []byte) (int, error) { return f.File.Read(b) })
+}
+
+func (f *timeoutFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.do("read", p, func(b []byte) (int, error) { return f.File.ReadAt(b, off) })
+}
+
+// do runs read against p within the timeout
+func (f *timeoutFile) do(op string, p []byte, read func([]byte) (int, error)) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	if f.deadline {
+		if err := f.File.(readDeadliner).SetReadDeadline(time.Now().Add(f.timeout)); err == nil {
+			return read(p)
+		}
+		f.deadline = false
+	}
+
+	type result struct {
+		buf []byte
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		buf := make([]byte, len(p))
+		n, err := read(buf)
+		done <- result{buf, n, err}
+	}()
+
+	timer := time.NewTimer(f.timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		copy(p, r.buf[:r.n])
+		return r.n, r.err
+	case <-timer.C:
+		f.err = &fs.PathError{Op: op, Path: f.File.Name(), Err: os.ErrDeadlineExceeded}
+		return 0, f.err
+	}
+}
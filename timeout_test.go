@@ -0,0 +1,80 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// blockingFiler returns files whose reads block until release is closed,
+// like a hung network filesystem
+type blockingFiler struct {
+	absfs.Filer
+	release chan struct{}
+}
+
+type blockingFile struct {
+	absfs.File
+	release chan struct{}
+}
+
+func (b *blockingFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	f, err := b.Filer.OpenFile(name, flag, perm)
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return f, err
+	}
+	return &blockingFile{File: f, release: b.release}, nil
+}
+
+func (f *blockingFile) Read(p []byte) (int, error) {
+	<-f.release
+	return f.File.Read(p)
+}
+
+// TestReadTimeout tests that a read from a hung base fails after ReadTimeout
+func TestReadTimeout(t *testing.T) {
+	mem := NewMemFS()
+	writer, err := New(mem, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	data := bytes.Repeat([]byte("stuck behind a slow network "), 100)
+	writeTestFile(t, writer, "slow.txt", data)
+
+	base := &blockingFiler{Filer: mem, release: make(chan struct{})}
+	defer close(base.release)
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		ReadTimeout:       20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	f, err := cfs.Open("slow.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(f)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Read error = %v, expected os.ErrDeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read blocked despite ReadTimeout")
+	}
+}
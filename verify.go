@@ -0,0 +1,75 @@
+package compressfs
+
+import (
+	"fmt"
+	"io"
+)
+
+// copyBufferSize is the buffer size used when streaming file contents
+const copyBufferSize = 32 * 1024
+
+// ValidateFile reads the named file to EOF through its decompressor so that
+// trailing checksums, such as the gzip CRC-32 and size, are verified. Callers
+// that read only part of a stream and close it never reach these checks.
+// Decode and checksum failures are reported wrapping ErrCorruptedData.
+func (cfs *FS) ValidateFile(name string) error {
+	_, err := cfs.validateTo(name, io.Discard)
+	return err
+}
+
+// VerifyIntegrity checks that the named file decompresses cleanly, streaming
+// it through its decompressor and discarding the output as ValidateFile
+// does. A decode or checksum failure partway through wraps ErrCorruptedData
+// around the decoder's error.
+func (cfs *FS) VerifyIntegrity(name string) error {
+	return cfs.ValidateFile(name)
+}
+
+// VerifyAll verifies every file stored under dir and returns each result
+// keyed by the file's logical path, nil for files that decompressed cleanly.
+// The returned error is only set when the directory walk fails.
+func (cfs *FS) VerifyAll(dir string) (map[string]error, error) {
+	results := make(map[string]error)
+	err := cfs.walkBase(cleanPath(dir), func(name string) error {
+		logical := name
+		if stripped, _, ok := cfs.stripPlacedExtension(name); ok {
+			logical = stripped
+		}
+		if _, done := results[logical]; !done {
+			results[logical] = cfs.VerifyIntegrity(logical)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// validateTo streams the decompressed content of the named file into w
+// through a single copyBufferSize buffer, so memory use does not depend on
+// the file size. It returns the number of bytes decompressed.
+func (cfs *FS) validateTo(name string, w io.Writer) (int64, error) {
+	f, err := cfs.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, copyBufferSize)
+	n, err := io.CopyBuffer(onlyWriter{w}, onlyReader{f}, buf)
+	if err != nil {
+		return n, fmt.Errorf("%w: %s: %w", ErrCorruptedData, name, err)
+	}
+	return n, nil
+}
+
+// onlyReader and onlyWriter hide any WriterTo or ReaderFrom so
+// io.CopyBuffer uses the supplied buffer
+type onlyReader struct {
+	io.Reader
+}
+
+type onlyWriter struct {
+	io.Writer
+}
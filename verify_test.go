@@ -0,0 +1,169 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// TestValidateFile tests that full reads catch corruption a partial read misses
+func TestValidateFile(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("checksummed payload "), 1000)
+	writeTestFile(t, cfs, "good.txt", data)
+	if err := cfs.ValidateFile("good.txt"); err != nil {
+		t.Errorf("Expected intact file to validate, got %v", err)
+	}
+
+	// Flip a bit in the CRC-32 trailer
+	stored := readBaseFile(t, base, "good.txt.gz")
+	stored[len(stored)-8] ^= 0xff
+	writeBaseFile(t, base, "bad.txt.gz", stored)
+
+	f, err := cfs.Open("bad.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := f.Read(make([]byte, 64)); err != nil {
+		t.Errorf("Partial read should not see the trailer, got %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("Close after partial read failed: %v", err)
+	}
+
+	if err := cfs.ValidateFile("bad.txt"); !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("Expected ErrCorruptedData, got %v", err)
+	}
+}
+
+// TestVerifyAll tests that a directory scrub reports each logical file,
+// with corruption caught for both gzip and zstd checksums
+func TestVerifyAll(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{Algorithm: AlgorithmGzip, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("scrubbed payload "), 1000)
+	writeTestFile(t, cfs, "a.txt", data)
+	writeBaseFile(t, base, "/plain.txt", []byte("stored verbatim"))
+
+	stored := readBaseFile(t, base, "a.txt.gz")
+	stored[len(stored)-8] ^= 0xff
+	writeBaseFile(t, base, "/b.txt.gz", stored)
+
+	zstdBytes, err := CompressBytes(data, AlgorithmZstd, 3)
+	if err != nil {
+		t.Fatalf("CompressBytes failed: %v", err)
+	}
+	zstdBytes[len(zstdBytes)-1] ^= 0xff // Content checksum
+	writeBaseFile(t, base, "/c.txt.zst", zstdBytes)
+
+	results, err := cfs.VerifyAll("/")
+	if err != nil {
+		t.Fatalf("VerifyAll failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Errorf("VerifyAll returned %d results, expected 4: %v", len(results), results)
+	}
+	for _, name := range []string{"/a.txt", "/plain.txt"} {
+		if err, ok := results[name]; !ok || err != nil {
+			t.Errorf("%s: got %v (reported %v), expected a clean result", name, err, ok)
+		}
+	}
+	for _, name := range []string{"/b.txt", "/c.txt"} {
+		if err := results[name]; !errors.Is(err, ErrCorruptedData) {
+			t.Errorf("%s: got %v, expected ErrCorruptedData", name, err)
+		}
+	}
+
+	if err := cfs.VerifyIntegrity("c.txt"); !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("VerifyIntegrity through the logical name = %v, expected ErrCorruptedData", err)
+	}
+}
+
+// patternReader produces an endless repeating pattern without allocating
+type patternReader struct {
+	pattern []byte
+	off     int
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.pattern[r.off]
+		r.off = (r.off + 1) % len(r.pattern)
+	}
+	return len(p), nil
+}
+
+// countingDiscard counts and drops everything written to it
+type countingDiscard struct {
+	n int64
+}
+
+func (w *countingDiscard) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// TestValidateFileStreams tests that validation decompresses a file much
+// larger than the memory it uses
+func TestValidateFileStreams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large stream in short mode")
+	}
+
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             1,
+		PreserveExtension: true,
+		StripExtension:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	const size = 64 << 20
+	f, err := cfs.Create("large.log")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	src := io.LimitReader(&patternReader{pattern: []byte("2026-01-01 INFO request served\n")}, size)
+	if _, err := io.Copy(f, src); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	var sink countingDiscard
+	n, err := cfs.validateTo("large.log", &sink)
+	if err != nil {
+		t.Fatalf("validateTo failed: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if n != size || sink.n != size {
+		t.Errorf("Validated %d bytes (%d written), expected %d", n, sink.n, size)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/16 {
+		t.Errorf("Validation allocated %d bytes for a %d byte file", allocated, size)
+	}
+}
@@ -0,0 +1,555 @@
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/absfs/absfs"
+)
+
+// readTestFile reads a file back through the compressed filesystem
+func readTestFile(t *testing.T, cfs *FS, name string) []byte {
+	t.Helper()
+	f, err := cfs.Open(name)
+	if err != nil {
+		t.Fatalf("Open %s failed: %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("Read %s failed: %v", name, err)
+	}
+	return data
+}
+
+// TestStreamingSample tests that the head sample decides compression for the whole stream
+func TestStreamingSample(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		Level:             6,
+		PreserveExtension: true,
+		StripExtension:    true,
+		BufferSize:        4096,
+		StreamingSample:   true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 64*1024)
+	rng.Read(random)
+	compressible := bytes.Repeat([]byte("compressible head "), 1024)
+
+	// Compressible head followed by random data: compressed as a whole
+	headFirst := append(append([]byte{}, compressible...), random...)
+	f, _ := cfs.Create("mixed.txt")
+	for off := 0; off < len(headFirst); off += 1000 {
+		end := off + 1000
+		if end > len(headFirst) {
+			end = len(headFirst)
+		}
+		f.Write(headFirst[off:end])
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if _, err := base.Stat("mixed.txt.gz"); err != nil {
+		t.Errorf("Expected mixed.txt.gz to be stored compressed: %v", err)
+	}
+	if got := readTestFile(t, cfs, "mixed.txt"); !bytes.Equal(got, headFirst) {
+		t.Error("Compressed stream did not round-trip")
+	}
+
+	// Random head followed by compressible data: stored raw as a whole
+	randomFirst := append(append([]byte{}, random...), compressible...)
+	writeTestFile(t, cfs, "noise.bin", randomFirst)
+
+	if _, err := base.Stat("noise.bin.gz"); err == nil {
+		t.Error("Incompressible head should not be stored with .gz extension")
+	}
+	if info, err := base.Stat("noise.bin"); err != nil || info.Size() != int64(len(randomFirst)) {
+		t.Errorf("Expected raw noise.bin of %d bytes: %v", len(randomFirst), err)
+	}
+	if got := readTestFile(t, cfs, "noise.bin"); !bytes.Equal(got, randomFirst) {
+		t.Error("Raw stream did not round-trip")
+	}
+
+	stats := cfs.GetStats()
+	if stats.FilesCompressed != 1 || stats.FilesSkipped != 1 {
+		t.Errorf("Expected 1 compressed and 1 skipped, got %d and %d", stats.FilesCompressed, stats.FilesSkipped)
+	}
+}
+
+// syncRecordingFiler wraps a Filer and counts Sync calls on opened files
+type syncRecordingFiler struct {
+	absfs.Filer
+	syncs int64
+}
+
+type syncRecordingFile struct {
+	absfs.File
+	filer *syncRecordingFiler
+}
+
+func (s *syncRecordingFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	f, err := s.Filer.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &syncRecordingFile{File: f, filer: s}, nil
+}
+
+func (f *syncRecordingFile) Sync() error {
+	atomic.AddInt64(&f.filer.syncs, 1)
+	return f.File.Sync()
+}
+
+// TestSyncOnClose tests that written files are synced exactly once on close
+func TestSyncOnClose(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		base := &syncRecordingFiler{Filer: NewMemFS()}
+		cfs, err := New(base, &Config{
+			Algorithm:   AlgorithmZstd,
+			SyncOnClose: enabled,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+
+		writeTestFile(t, cfs, "durable.txt", bytes.Repeat([]byte("durable "), 100))
+
+		want := int64(0)
+		if enabled {
+			want = 1
+		}
+		if got := atomic.LoadInt64(&base.syncs); got != want {
+			t.Errorf("SyncOnClose=%v: expected %d Sync calls, got %d", enabled, want, got)
+		}
+	}
+}
+
+// TestWriteStringByteCount tests that writes report consumed input bytes
+func TestWriteStringByteCount(t *testing.T) {
+	text := strings.Repeat("héllo wörld, 你好世界 — ünïcödé ", 400)
+
+	for _, sample := range []bool{false, true} {
+		base := NewMemFS()
+		cfs, err := New(base, &Config{
+			Algorithm:         AlgorithmZstd,
+			PreserveExtension: true,
+			StripExtension:    true,
+			BufferSize:        1024,
+			StreamingSample:   sample,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create compressfs: %v", err)
+		}
+
+		f, err := cfs.Create("unicode.txt")
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			n, err := f.WriteString(text)
+			if err != nil {
+				t.Fatalf("WriteString failed: %v", err)
+			}
+			if n != len(text) {
+				t.Errorf("StreamingSample=%v: expected n=%d, got %d", sample, len(text), n)
+			}
+		}
+		f.Close()
+
+		if got := readTestFile(t, cfs, "unicode.txt"); string(got) != strings.Repeat(text, 3) {
+			t.Errorf("StreamingSample=%v: content did not round-trip", sample)
+		}
+	}
+}
+
+// TestCopyBulk tests that io.Copy into and out of a file takes the
+// ReadFrom and WriteTo path and round-trips the content
+func TestCopyBulk(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{Algorithm: AlgorithmZstd, PreserveExtension: true, StripExtension: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	data := cdcTestData(1 << 20)
+
+	f, err := cfs.Create("copy.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, ok := f.(io.ReaderFrom); !ok {
+		t.Fatal("File does not implement io.ReaderFrom")
+	}
+	if n, err := io.Copy(f, strings.NewReader(string(data))); err != nil || n != int64(len(data)) {
+		t.Fatalf("io.Copy into the file = %d, %v", n, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	f, err = cfs.Open("copy.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	if _, ok := f.(io.WriterTo); !ok {
+		t.Fatal("File does not implement io.WriterTo")
+	}
+	var out bytes.Buffer
+	if n, err := io.Copy(&out, f); err != nil || n != int64(len(data)) {
+		t.Fatalf("io.Copy out of the file = %d, %v", n, err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("Content did not round-trip through io.Copy")
+	}
+}
+
+// TestStreamingSampleMinSize tests MinSize boundaries around the head buffer
+func TestStreamingSampleMinSize(t *testing.T) {
+	const head = 1024
+	newFS := func(minSize int64) (absfs.Filer, *FS, error) {
+		base := NewMemFS()
+		cfs, err := New(base, &Config{
+			Algorithm:         AlgorithmGzip,
+			Level:             6,
+			PreserveExtension: true,
+			StripExtension:    true,
+			BufferSize:        head,
+			MinSize:           minSize,
+			StreamingSample:   true,
+		})
+		return base, cfs, err
+	}
+
+	if _, _, err := newFS(head + 1); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for MinSize above head buffer, got %v", err)
+	}
+
+	base, cfs, err := newFS(head)
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		size       int
+		compressed bool
+	}{
+		{"below.txt", head - 1, false},
+		{"exact.txt", head, true},
+		{"above.txt", head + 1, true},
+	}
+
+	for _, tt := range tests {
+		data := bytes.Repeat([]byte("z"), tt.size)
+		writeTestFile(t, cfs, tt.name, data)
+
+		_, err := base.Stat(tt.name + ".gz")
+		if (err == nil) != tt.compressed {
+			t.Errorf("%s (%d bytes): compressed=%v, want %v", tt.name, tt.size, err == nil, tt.compressed)
+		}
+		if got := readTestFile(t, cfs, tt.name); !bytes.Equal(got, data) {
+			t.Errorf("%s did not round-trip", tt.name)
+		}
+	}
+}
+
+// TestTrySmallest tests that the smallest candidate encoding is stored
+func TestTrySmallest(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmLZ4,
+		PreserveExtension: true,
+		StripExtension:    true,
+		TrySmallest:       []Algorithm{AlgorithmLZ4, AlgorithmBrotli},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	var text strings.Builder
+	for i := 0; i < 2000; i++ {
+		text.WriteString("line ")
+		text.WriteString(strings.Repeat("ab", i%17))
+		text.WriteString(" of archival text\n")
+	}
+	data := []byte(text.String())
+	writeTestFile(t, cfs, "archive.txt", data)
+
+	if _, err := base.Stat("archive.txt.br"); err != nil {
+		t.Fatalf("Expected archive.txt.br to be stored: %v", err)
+	}
+	if _, err := base.Stat("archive.txt.lz4"); err == nil {
+		t.Error("Losing lz4 encoding should not be kept")
+	}
+	if got := readTestFile(t, cfs, "archive.txt"); !bytes.Equal(got, data) {
+		t.Error("Content did not round-trip")
+	}
+
+	stats := cfs.GetStats()
+	if stats.GetTrySmallestWins(AlgorithmBrotli) != 1 {
+		t.Errorf("Expected one brotli win, got %d", stats.GetTrySmallestWins(AlgorithmBrotli))
+	}
+	if stats.TrySmallestBytesSaved <= 0 {
+		t.Errorf("Expected positive savings over lz4, got %d", stats.TrySmallestBytesSaved)
+	}
+
+	// Concurrent closes all count their win
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f, err := cfs.Create(fmt.Sprintf("copy%d.txt", i))
+			if err != nil {
+				t.Errorf("Create failed: %v", err)
+				return
+			}
+			f.Write(data)
+			if err := f.Close(); err != nil {
+				t.Errorf("Close failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if wins := cfs.GetStats().GetTrySmallestWins(AlgorithmBrotli); wins != 17 {
+		t.Errorf("Expected 17 brotli wins, got %d", wins)
+	}
+
+	// An explicit level 0 is kept: gzip stores the data
+	stored, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		LevelSet:          true,
+		PreserveExtension: true,
+		StripExtension:    true,
+		TrySmallest:       []Algorithm{AlgorithmGzip},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+	writeTestFile(t, stored, "stored.txt", data)
+	if size := len(readBaseFile(t, base, "stored.txt.gz")); size < len(data) {
+		t.Errorf("Level 0 gzip stored %d bytes of %d, expected no compression", size, len(data))
+	}
+
+	if _, err := New(NewMemFS(), &Config{TrySmallest: []Algorithm{"bogus"}}); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
+
+// failingWriteFiler fails every Write to files whose name contains "bad"
+type failingWriteFiler struct {
+	absfs.Filer
+}
+
+type failingWriteFile struct {
+	absfs.File
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (f *failingWriteFiler) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, err := f.Filer.OpenFile(name, flag, perm)
+	if err != nil || !strings.Contains(name, "bad") {
+		return file, err
+	}
+	return &failingWriteFile{File: file}, nil
+}
+
+func (f *failingWriteFile) Write(p []byte) (int, error) {
+	return 0, errWriteFailed
+}
+
+// TestClosedAfterFailedClose tests that every operation on a file whose
+// Close failed to flush returns fs.ErrClosed, not an error from the codec or
+// the half-written base file
+func TestClosedAfterFailedClose(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmGzip, AlgorithmZstd, AlgorithmLZ4, AlgorithmBrotli, AlgorithmSnappy, AlgorithmXz, AlgorithmZlib} {
+		for _, size := range []int{1 << 10, 4 << 20} {
+			t.Run(fmt.Sprintf("%s/%d", algo, size), func(t *testing.T) {
+				cfs, err := New(&failingWriteFiler{Filer: NewMemFS()}, &Config{Algorithm: algo, PreserveExtension: true, StripExtension: true})
+				if err != nil {
+					t.Fatalf("Failed to create compressfs: %v", err)
+				}
+
+				f, err := cfs.Create("bad.txt")
+				if err != nil {
+					t.Fatalf("Create failed: %v", err)
+				}
+				f.Write(cdcTestData(size))
+				if err := f.Close(); !errors.Is(err, errWriteFailed) {
+					t.Fatalf("Close = %v, expected the injected write error", err)
+				}
+
+				buf := make([]byte, 16)
+				ops := map[string]error{}
+				_, ops["Write"] = f.Write(buf)
+				_, ops["WriteString"] = f.WriteString("more")
+				_, ops["Read"] = f.Read(buf)
+				_, ops["Seek"] = f.Seek(0, io.SeekStart)
+				ops["Sync"] = f.Sync()
+				_, ops["ReadAt"] = f.ReadAt(buf, 0)
+				_, ops["WriteAt"] = f.WriteAt(buf, 0)
+				ops["Truncate"] = f.Truncate(0)
+				ops["Flush"] = f.(interface{ Flush() error }).Flush()
+				_, ops["ReadFrom"] = f.(io.ReaderFrom).ReadFrom(bytes.NewReader(buf))
+				_, ops["WriteTo"] = f.(io.WriterTo).WriteTo(io.Discard)
+				for op, err := range ops {
+					if !errors.Is(err, fs.ErrClosed) {
+						t.Errorf("%s after Close = %v, expected fs.ErrClosed", op, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestAsyncCompress tests that closes compressed in the background are
+// complete after Wait and report errors through the callback
+func TestAsyncCompress(t *testing.T) {
+	var mu sync.Mutex
+	failed := make(map[string]error)
+
+	base := &failingWriteFiler{Filer: NewMemFS()}
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmZstd,
+		PreserveExtension: true,
+		StripExtension:    true,
+		AsyncCompress:     true,
+		OnAsyncError: func(name string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed[name] = err
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	const files = 50
+	content := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("async file %d ", i)), 200+i)
+	}
+	for i := 0; i < files; i++ {
+		writeTestFile(t, cfs, fmt.Sprintf("async%d.txt", i), content(i))
+	}
+	writeTestFile(t, cfs, "bad.txt", content(0))
+	cfs.Wait()
+
+	for i := 0; i < files; i++ {
+		name := fmt.Sprintf("async%d.txt", i)
+		if got := readTestFile(t, cfs, name); !bytes.Equal(got, content(i)) {
+			t.Errorf("%s did not round-trip", name)
+		}
+	}
+	if stats := cfs.GetStats(); stats.FilesCompressed != files {
+		t.Errorf("Expected %d files compressed, got %d", files, stats.FilesCompressed)
+	}
+
+	if len(failed) != 1 || !errors.Is(failed["bad.txt"], errWriteFailed) {
+		t.Errorf("Expected only bad.txt to fail with the write error, got %v", failed)
+	}
+}
+
+// TestStreamingByDefault tests that writes past the size decisions stream
+// to the base instead of accumulating in memory
+func TestStreamingByDefault(t *testing.T) {
+	base := NewMemFS()
+	cfs, err := New(base, &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		MinSize:           1024,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	chunk := bytes.Repeat([]byte("streamed chunk "), 256)
+	f, err := cfs.Create("large.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	cf := f.(*compressedFile)
+	var want []byte
+	for i := 0; i < 256; i++ {
+		f.Write(chunk)
+		want = append(want, chunk...)
+		if cf.writeBuffer.Len() > len(chunk) {
+			t.Fatalf("Write %d: %d bytes buffered, expected at most one chunk", i, cf.writeBuffer.Len())
+		}
+	}
+	if cf.compressor == nil {
+		t.Fatal("Expected a streaming compressor once MinSize was reached")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if got := readTestFile(t, cfs, "large.txt"); !bytes.Equal(got, want) {
+		t.Error("Streamed file did not round-trip")
+	}
+
+	// A file that never reaches MinSize is still stored raw
+	writeTestFile(t, cfs, "small.txt", []byte("under the minimum"))
+	if _, err := base.Stat("small.txt"); err != nil {
+		t.Errorf("Expected small.txt stored uncompressed: %v", err)
+	}
+	if _, err := base.Stat("small.txt.gz"); err == nil {
+		t.Error("small.txt should not be compressed")
+	}
+}
+
+// TestCompressionRatio tests that the ratio is unknown until Close and then
+// reflects the stored size
+func TestCompressionRatio(t *testing.T) {
+	cfs, err := New(NewMemFS(), &Config{
+		Algorithm:         AlgorithmGzip,
+		PreserveExtension: true,
+		StripExtension:    true,
+		MinSize:           100,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create compressfs: %v", err)
+	}
+
+	f, err := cfs.Create("ratio.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	cf := f.(*compressedFile)
+	f.Write(bytes.Repeat([]byte("a"), 100000))
+	if ratio := cf.CompressionRatio(); ratio != -1 {
+		t.Errorf("Ratio before Close = %v, expected -1", ratio)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if ratio := cf.CompressionRatio(); ratio <= 0 || ratio > 0.01 {
+		t.Errorf("Ratio after Close = %v, expected well below 1", ratio)
+	}
+
+	// Below MinSize the file is stored as written
+	f, err = cfs.Create("small.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("tiny"))
+	f.Close()
+	if ratio := f.(*compressedFile).CompressionRatio(); ratio != 1 {
+		t.Errorf("Uncompressed ratio = %v, expected 1", ratio)
+	}
+}